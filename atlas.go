@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gl/mathgl/mgl32"
+	"image"
+	"sort"
+)
+
+// atlasPadding is the number of pixels that every tile is surrounded with.
+// The padding repeats the tile so that filtering and mipmapping at tile
+// edges sample the tile itself rather than its neighbours.
+const atlasPadding = 4
+
+// Atlas packs many small images into a few large pages so that they can be
+// drawn without switching textures.
+type Atlas struct {
+	Pages   []*image.RGBA
+	entries map[string]AtlasEntry
+}
+
+// AtlasEntry describes where an image is located in an atlas. The rect
+// holds the offset and size of the image in texture coordinates of its
+// page.
+type AtlasEntry struct {
+	Page int
+	Rect mgl32.Vec4
+}
+
+// Lookup returns the atlas entry of a named image.
+func (atlas *Atlas) Lookup(name string) (AtlasEntry, bool) {
+	entry, ok := atlas.entries[name]
+	return entry, ok
+}
+
+// NewAtlas packs images into pages of the given width and at most the given
+// height using a simple shelf packer.
+func NewAtlas(images map[string]*image.RGBA, pageSize int) (*Atlas, error) {
+	names := []string{}
+	for name := range images {
+		names = append(names, name)
+	}
+	// Tallest images first keeps the shelves tight; names break ties so
+	// that the layout is deterministic.
+	sort.Slice(names, func(i, j int) bool {
+		hi := images[names[i]].Rect.Dy()
+		hj := images[names[j]].Rect.Dy()
+		if hi != hj {
+			return hi > hj
+		}
+		return names[i] < names[j]
+	})
+
+	type placement struct {
+		name string
+		page int
+		x    int
+		y    int
+	}
+	placements := []placement{}
+	heights := []int{}
+	page, x, y, shelfHeight := 0, 0, 0, 0
+	for _, name := range names {
+		size := images[name].Rect.Size()
+		w := size.X + 2*atlasPadding
+		h := size.Y + 2*atlasPadding
+		if w > pageSize || h > pageSize {
+			return nil, fmt.Errorf("image %s (%dx%d) does not fit in a %dx%d atlas", name, size.X, size.Y, pageSize, pageSize)
+		}
+		if x+w > pageSize {
+			x = 0
+			y += shelfHeight
+			shelfHeight = 0
+		}
+		if y+h > pageSize {
+			heights = append(heights, y)
+			page++
+			x, y, shelfHeight = 0, 0, 0
+		}
+		placements = append(placements, placement{name: name, page: page, x: x + atlasPadding, y: y + atlasPadding})
+		x += w
+		if h > shelfHeight {
+			shelfHeight = h
+		}
+	}
+	heights = append(heights, y+shelfHeight)
+
+	atlas := &Atlas{entries: make(map[string]AtlasEntry)}
+	for _, height := range heights {
+		atlas.Pages = append(atlas.Pages, image.NewRGBA(image.Rect(0, 0, pageSize, height)))
+	}
+	for _, p := range placements {
+		img := images[p.name]
+		dst := atlas.Pages[p.page]
+		blitWrapped(dst, img, p.x, p.y)
+		size := img.Rect.Size()
+		pageWidth := float32(dst.Rect.Dx())
+		pageHeight := float32(dst.Rect.Dy())
+		atlas.entries[p.name] = AtlasEntry{
+			Page: p.page,
+			Rect: mgl32.Vec4{
+				float32(p.x) / pageWidth,
+				float32(p.y) / pageHeight,
+				float32(size.X) / pageWidth,
+				float32(size.Y) / pageHeight,
+			},
+		}
+	}
+	return atlas, nil
+}
+
+// blitWrapped copies an image into a page at the given position and fills
+// the surrounding padding by repeating the image.
+func blitWrapped(dst *image.RGBA, src *image.RGBA, x0 int, y0 int) {
+	size := src.Rect.Size()
+	for dy := -atlasPadding; dy < size.Y+atlasPadding; dy++ {
+		sy := (dy%size.Y + size.Y) % size.Y
+		for dx := -atlasPadding; dx < size.X+atlasPadding; dx++ {
+			sx := (dx%size.X + size.X) % size.X
+			srcOffset := src.PixOffset(src.Rect.Min.X+sx, src.Rect.Min.Y+sy)
+			dstOffset := dst.PixOffset(x0+dx, y0+dy)
+			copy(dst.Pix[dstOffset:dstOffset+4], src.Pix[srcOffset:srcOffset+4])
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/wad"
+	"math"
+)
+
+const (
+	automapVertex = `#version 330
+
+layout(location = 0) in vec2 vertex;
+layout(location = 1) in vec3 vertColor;
+
+uniform mat4 MVP;
+
+out vec3 fragColor;
+
+void main()
+{
+    fragColor = vertColor;
+    gl_Position = MVP * vec4(vertex, 0.0, 1.0);
+}` + "\x00"
+
+	automapFragment = `#version 330
+
+in vec3 fragColor;
+
+out vec4 outColor;
+
+void main()
+{
+    outColor = vec4(fragColor, 1.0);
+}` + "\x00"
+)
+
+const (
+	automapStride      = 5
+	automapDefaultZoom = 1024.0
+	automapMinZoom     = 64.0
+	automapMaxZoom     = 16384.0
+	automapArrowSize   = 32.0
+)
+
+var (
+	automapWallColor          = mgl32.Vec3{1.0, 0.0, 0.0}
+	automapFloorChangeColor   = mgl32.Vec3{0.55, 0.35, 0.2}
+	automapCeilingChangeColor = mgl32.Vec3{1.0, 1.0, 0.0}
+	automapTwoSidedColor      = mgl32.Vec3{0.5, 0.5, 0.5}
+	automapBlockingColor      = mgl32.Vec3{1.0, 0.5, 0.0}
+	automapThingColor         = mgl32.Vec3{0.0, 1.0, 0.0}
+	automapPlayerColor        = mgl32.Vec3{1.0, 1.0, 1.0}
+)
+
+// Automap is a top-down view of the level that is drawn with an
+// orthographic projection directly in map coordinates.
+type Automap struct {
+	program    uint32
+	matrixID   int32
+	vao        uint32
+	vbo        uint32
+	lineCount  int
+	thingCount int
+	playerVao  uint32
+	playerVbo  uint32
+	pan        mgl32.Vec2
+	zoom       float32
+	blockmap   *wad.Blockmap // Finds the lines in view, nil to draw all lines.
+	lineFirst  []int         // First vertex of every linedef, -1 for hidden lines.
+	ebo        uint32        // Indices of the vertices of the lines in view.
+}
+
+// NewAutomap uploads the linedefs of a level and the things present on the
+// skill level of the game for automap rendering.
+func NewAutomap(level *wad.Level, gameSettings *GameSettings) (*Automap, error) {
+	program, err := newProgram(automapVertex, automapFragment)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []float32{}
+	lineFirst := make([]int, len(level.Linedefs))
+	for i, linedef := range level.Linedefs {
+		color, visible := automapLineColor(level, &linedef)
+		if !visible {
+			lineFirst[i] = -1
+			continue
+		}
+		lineFirst[i] = len(lines) / automapStride
+		start := level.Vertexes[linedef.VertexStart]
+		end := level.Vertexes[linedef.VertexEnd]
+		lines = appendAutomapVertex(lines, float32(start.XCoord), float32(start.YCoord), color)
+		lines = appendAutomapVertex(lines, float32(end.XCoord), float32(end.YCoord), color)
+	}
+	lineCount := len(lines) / automapStride
+	for _, thing := range level.Things {
+		if !gameSettings.SpawnsThing(&thing) {
+			continue
+		}
+		lines = appendAutomapVertex(lines, float32(thing.XPosition), float32(thing.YPosition), automapThingColor)
+	}
+	thingCount := len(lines)/automapStride - lineCount
+
+	automap := &Automap{
+		program:    program,
+		matrixID:   gl.GetUniformLocation(program, gl.Str("MVP\x00")),
+		lineCount:  lineCount,
+		thingCount: thingCount,
+		zoom:       automapDefaultZoom,
+		blockmap:   level.Blockmap,
+		lineFirst:  lineFirst,
+	}
+	automap.vao, automap.vbo = newAutomapBuffer(lines, gl.STATIC_DRAW)
+	gl.GenBuffers(1, &automap.ebo)
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, automap.ebo)
+	automap.playerVao, automap.playerVbo = newAutomapBuffer(nil, gl.STREAM_DRAW)
+	return automap, nil
+}
+
+// Delete releases the shader program and the vertex buffers of the
+// automap.
+func (automap *Automap) Delete() {
+	gl.DeleteProgram(automap.program)
+	for _, vao := range []uint32{automap.vao, automap.playerVao} {
+		gl.DeleteVertexArrays(1, &vao)
+	}
+	for _, buffer := range []uint32{automap.vbo, automap.playerVbo, automap.ebo} {
+		gl.DeleteBuffers(1, &buffer)
+	}
+}
+
+// automapLineColor returns the color of a linedef on the automap following
+// the vanilla color scheme: one-sided and secret lines are drawn as walls,
+// and two-sided lines are colored by the height change between their
+// sectors. Impassable two-sided lines get a color of their own. The second
+// return value is false for lines that are hidden from the automap.
+func automapLineColor(level *wad.Level, linedef *wad.Linedef) (mgl32.Vec3, bool) {
+	if linedef.Flags&wad.LinedefNotOnMap != 0 {
+		return mgl32.Vec3{}, false
+	}
+	if linedef.SidedefLeft == -1 || linedef.Flags&wad.LinedefSecret != 0 {
+		return automapWallColor, true
+	}
+	if linedef.Flags&wad.LinedefBlocking != 0 {
+		return automapBlockingColor, true
+	}
+	front := level.Sectors[level.Sidedefs[linedef.SidedefRight].SectorRef]
+	back := level.Sectors[level.Sidedefs[linedef.SidedefLeft].SectorRef]
+	if front.FloorHeight != back.FloorHeight {
+		return automapFloorChangeColor, true
+	}
+	if front.CeilingHeight != back.CeilingHeight {
+		return automapCeilingChangeColor, true
+	}
+	return automapTwoSidedColor, true
+}
+
+func appendAutomapVertex(data []float32, x float32, y float32, color mgl32.Vec3) []float32 {
+	return append(data, x, y, color.X(), color.Y(), color.Z())
+}
+
+func newAutomapBuffer(data []float32, usage uint32) (uint32, uint32) {
+	var vao uint32
+	gl.GenVertexArrays(1, &vao)
+	gl.BindVertexArray(vao)
+
+	var vbo uint32
+	gl.GenBuffers(1, &vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+	if len(data) > 0 {
+		gl.BufferData(gl.ARRAY_BUFFER, len(data)*4, gl.Ptr(data), usage)
+	}
+
+	vertexAttrib := uint32(0)
+	gl.VertexAttribPointer(vertexAttrib, 2, gl.FLOAT, false, automapStride*4, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(vertexAttrib)
+
+	colorAttrib := uint32(1)
+	gl.VertexAttribPointer(colorAttrib, 3, gl.FLOAT, false, automapStride*4, gl.PtrOffset(2*4))
+	gl.EnableVertexAttribArray(colorAttrib)
+
+	return vao, vbo
+}
+
+// Pan moves the automap view by the given number of screens.
+func (automap *Automap) Pan(dx float32, dy float32) {
+	automap.pan = automap.pan.Add(mgl32.Vec2{dx, dy}.Mul(automap.zoom))
+}
+
+// Zoom scales the automap view. Factors above one zoom out.
+func (automap *Automap) Zoom(factor float32) {
+	automap.zoom *= factor
+	if automap.zoom < automapMinZoom {
+		automap.zoom = automapMinZoom
+	}
+	if automap.zoom > automapMaxZoom {
+		automap.zoom = automapMaxZoom
+	}
+}
+
+// Reset recenters the automap on the player and restores the default zoom.
+func (automap *Automap) Reset() {
+	automap.pan = mgl32.Vec2{}
+	automap.zoom = automapDefaultZoom
+}
+
+// Render draws the automap centered on the player. The position and
+// direction are in map coordinates.
+func (automap *Automap) Render(width int, height int, position mgl32.Vec2, direction mgl32.Vec2) {
+	center := position.Add(automap.pan)
+	halfHeight := automap.zoom / 2
+	halfWidth := halfHeight * float32(width) / float32(height)
+	mvp := mgl32.Ortho2D(center.X()-halfWidth, center.X()+halfWidth, center.Y()-halfHeight, center.Y()+halfHeight)
+
+	gl.Disable(gl.DEPTH_TEST)
+	defer gl.Enable(gl.DEPTH_TEST)
+
+	gl.UseProgram(automap.program)
+	gl.UniformMatrix4fv(automap.matrixID, 1, false, &mvp[0])
+
+	gl.BindVertexArray(automap.vao)
+	if automap.blockmap != nil {
+		bbox := wad.BBox{
+			Left:   automapClamp(center.X() - halfWidth),
+			Right:  automapClamp(center.X() + halfWidth),
+			Bottom: automapClamp(center.Y() - halfHeight),
+			Top:    automapClamp(center.Y() + halfHeight),
+		}
+		indices := []uint32{}
+		for _, linedef := range automap.blockmap.LinesInRect(bbox) {
+			if first := automap.lineFirst[linedef]; first >= 0 {
+				indices = append(indices, uint32(first), uint32(first+1))
+			}
+		}
+		if len(indices) > 0 {
+			gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, gl.Ptr(indices), gl.STREAM_DRAW)
+			gl.DrawElements(gl.LINES, int32(len(indices)), gl.UNSIGNED_INT, nil)
+		}
+	} else {
+		gl.DrawArrays(gl.LINES, 0, int32(automap.lineCount))
+	}
+	gl.PointSize(3.0)
+	gl.DrawArrays(gl.POINTS, int32(automap.lineCount), int32(automap.thingCount))
+
+	arrow := automapArrow(position, direction)
+	gl.BindVertexArray(automap.playerVao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, automap.playerVbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(arrow)*4, gl.Ptr(arrow), gl.STREAM_DRAW)
+	gl.DrawArrays(gl.LINES, 0, int32(len(arrow)/automapStride))
+}
+
+// automapClamp converts a map coordinate of the automap view to the range
+// of map coordinates.
+func automapClamp(v float32) int16 {
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	return int16(v)
+}
+
+// automapArrow returns line vertices for an arrow at the player position
+// pointing in the direction the player is facing.
+func automapArrow(position mgl32.Vec2, direction mgl32.Vec2) []float32 {
+	forward := direction.Normalize().Mul(automapArrowSize)
+	side := mgl32.Vec2{-forward.Y(), forward.X()}.Mul(0.5)
+	tip := position.Add(forward)
+	tail := position.Sub(forward)
+	head := position.Add(forward.Mul(0.25))
+	points := []mgl32.Vec2{
+		tail, tip,
+		tip, head.Add(side),
+		tip, head.Sub(side),
+	}
+	arrow := []float32{}
+	for _, p := range points {
+		arrow = appendAutomapVertex(arrow, p.X(), p.Y(), automapPlayerColor)
+	}
+	return arrow
+}
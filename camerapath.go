@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// CameraPath is a recording of the view transform of every frame. It is
+// saved as a text file with one frame per line that holds the eye
+// position, the angle, and the pitch.
+type CameraPath struct {
+	Frames []Spectator
+}
+
+// cameraPathFilename returns the name of a new camera path recording.
+func cameraPathFilename() string {
+	return fmt.Sprintf("godoom-%s.path", time.Now().Format("20060102-150405"))
+}
+
+// saveCameraPath saves a recorded camera path to a new file and reports
+// where it went.
+func saveCameraPath(path *CameraPath) {
+	filename := cameraPathFilename()
+	if err := path.Save(filename); err != nil {
+		fmt.Printf("warning: Failed to save camera path: %s\n", err)
+		return
+	}
+	fmt.Printf("Saved %d frames of camera path to '%s'\n", len(path.Frames), filename)
+}
+
+// LoadCameraPath reads a camera path from a file.
+func LoadCameraPath(filename string) (*CameraPath, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	path := &CameraPath{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var frame Spectator
+		var x, y, z float32
+		if _, err := fmt.Sscanf(scanner.Text(), "%g %g %g %d %g", &x, &y, &z, &frame.Angle, &frame.Pitch); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, line, err)
+		}
+		frame.Eye[0], frame.Eye[1], frame.Eye[2] = x, y, z
+		path.Frames = append(path.Frames, frame)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(path.Frames) == 0 {
+		return nil, fmt.Errorf("%s: no frames", filename)
+	}
+	return path, nil
+}
+
+// Save writes a camera path to a file.
+func (path *CameraPath) Save(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, frame := range path.Frames {
+		fmt.Fprintf(w, "%g %g %g %d %g\n", frame.Eye.X(), frame.Eye.Y(), frame.Eye.Z(), frame.Angle, frame.Pitch)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"time"
+)
+
+// pausedMessage is shown while the game is paused.
+const pausedMessage = "Paused"
+
+// ticRate is the number of tics per second. Like in Doom, the game state
+// changes in steps of one tic, independent of the frame rate.
+const (
+	ticRate     = 35
+	ticDuration = time.Second / ticRate
+)
+
+// maxCatchUpTics is the most tics that a fixed step runs in one frame.
+// Time beyond it, such as while the window is dragged, is dropped instead
+// of making the following frames run ever more tics.
+const maxCatchUpTics = 10
+
+// Clock measures game time. Everything that changes over time reads the
+// clock instead of the wall time, so pausing or slowing down the clock
+// keeps the whole world in sync.
+type Clock struct {
+	Scale   float64          // Speed of game time relative to wall time.
+	now     func() time.Time // Source of wall time.
+	last    time.Time        // Wall time of the previous tick.
+	elapsed time.Duration    // Total game time.
+	delta   time.Duration    // Game time between the two previous ticks.
+	paused  bool
+}
+
+// NewClock returns a running clock that starts at zero and advances at a
+// scale of wall time.
+func NewClock(scale float64) *Clock {
+	return newClockAt(scale, time.Now)
+}
+
+// newClockAt returns a clock like NewClock that reads the wall time from a
+// time source.
+func newClockAt(scale float64, now func() time.Time) *Clock {
+	return &Clock{Scale: scale, now: now, last: now()}
+}
+
+// Tick advances the clock by the scaled wall time since the previous tick.
+// It is called once per frame before anything reads the clock.
+func (clock *Clock) Tick() {
+	now := clock.now()
+	clock.delta = 0
+	if !clock.paused {
+		clock.delta = time.Duration(float64(now.Sub(clock.last)) * clock.Scale)
+	}
+	clock.elapsed += clock.delta
+	clock.last = now
+}
+
+// Elapsed returns the game time since the clock was started.
+func (clock *Clock) Elapsed() time.Duration {
+	return clock.elapsed
+}
+
+// Delta returns the game time between the two previous ticks.
+func (clock *Clock) Delta() time.Duration {
+	return clock.delta
+}
+
+// Tics returns the number of whole game tics since the clock was started.
+func (clock *Clock) Tics() int {
+	return int(clock.elapsed / ticDuration)
+}
+
+// TicFraction returns how far the clock is into the current tic, from
+// zero to one.
+func (clock *Clock) TicFraction() float32 {
+	return float32(float64(clock.elapsed%ticDuration) / float64(ticDuration))
+}
+
+// Paused returns true if the clock is paused.
+func (clock *Clock) Paused() bool {
+	return clock.paused
+}
+
+// SetPaused pauses or resumes the clock.
+func (clock *Clock) SetPaused(paused bool) {
+	clock.paused = paused
+}
+
+// FixedStep runs updates in steps of one tic of a clock, however much time
+// passes between frames.
+type FixedStep struct {
+	clock *Clock
+	tic   int // Number of tics stepped.
+}
+
+// NewFixedStep returns a fixed step that has not stepped any tics of a
+// clock yet.
+func NewFixedStep(clock *Clock) *FixedStep {
+	return &FixedStep{clock: clock}
+}
+
+// Next returns true and counts the tic as stepped if the clock has
+// advanced by a whole tic that has not been stepped yet. Every frame calls
+// it in a loop to run the tics that have passed since the previous frame.
+func (step *FixedStep) Next() bool {
+	tics := step.clock.Tics()
+	if tics-step.tic > maxCatchUpTics {
+		step.tic = tics - maxCatchUpTics
+	}
+	if step.tic >= tics {
+		return false
+	}
+	step.tic++
+	return true
+}
+
+// Tic returns the number of the tic last stepped, counted from zero.
+func (step *FixedStep) Tic() int {
+	return step.tic - 1
+}
+
+// Fraction returns how far the clock is into the tic after the one last
+// stepped, from zero to one. Rendering interpolates by it between the
+// states of the two latest tics. The fraction is taken from the game time
+// past the tic, so it keeps its precision however long the game runs.
+func (step *FixedStep) Fraction() float32 {
+	past := step.clock.elapsed - time.Duration(step.tic)*ticDuration
+	return mgl32.Clamp(float32(float64(past)/float64(ticDuration)), 0, 1)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// testTime is a wall time source that only moves when told to.
+type testTime struct {
+	now time.Time
+}
+
+func (t *testTime) Now() time.Time {
+	return t.now
+}
+
+func (t *testTime) Advance(d time.Duration) {
+	t.now = t.now.Add(d)
+}
+
+// steps returns how many tics a fixed step runs.
+func steps(step *FixedStep) int {
+	count := 0
+	for step.Next() {
+		count++
+	}
+	return count
+}
+
+func TestFixedStep(t *testing.T) {
+	wall := &testTime{now: time.Unix(0, 0)}
+	clock := newClockAt(1.0, wall.Now)
+	step := NewFixedStep(clock)
+
+	wall.Advance(3*ticDuration + ticDuration/2)
+	clock.Tick()
+	if n := steps(step); n != 3 {
+		t.Errorf("stepped %d tics, want 3", n)
+	}
+	if step.Tic() != 2 {
+		t.Errorf("Tic() = %d, want 2", step.Tic())
+	}
+	if f := step.Fraction(); math.Abs(float64(f)-0.5) > 1e-6 {
+		t.Errorf("Fraction() = %f, want 0.5", f)
+	}
+
+	// Time past the catch-up limit is dropped.
+	wall.Advance(25*ticDuration + ticDuration/2)
+	clock.Tick()
+	if n := steps(step); n != maxCatchUpTics {
+		t.Errorf("stepped %d tics after a stall, want %d", n, maxCatchUpTics)
+	}
+	if step.Tic() != 28 {
+		t.Errorf("Tic() = %d after a stall, want 28", step.Tic())
+	}
+	if f := step.Fraction(); f != 0 {
+		t.Errorf("Fraction() = %f on a tic boundary, want 0", f)
+	}
+
+	// A paused clock does not advance, and the time while paused is not
+	// caught up on resuming.
+	clock.SetPaused(true)
+	wall.Advance(5 * ticDuration)
+	clock.Tick()
+	if n := steps(step); n != 0 || clock.Delta() != 0 {
+		t.Errorf("paused: stepped %d tics and advanced by %s, want none", n, clock.Delta())
+	}
+	clock.SetPaused(false)
+	wall.Advance(ticDuration)
+	clock.Tick()
+	if n := steps(step); n != 1 {
+		t.Errorf("resumed: stepped %d tics, want 1", n)
+	}
+}
+
+func TestFixedStepFraction(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		stepped bool
+		want    float32
+	}{
+		{"start", 0, true, 0},
+		{"quarter", ticDuration / 4, true, 0.25},
+		{"just before a tic", ticDuration - 1, true, 1},
+		{"on a tic", 7 * ticDuration, true, 0},
+		{"not stepped yet", 7 * ticDuration, false, 1},
+		{"after an hour", 126000*ticDuration + ticDuration/3, true, 1.0 / 3},
+		{"after eight hours", 8*126000*ticDuration + ticDuration/3, true, 1.0 / 3},
+	}
+	for _, test := range tests {
+		clock := newClockAt(1.0, time.Now)
+		clock.elapsed = test.elapsed
+		step := NewFixedStep(clock)
+		step.tic = clock.Tics()
+		if !test.stepped {
+			step.tic--
+		}
+		if got := step.Fraction(); math.Abs(float64(got-test.want)) > 1e-6 {
+			t.Errorf("%s: Fraction() = %f, want %f", test.name, got, test.want)
+		}
+	}
+}
+
+func TestClockScale(t *testing.T) {
+	wall := &testTime{now: time.Unix(0, 0)}
+	clock := newClockAt(0.5, wall.Now)
+	wall.Advance(2 * time.Second)
+	clock.Tick()
+	if clock.Elapsed() != time.Second || clock.Delta() != time.Second {
+		t.Errorf("got %s elapsed and %s delta, want 1s each", clock.Elapsed(), clock.Delta())
+	}
+}
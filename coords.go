@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"math"
+)
+
+// The 3D view uses OpenGL's convention of a right-handed world with Y up.
+// Doom maps have X to the east, Y to the north, and heights along Z. Map X
+// becomes negated world X, map Y becomes world Z, and heights become world
+// Y. The transform is a rotation, so the map is not mirrored, and it is the
+// only place where the two coordinate systems meet.
+
+// worldTransform converts between map and world coordinates.
+type worldTransform struct {
+	xSign float32 // Sign that map X gets in world X.
+}
+
+// newWorldTransform returns the transform of the 3D view. If mirrored is
+// set, map X keeps its sign in world X, which turns the transform into a
+// reflection that mirrors the map. It is a debugging aid for comparing the
+// view with screenshots.
+func newWorldTransform(mirrored bool) worldTransform {
+	if mirrored {
+		return worldTransform{xSign: 1}
+	}
+	return worldTransform{xSign: -1}
+}
+
+// mirrored returns true if the transform mirrors the map.
+func (t worldTransform) mirrored() bool {
+	return t.xSign > 0
+}
+
+// doomToWorld converts a point in map coordinates at a height to world
+// coordinates.
+func (t worldTransform) doomToWorld(x int16, y int16, z int16) mgl32.Vec3 {
+	return t.mapToWorld(mgl32.Vec2{float32(x), float32(y)}, float32(z))
+}
+
+// mapToWorld converts a position in map coordinates at a height to world
+// coordinates. It also converts directions.
+func (t worldTransform) mapToWorld(position mgl32.Vec2, height float32) mgl32.Vec3 {
+	return mgl32.Vec3{t.xSign * position.X(), height, position.Y()}
+}
+
+// worldToMap converts world coordinates to a position in map coordinates
+// and a height. It is the inverse of mapToWorld and also converts
+// directions.
+func (t worldTransform) worldToMap(v mgl32.Vec3) (mgl32.Vec2, float32) {
+	return mgl32.Vec2{t.xSign * v.X(), v.Z()}, v.Y()
+}
+
+// mapAngleToView converts a map angle in degrees, which grows
+// counterclockwise from the east like the angles of things, to the view
+// angle of viewDirection that faces the same way.
+func (t worldTransform) mapAngleToView(angle int16) int16 {
+	y, x := math.Sincos(float64(angle) * math.Pi / 180)
+	direction := t.mapToWorld(mgl32.Vec2{float32(x), float32(y)}, 0)
+	view := math.Atan2(float64(direction.Z()), float64(direction.X())) * 180 / math.Pi
+	return int16(math.Floor(view + 0.5))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"math"
+	"testing"
+)
+
+// handedness returns the determinant of the world directions of map east,
+// map north, and up, which is 1 for a rotation and -1 for a reflection.
+func handedness(transform worldTransform) float32 {
+	east := transform.mapToWorld(mgl32.Vec2{1, 0}, 0)
+	north := transform.mapToWorld(mgl32.Vec2{0, 1}, 0)
+	up := transform.mapToWorld(mgl32.Vec2{}, 1)
+	return mgl32.Mat3FromCols(east, north, up).Det()
+}
+
+func TestMapToWorld(t *testing.T) {
+	for _, mirrored := range []bool{false, true} {
+		transform := newWorldTransform(mirrored)
+		for _, p := range [][3]int16{{0, 0, 0}, {128, -64, 32}, {-32768, 32767, -8}} {
+			world := transform.doomToWorld(p[0], p[1], p[2])
+			if world.Y() != float32(p[2]) || world.Z() != float32(p[1]) {
+				t.Errorf("mirrored %v: doomToWorld(%v) = %v, want height as Y and map Y as Z", mirrored, p, world)
+			}
+			position, height := transform.worldToMap(world)
+			if position != (mgl32.Vec2{float32(p[0]), float32(p[1])}) || height != float32(p[2]) {
+				t.Errorf("mirrored %v: worldToMap(doomToWorld(%v)) = %v, %f", mirrored, p, position, height)
+			}
+		}
+	}
+	// Unless mirrored on purpose, the transform must not swap left and
+	// right.
+	if det := handedness(newWorldTransform(false)); det != 1 {
+		t.Errorf("transform has determinant %f, want 1", det)
+	}
+	if det := handedness(newWorldTransform(true)); det != -1 {
+		t.Errorf("mirrored transform has determinant %f, want -1", det)
+	}
+}
+
+func TestMapAngleToView(t *testing.T) {
+	for _, mirrored := range []bool{false, true} {
+		transform := newWorldTransform(mirrored)
+		for _, angle := range []int16{0, 45, 90, 180, 270, -90} {
+			y, x := math.Sincos(float64(angle) * math.Pi / 180)
+			want := transform.mapToWorld(mgl32.Vec2{float32(x), float32(y)}, 0)
+			got := viewDirection(float32(transform.mapAngleToView(angle)))
+			if got.Sub(want).Len() > 1e-3 {
+				t.Errorf("mirrored %v: map angle %d faces %v, want %v", mirrored, angle, got, want)
+			}
+		}
+	}
+	// Turning right increases the view angle and turns clockwise on the
+	// map, which has a negative cross product with the old direction.
+	transform := newWorldTransform(false)
+	before, _ := transform.worldToMap(viewDirection(0))
+	after, _ := transform.worldToMap(viewDirection(10))
+	if cross := before.X()*after.Y() - before.Y()*after.X(); cross >= 0 {
+		t.Errorf("turning right turns counterclockwise on the map")
+	}
+}
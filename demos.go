@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/penberg/godoom/wad"
+	"os"
+	"strings"
+)
+
+// demoLumps are the demos that the title screen of Doom cycles through.
+// DEMO4 is only in The Ultimate Doom.
+var demoLumps = []string{"DEMO1", "DEMO2", "DEMO3", "DEMO4"}
+
+var demosCommand = cli.Command{
+	Name:  "demos",
+	Usage: "Print the header of every demo lump",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "WAD archive",
+			Value: "doom1.wad",
+		},
+	},
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		printDemos(w)
+	},
+}
+
+// printDemos prints the version, skill, level, players, and length of
+// every demo lump, and warns about demos that cannot be parsed or that are
+// recorded on a level that the WAD archive does not have.
+func printDemos(w *wad.WAD) {
+	count := 0
+	for _, name := range demoLumps {
+		if !w.HasLump(name) {
+			continue
+		}
+		count++
+		demo, err := w.ReadDemo(name)
+		if err != nil {
+			fmt.Printf("warning: %s\n", err)
+			continue
+		}
+		levelName, found := demoLevelName(w, demo)
+		version := "old"
+		if demo.Version != 0 {
+			version = fmt.Sprintf("%d.%d", demo.Version/100, demo.Version%100)
+		}
+		seconds := float64(len(demo.Tics)) / ticRate
+		// Demos number skill levels from zero.
+		fmt.Printf("  %-5s: version %s, skill %d, %s, players %d, %d tics (%.1f s)\n", name, version, demo.Skill+1, levelName, demo.NumPlayers(), len(demo.Tics), seconds)
+		if !found {
+			fmt.Printf("warning: %s: Level %s not found\n", name, levelName)
+		}
+	}
+	fmt.Printf("%d demos\n", count)
+}
+
+// demoLevelName returns the name of the level that a demo is recorded on
+// and whether the WAD archive has it. Doom II demos record episode 1 and
+// the map number, so a level is looked up by both names.
+func demoLevelName(w *wad.WAD, demo *wad.Demo) (string, bool) {
+	episodeName := fmt.Sprintf("E%dM%d", demo.Episode, demo.Map)
+	mapName := fmt.Sprintf("MAP%02d", demo.Map)
+	levelNames := w.LevelNames()
+	for _, levelName := range levelNames {
+		if levelName == episodeName || levelName == mapName {
+			return levelName, true
+		}
+	}
+	for _, levelName := range levelNames {
+		if strings.HasPrefix(levelName, "MAP") {
+			return mapName, false
+		}
+	}
+	return episodeName, false
+}
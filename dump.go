@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/penberg/godoom/wad"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var dumpTexturesCommand = cli.Command{
+	Name:  "dump-textures",
+	Usage: "Write every wall texture as a PNG image",
+	Flags: dumpFlags("textures"),
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := dumpImages(w.TextureNames(), c.String("output"), "textures", func(name string) (*image.RGBA, error) {
+			return composeTexture(w, name, 0)
+		}); err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var dumpFlatsCommand = cli.Command{
+	Name:  "dump-flats",
+	Usage: "Write every flat as a PNG image",
+	Flags: dumpFlags("flats"),
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := dumpImages(w.FlatNames(), c.String("output"), "flats", func(name string) (*image.RGBA, error) {
+			return composeFlat(w, name, 0)
+		}); err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var dumpSpritesCommand = cli.Command{
+	Name:  "dump-sprites",
+	Usage: "Write every sprite as a PNG image",
+	Flags: dumpFlags("sprites"),
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		names, err := w.SpriteNames()
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := dumpImages(names, c.String("output"), "sprites", func(name string) (*image.RGBA, error) {
+			sprite, err := w.LoadPicture(name)
+			if err != nil {
+				return nil, err
+			}
+			return pictureToRGBA(w, sprite, 0), nil
+		}); err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func dumpFlags(defaultDir string) []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "WAD archive",
+			Value: "doom1.wad",
+		},
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "Output directory",
+			Value: defaultDir,
+		},
+	}
+}
+
+// dumpImages composes every named image and writes it as a PNG image named
+// after it into a directory. Images that fail to compose are reported and
+// skipped.
+func dumpImages(names []string, dir string, kind string, compose func(name string) (*image.RGBA, error)) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	failed := 0
+	for i, name := range names {
+		fmt.Printf("[%d/%d] %s\n", i+1, len(names), name)
+		rgba, err := compose(name)
+		if err != nil {
+			fmt.Printf("warning: %s\n", err)
+			failed++
+			continue
+		}
+		// Lump names may contain characters that are not valid in file
+		// names, such as the backslash.
+		filename := strings.NewReplacer("/", "_", "\\", "_").Replace(name) + ".png"
+		file, err := os.Create(filepath.Join(dir, filename))
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(file, rgba); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Wrote %d %s to %s (%d failed)\n", len(names)-failed, kind, dir, failed)
+	return nil
+}
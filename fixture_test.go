@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"github.com/penberg/godoom/internal/wadtest"
+	"github.com/penberg/godoom/wad"
+	"math"
+)
+
+// triangleArea returns the total area of triangles.
+func triangleArea(triangles []wad.Point) float64 {
+	area := 0.0
+	for i := 0; i+2 < len(triangles); i += 3 {
+		a, b, c := triangles[i], triangles[i+1], triangles[i+2]
+		area += math.Abs(float64(cross(a, b, c))) / 2
+	}
+	return area
+}
+
+// testPicture encodes a picture with one post per column.
+func testPicture(width int, height int, pixel func(x int, y int) byte) []byte {
+	columns := []byte{}
+	offsets := []int32{}
+	columnsStart := 8 + 4*width
+	for x := 0; x < width; x++ {
+		offsets = append(offsets, int32(columnsStart+len(columns)))
+		columns = append(columns, 0, byte(height), 0)
+		for y := 0; y < height; y++ {
+			columns = append(columns, pixel(x, y))
+		}
+		columns = append(columns, 0, 0xff)
+	}
+	header := wadtest.EncodeLE(wad.PictureHeader{Width: int16(width), Height: int16(height)}, offsets)
+	return append(header, columns...)
+}
+
+// testTexturePixel is the pattern of the wall patch, 16 by 16 squares of
+// two colors.
+func testTexturePixel(x int, y int) byte {
+	return byte(64 + (x/16+y/16)%2*96)
+}
+
+// testWADLumps returns the lumps of an IWAD with a palette, a wall texture
+// called WALL made of one patch, the flats FLOOR and CEIL, and wadtest.Level
+// as MAP01. Textures WALL0 and up repeat WALL as many times as asked for.
+func testWADLumps(extraTextures int) []wadtest.Lump {
+	playpal := []byte{}
+	for palette := 0; palette < wad.NumPalettes; palette++ {
+		for i := 0; i < 256; i++ {
+			playpal = append(playpal, byte(i), byte(255-i), byte(i*5))
+		}
+	}
+	textures := []string{"WALL"}
+	for i := 0; i < extraTextures; i++ {
+		textures = append(textures, fmt.Sprintf("WALL%d", i))
+	}
+	offsets := []int32{}
+	definitions := []byte{}
+	definitionsStart := 4 + 4*len(textures)
+	for _, name := range textures {
+		offsets = append(offsets, int32(definitionsStart+len(definitions)))
+		header := wad.TextureHeader{TexName: wadtest.Name8(name), Width: 64, Height: 128, NumPatches: 1}
+		definitions = append(definitions, wadtest.EncodeLE(header, wad.Patch{PNameNumber: 0})...)
+	}
+	texture1 := append(wadtest.EncodeLE(uint32(len(textures)), offsets), definitions...)
+	floor := make([]byte, 64*64)
+	ceiling := make([]byte, 64*64)
+	for i := range floor {
+		floor[i] = byte(200 + (i/8+i/(8*64))%2*20)
+		ceiling[i] = 120
+	}
+	lumps := []wadtest.Lump{
+		{Name: "PLAYPAL", Data: playpal},
+		{Name: "PNAMES", Data: wadtest.EncodeLE(uint32(1), wadtest.Name8("WALLPAT"))},
+		{Name: "WALLPAT", Data: testPicture(64, 128, testTexturePixel)},
+		{Name: "TEXTURE1", Data: texture1},
+		{Name: "F_START", Data: nil},
+		{Name: "FLOOR", Data: floor},
+		{Name: "CEIL", Data: ceiling},
+		{Name: "F_END", Data: nil},
+	}
+	return append(lumps, wadtest.LevelLumps("MAP01", wadtest.Level())...)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/penberg/godoom/wad"
+	"strings"
+	"time"
+)
+
+// The HUD font has a STCFNxxx picture lump for each character from '!' to
+// '_', where xxx is the decimal character code.
+const (
+	fontStart      = '!'
+	fontEnd        = '_'
+	fontSpaceWidth = 4
+)
+
+// messageDuration is how long a HUD message stays on the screen.
+const messageDuration = 2 * time.Second
+
+// glyph is a HUD picture uploaded as a texture.
+type glyph struct {
+	texture    uint32
+	width      int
+	height     int
+	leftOffset int
+	topOffset  int
+}
+
+// newGlyph uploads a HUD picture.
+func newGlyph(w *wad.WAD, picture *wad.Image) glyph {
+	return glyph{
+		texture:    UploadPicture(pictureToRGBA(w, picture, 0)),
+		width:      picture.Width,
+		height:     picture.Height,
+		leftOffset: picture.LeftOffset,
+		topOffset:  picture.TopOffset,
+	}
+}
+
+// delete releases the texture of the glyph.
+func (g *glyph) delete() {
+	gl.DeleteTextures(1, &g.texture)
+}
+
+// draw draws the glyph at a position on the Doom screen. Like in Doom, the
+// position is where the origin of the picture goes, which is offset from
+// its top left corner.
+func (g *glyph) draw(renderer *PictureRenderer, x int, y int, fbWidth int, fbHeight int) {
+	renderer.Draw(g.texture, x-g.leftOffset, y-g.topOffset, g.width, g.height, fbWidth, fbHeight)
+}
+
+// Font draws text on the Doom screen with the HUD font.
+type Font struct {
+	glyphs map[rune]glyph
+	height int
+}
+
+// NewFont uploads the HUD font of a WAD archive.
+func NewFont(w *wad.WAD) (*Font, error) {
+	font := &Font{glyphs: make(map[rune]glyph)}
+	for ch := rune(fontStart); ch <= fontEnd; ch++ {
+		name := fmt.Sprintf("STCFN%03d", ch)
+		if !w.HasLump(name) {
+			continue
+		}
+		picture, err := w.LoadPicture(name)
+		if err != nil {
+			return nil, err
+		}
+		font.glyphs[ch] = newGlyph(w, picture)
+		if picture.Height > font.height {
+			font.height = picture.Height
+		}
+	}
+	if len(font.glyphs) == 0 {
+		return nil, fmt.Errorf("HUD font not found")
+	}
+	return font, nil
+}
+
+// Delete releases the textures of the font.
+func (font *Font) Delete() {
+	for _, g := range font.glyphs {
+		g.delete()
+	}
+}
+
+// Draw draws text with its top left corner at a position on the Doom
+// screen. The font only has upper case letters, and characters that are
+// not in the font are drawn as spaces.
+func (font *Font) Draw(renderer *PictureRenderer, text string, x int, y int, fbWidth int, fbHeight int) {
+	for _, ch := range strings.ToUpper(text) {
+		glyph, ok := font.glyphs[ch]
+		if !ok {
+			x += fontSpaceWidth
+			continue
+		}
+		glyph.draw(renderer, x, y, fbWidth, fbHeight)
+		x += glyph.width
+	}
+}
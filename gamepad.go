@@ -0,0 +1,126 @@
+package main
+
+import (
+	"github.com/go-gl/glfw/v3.1/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+	"math"
+)
+
+// Axes and buttons of the gamepad in the layout of an Xbox controller on
+// Linux, which most gamepads follow. Triggers rest at -1 and go to 1 when
+// pulled all the way.
+const (
+	gamepadLeftX        = 0
+	gamepadLeftY        = 1
+	gamepadLeftTrigger  = 2
+	gamepadRightX       = 3
+	gamepadRightY       = 4
+	gamepadRightTrigger = 5
+	gamepadButtonA      = 0
+	gamepadLeftBumper   = 4
+	gamepadRightBumper  = 5
+)
+
+// defaultGamepadDeadzone is the stick deflection below which sticks are
+// ignored, which keeps worn sticks from drifting.
+const defaultGamepadDeadzone = 0.25
+
+// playerRunFactor is how much faster the player moves while running.
+const playerRunFactor = 2.0
+
+// GamepadInput is the state of the gamepad in a frame. Stick values go
+// from -1 to 1 and are zero inside the deadzone.
+type GamepadInput struct {
+	Forward float32 // Left stick up.
+	Strafe  float32 // Left stick right.
+	Turn    float32 // Right stick right.
+	Look    float32 // Right stick up.
+	Run     bool    // Left trigger or bumper held down.
+	Use     bool    // Right trigger, bumper, or A went down since the previous frame.
+}
+
+// Gamepad reads the first connected joystick. Gamepads can be plugged in
+// and out while playing.
+type Gamepad struct {
+	joystick  glfw.Joystick
+	connected bool
+	deadzone  float32
+	useDown   bool
+}
+
+// NewGamepad returns a gamepad that ignores stick deflections below a
+// deadzone.
+func NewGamepad(deadzone float32) *Gamepad {
+	return &Gamepad{deadzone: deadzone}
+}
+
+// Poll returns the state of the gamepad, or no input if no gamepad is
+// connected.
+func (gamepad *Gamepad) Poll() GamepadInput {
+	if !gamepad.connected || !glfw.JoystickPresent(gamepad.joystick) {
+		if gamepad.connected {
+			verbose.Printf("Gamepad disconnected\n")
+			gamepad.connected = false
+			gamepad.useDown = false
+		}
+		if !gamepad.connect() {
+			return GamepadInput{}
+		}
+	}
+	axes := glfw.GetJoystickAxes(gamepad.joystick)
+	buttons := glfw.GetJoystickButtons(gamepad.joystick)
+	axis := func(i int) float32 {
+		if i >= len(axes) {
+			return 0
+		}
+		return axes[i]
+	}
+	button := func(i int) bool {
+		return i < len(buttons) && buttons[i] == byte(glfw.Press)
+	}
+	// Triggers at rest read as zero before they are first pulled on some
+	// drivers, so only a trigger pulled past halfway counts.
+	trigger := func(i int) bool {
+		return axis(i) > 0
+	}
+	left := gamepad.stick(axis(gamepadLeftX), axis(gamepadLeftY))
+	right := gamepad.stick(axis(gamepadRightX), axis(gamepadRightY))
+	useDown := button(gamepadButtonA) || button(gamepadRightBumper) || trigger(gamepadRightTrigger)
+	input := GamepadInput{
+		// Stick axes grow downwards.
+		Forward: -left.Y(),
+		Strafe:  left.X(),
+		Turn:    right.X(),
+		Look:    -right.Y(),
+		Run:     button(gamepadLeftBumper) || trigger(gamepadLeftTrigger),
+		Use:     useDown && !gamepad.useDown,
+	}
+	gamepad.useDown = useDown
+	return input
+}
+
+// connect looks for a connected joystick and returns true if it finds one.
+func (gamepad *Gamepad) connect() bool {
+	for joystick := glfw.Joystick1; joystick <= glfw.JoystickLast; joystick++ {
+		if glfw.JoystickPresent(joystick) {
+			gamepad.joystick = joystick
+			gamepad.connected = true
+			verbose.Printf("Gamepad connected: %s\n", glfw.GetJoystickName(joystick))
+			return true
+		}
+	}
+	return false
+}
+
+// stick returns the deflection of a stick with the deadzone cut out, so
+// that the deflection grows smoothly from zero at the edge of the deadzone
+// to one at full deflection.
+func (gamepad *Gamepad) stick(x float32, y float32) mgl32.Vec2 {
+	deflection := mgl32.Vec2{x, y}
+	length := deflection.Len()
+	if length <= gamepad.deadzone {
+		return mgl32.Vec2{}
+	}
+	scaled := (float32(math.Min(float64(length), 1.0)) - gamepad.deadzone) / (1.0 - gamepad.deadzone)
+	return deflection.Mul(scaled / length)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"testing"
+)
+
+func TestGamepadStick(t *testing.T) {
+	gamepad := NewGamepad(0.25)
+	tests := []struct {
+		name string
+		x    float32
+		y    float32
+		want mgl32.Vec2
+	}{
+		{"at rest", 0, 0, mgl32.Vec2{}},
+		{"inside the deadzone", 0.2, -0.1, mgl32.Vec2{}},
+		{"at the deadzone edge", 0.25, 0, mgl32.Vec2{}},
+		{"halfway out of the deadzone", 0, 0.625, mgl32.Vec2{0, 0.5}},
+		{"full deflection", -1, 0, mgl32.Vec2{-1, 0}},
+		{"diagonal over one", 1, 1, mgl32.Vec2{0.70710677, 0.70710677}},
+		{"diagonal inside one", 0.5, -0.5, mgl32.Vec2{0.4309644, -0.4309644}},
+	}
+	for _, test := range tests {
+		if got := gamepad.stick(test.x, test.y); !got.ApproxEqualThreshold(test.want, 1e-6) {
+			t.Errorf("%s: stick(%g, %g) = %v, want %v", test.name, test.x, test.y, got, test.want)
+		}
+	}
+}
@@ -1,50 +1,100 @@
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
 	"github.com/codegangsta/cli"
 	"github.com/go-gl/gl/v3.3-core/gl"
 	"github.com/go-gl/glfw/v3.1/glfw"
 	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/wad"
 	"image"
 	"image/color"
+	"image/png"
+	"io"
 	"log"
 	"math"
 	"os"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	vertex = `#version 330
 
-in vec3 vertex;
-in vec2 vertTexCoord;
+layout(location = 0) in vec3 vertex;
+layout(location = 1) in vec2 vertTexCoord;
+layout(location = 2) in float vertLight;
 
 uniform mat4 MVP;
+uniform vec3 Eye;
+uniform vec3 Origin;
 
 out vec2 fragTexCoord;
+out float fragDistance;
+out float fragLight;
 
 void main()
 {
+    vec3 position = Origin + vertex;
     fragTexCoord = vertTexCoord;
-    gl_Position = MVP * vec4(vertex, 1.0);
+    fragLight = vertLight;
+    fragDistance = distance(position, Eye);
+    gl_Position = MVP * vec4(position, 1.0);
 }` + "\x00"
 
 	fragment = `#version 330
 
 uniform float LightLevel;
+uniform bool Fullbright;
+uniform float Alpha;
+uniform float FogDensity;
+uniform vec3 FogColor;
+uniform vec4 TexRect;
+uniform vec2 Scroll;
+uniform float Gamma;
+uniform bool Highlight;
+const vec3 HighlightColor = vec3(1.0, 0.0, 1.0);
+uniform bool Indexed;
 uniform sampler2D tex;
+uniform sampler2D Palette;
 
 in vec2 fragTexCoord;
+in float fragDistance;
+in float fragLight;
 
 out vec4 outColor;
 
 void main()
 {
-    float alpha = texture(tex, fragTexCoord).a;
-    if (alpha == 1.0) {
-        outColor = texture(tex, fragTexCoord) * LightLevel;
+    // Textures repeat within their atlas tile. The gradients are taken from
+    // the unwrapped coordinates so that mipmap selection is not thrown off
+    // at the tile seams.
+    vec2 uv = TexRect.xy + fract(fragTexCoord + Scroll) * TexRect.zw;
+    vec2 dx = dFdx(fragTexCoord) * TexRect.zw;
+    vec2 dy = dFdy(fragTexCoord) * TexRect.zw;
+    vec4 texel = textureGrad(tex, uv, dx, dy);
+    // Indexed textures hold the palette index in red and the alpha in
+    // green.
+    if (Indexed) {
+        vec2 index = vec2((texel.r * 255.0 + 0.5) / 256.0, 0.5);
+        texel = vec4(texture(Palette, index).rgb, texel.g);
+    }
+    // Masked textures have binary alpha, so transparent texels are
+    // discarded. Translucency of a whole mesh comes from Alpha instead.
+    if (texel.a == 1.0) {
+        float light = clamp(LightLevel + fragLight, 0.0, 1.0);
+        vec4 color = Fullbright ? texel : texel * light;
+        if (Highlight) {
+            color.rgb = mix(color.rgb, HighlightColor, 0.5);
+        }
+        float fog = exp(-FogDensity * fragDistance);
+        vec3 rgb = mix(FogColor, color.rgb, fog);
+        outColor = vec4(pow(rgb, vec3(1.0 / Gamma)), Alpha);
     } else {
         discard;
     }
@@ -52,205 +102,894 @@ void main()
 )
 
 const (
-	subsectorBit = int(0x8000)
+	flatSize = 64
+	skyFlat  = "F_SKY1"
+)
+
+// Window size used when the requested size is invalid.
+const (
+	defaultWidth  = 640
+	defaultHeight = 480
+)
+
+// Polygon offset of flats. The offset is small enough to not open visible
+// gaps between flats and walls.
+const (
+	flatOffsetFactor = 1.0
+	flatOffsetUnits  = 1.0
+)
+
+// Default clip planes. Depth buffer precision depends on the ratio of the
+// far and near distances, so a far plane that only just covers the longest
+// sight lines of a level reduces z-fighting. Fog is off by default to look
+// like Doom, so geometry past the far plane of the largest levels is cut
+// off visibly unless --fog is set to hide it.
+const (
+	defaultNear = 1.0
+	defaultFar  = 8192.0
 )
 
+// maxAtlasSize is the largest texture atlas page size used even if the GPU
+// supports larger textures.
+const maxAtlasSize = 4096
+
 type Point3 struct {
-	X int16
-	Y int16
-	Z int16
-	U float32
-	V float32
+	Position mgl32.Vec3
+	U        float32
+	V        float32
+	Light    float32 // Offset added to the light level of the mesh.
+}
+
+// Layout of a vertex in the scene vertex buffer. The attribute locations
+// match the layout qualifiers of the vertex shader.
+const vertexFloats = 6 // Number of floats per vertex.
+
+var vertexAttribs = []struct {
+	location uint32
+	size     int32 // Number of floats.
+	offset   int   // Offset in floats from the start of the vertex.
+}{
+	{location: 0, size: 3, offset: 0}, // Position.
+	{location: 1, size: 2, offset: 3}, // Texture coordinates.
+	{location: 2, size: 1, offset: 5}, // Light offset.
 }
 
-type Point struct {
-	X int16
-	Y int16
+// appendFloats appends the vertex in the layout of the vertex buffer.
+func (p *Point3) appendFloats(data []float32) []float32 {
+	return append(data, p.Position.X(), p.Position.Y(), p.Position.Z(), p.U, p.V, p.Light)
 }
 
 type Mesh struct {
-	texture    string
-	vao        uint32
-	vbo        uint32
-	count      int
-	lightLevel float32
+	texture     string
+	flat        bool
+	first       int        // Index of the first vertex in the scene vertex buffer.
+	count       int        // Number of vertices.
+	origin      mgl32.Vec3 // Position that the vertices are relative to.
+	lightLevel  float32
+	sidedef     int        // Index of the sidedef of a wall, -1 for flats.
+	sector      int        // Index of the sector that the mesh belongs to.
+	doubleSided bool       // Visible from behind, for middle textures of two-sided lines.
+	fullbright  bool       // Ignore the sector light level.
+	alpha       float32    // Translucency of the mesh, one for opaque meshes.
+	atlasPage   uint32     // GL texture of the atlas page, zero if the texture is missing.
+	atlasRect   mgl32.Vec4 // Offset and size of the texture in the atlas page.
+	scrollTics  int        // Tics a scrolling wall takes to scroll by its texture width, zero if it does not scroll.
+}
+
+// Texture filtering modes.
+const (
+	FilterLinear  = "linear"
+	FilterNearest = "nearest"
+)
+
+// GL_EXT_texture_filter_anisotropic tokens.
+const (
+	textureMaxAnisotropy    = 0x84FE
+	maxTextureMaxAnisotropy = 0x84FF
+)
+
+// Boom translucent linedef special. A line with this special and a zero
+// tag draws its middle texture translucent.
+const (
+	linedefTranslucent = 260
+	translucentAlpha   = 0.66
+)
+
+// Linedef special that scrolls the textures of the line's front side left
+// by one map unit every tic.
+const linedefScrollLeft = 48
+
+// fullbrightTextures are the name prefixes of vanilla textures and flats
+// that are lit on their own, such as computer screens and light fixtures.
+var fullbrightTextures = []string{"COMPSTA", "COMPUTE", "LITE", "TLITE", "CEIL1_2", "CEIL1_3", "FLOOR1_7"}
+
+// isFullbright returns true if a texture or flat matches one of the
+// fullbright name prefixes.
+func isFullbright(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(name, strings.ToUpper(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// gammaLevels are the gamma correction levels cycled through with F11 like
+// the five gamma levels of vanilla Doom.
+var gammaLevels = []float32{1.0, 1.2, 1.4, 1.6, 1.8}
+
+// nextGamma returns the gamma level following the given gamma.
+func nextGamma(gamma float32) float32 {
+	for _, level := range gammaLevels {
+		if level > gamma+0.001 {
+			return level
+		}
+	}
+	return gammaLevels[0]
+}
+
+// RenderSettings holds the user-configurable rendering options.
+type RenderSettings struct {
+	FogDensity     float32    // Exponential fog density, zero disables fog.
+	FogColor       mgl32.Vec3 // Fog color, also used to clear the background.
+	WallFilter     string     // Texture filtering mode of walls.
+	FlatFilter     string     // Texture filtering mode of flats.
+	Mipmaps        bool       // Generate mipmaps for textures and flats.
+	Anisotropy     float32    // Anisotropic filtering level, one or less disables it.
+	Width          int        // Initial window width.
+	Height         int        // Initial window height.
+	Bench          int        // Number of frames to render in benchmark mode.
+	Gamma          float32    // Gamma correction applied to the final color.
+	SceneCache     bool       // Cache generated scenes on disk.
+	LevelName      string     // Name of the level being played.
+	Fullbright     []string   // Name prefixes of textures that ignore sector light.
+	Palette        int        // PLAYPAL palette used to compose textures and flats.
+	ViewBob        float32    // Amplitude of the view bob in map units, zero disables it.
+	Replay         string     // Camera path to replay instead of taking input.
+	Near           float32    // Distance of the near clip plane.
+	Far            float32    // Distance of the far clip plane.
+	VSync          bool       // Synchronize buffer swaps with the display refresh.
+	Highlight      bool       // Tint the sector that the player is in.
+	Indexed        bool       // Upload palette indices and apply the palette in the shader.
+	SmoothLighting bool       // Blend wall light levels across two-sided lines.
+	Mirror         bool       // Mirror the map in the 3D view.
+	RenderTo       string     // PNG image to render one offscreen frame to instead of playing.
+	RenderWidth    int        // Width of the offscreen frame.
+	RenderHeight   int        // Height of the offscreen frame.
+	ThingScale     float32    // Size of sprites in map units per pixel.
+	Deadzone       float32    // Gamepad stick deflection that is ignored.
+}
+
+// composePalette returns the palette that scene textures and flats are
+// composed with.
+// transform returns the transform between map and world coordinates of
+// the 3D view.
+func (settings *RenderSettings) transform() worldTransform {
+	return newWorldTransform(settings.Mirror)
+}
+
+func (settings *RenderSettings) composePalette() int {
+	if settings.Indexed {
+		return indexedPalette
+	}
+	return settings.Palette
 }
 
 type Scene struct {
-	meshes   map[int][]Mesh // Meshes indexed by subsector ID.
-	textures map[string]uint32
+	meshes    map[int][]Mesh // Meshes indexed by subsector ID.
+	textures  map[string]*image.RGBA
+	flats     map[string]*image.RGBA
+	wallAtlas *Atlas   // Wall texture atlas, used to retexture walls after upload.
+	wallPages []uint32 // GL textures of the wall texture atlas pages.
+	flatPages []uint32 // GL textures of the flat atlas pages.
+	playpal   *wad.Playpal
+	palette   uint32 // GL texture of the palette for indexed textures, zero if not indexed.
+	settings  *RenderSettings
+	vertices  []float32      // Vertex data pending upload.
+	ranges    map[string]int // First vertices of the meshes by texture and vertex data.
+	newMeshes int            // Number of meshes created by NewMesh.
+	shared    int            // Number of meshes that reuse the vertices of another mesh.
+	vao       uint32
+	vbo       uint32
 }
 
-func NewScene() Scene {
+func NewScene(settings *RenderSettings) Scene {
 	return Scene{
+		settings: settings,
 		meshes:   make(map[int][]Mesh),
-		textures: make(map[string]uint32),
+		ranges:   make(map[string]int),
+		textures: make(map[string]*image.RGBA),
+		flats:    make(map[string]*image.RGBA),
 	}
 }
 
-func (scene *Scene) CacheTexture(wad *WAD, name string) error {
-	_, loaded := scene.textures[name]
-	if loaded {
-		return nil
+// CacheTexture marks a wall texture as used by the scene. The texture is
+// composed later by Compose.
+func (scene *Scene) CacheTexture(name string) {
+	if _, ok := scene.textures[name]; !ok {
+		scene.textures[name] = nil
+	}
+}
+
+// CacheFlat marks a flat as used by the scene. The flat is composed later
+// by Compose.
+func (scene *Scene) CacheFlat(name string) {
+	if _, ok := scene.flats[name]; !ok {
+		scene.flats[name] = nil
+	}
+}
+
+// composeJob is a texture or flat to be composed by a Compose worker.
+type composeJob struct {
+	name  string
+	flat  bool
+	image *image.RGBA
+	err   error
+}
+
+// Compose composes the textures and flats used by the scene on a pool of
+// runtime.NumCPU() workers. Textures and flats that fail to compose are
+// dropped from the scene. The images are uploaded later on the GL thread
+// by Upload.
+func (scene *Scene) Compose(w *wad.WAD) {
+	jobs := []*composeJob{}
+	for name, texture := range scene.textures {
+		if texture == nil {
+			jobs = append(jobs, &composeJob{name: name})
+		}
+	}
+	for name, flat := range scene.flats {
+		if flat == nil {
+			jobs = append(jobs, &composeJob{name: name, flat: true})
+		}
+	}
+	queue := make(chan *composeJob)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if job.flat {
+					job.image, job.err = composed.Flat(w, job.name, scene.settings.composePalette())
+				} else {
+					job.image, job.err = composed.Texture(w, job.name, scene.settings.composePalette())
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	for _, job := range jobs {
+		images := scene.textures
+		if job.flat {
+			images = scene.flats
+		}
+		if job.err != nil {
+			delete(images, job.name)
+			continue
+		}
+		images[job.name] = job.image
+	}
+}
+
+// NewMesh appends the vertices of a mesh to the scene's vertex data. The
+// vertices are uploaded to the GPU for all meshes at once by Upload.
+//
+// The vertices are stored relative to the first vertex, so walls and flats
+// of the same texture and size that only differ in where they are share
+// their range of the vertex buffer. Map coordinates and heights are whole
+// numbers, so the relative positions are exact.
+func (scene *Scene) NewMesh(texture string, lightLevel int16, vertices []Point3) Mesh {
+	mesh := Mesh{texture: texture, count: len(vertices), lightLevel: float32(lightLevel) / 255.0, sidedef: -1, sector: -1, alpha: 1.0}
+	if len(vertices) > 0 {
+		mesh.origin = vertices[0].Position
+	}
+	data := make([]float32, 0, len(vertices)*vertexFloats)
+	for i := range vertices {
+		vertex := vertices[i]
+		vertex.Position = vertex.Position.Sub(mesh.origin)
+		data = vertex.appendFloats(data)
 	}
-	texture, err := loadTexture(wad, name)
+	key := make([]byte, 0, len(texture)+1+len(data)*4)
+	key = append(append(key, texture...), 0)
+	var bits [4]byte
+	for _, f := range data {
+		binary.LittleEndian.PutUint32(bits[:], math.Float32bits(f))
+		key = append(key, bits[:]...)
+	}
+	scene.newMeshes++
+	if first, ok := scene.ranges[string(key)]; ok {
+		scene.shared++
+		mesh.first = first
+		return mesh
+	}
+	mesh.first = len(scene.vertices) / vertexFloats
+	scene.ranges[string(key)] = mesh.first
+	scene.vertices = append(scene.vertices, data...)
+	return mesh
+}
+
+// vertexPosition returns the world position of a vertex of a mesh.
+func (scene *Scene) vertexPosition(mesh *Mesh, i int) mgl32.Vec3 {
+	data := scene.vertices[(mesh.first+i)*vertexFloats:]
+	return mesh.origin.Add(mgl32.Vec3{data[0], data[1], data[2]})
+}
+
+// ApplyFilter applies the wall and flat texture filtering modes of the
+// render settings to the uploaded atlas pages.
+func (scene *Scene) ApplyFilter() {
+	apply := func(pages []uint32, filter string) {
+		minFilter, magFilter := textureFilters(scene.settings, filter)
+		for _, page := range pages {
+			gl.BindTexture(gl.TEXTURE_2D, page)
+			gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, minFilter)
+			gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, magFilter)
+		}
+	}
+	apply(scene.wallPages, scene.settings.WallFilter)
+	apply(scene.flatPages, scene.settings.FlatFilter)
+}
+
+// SetPalette uploads a PLAYPAL palette for looking up the colors of indexed
+// textures. Switching palettes does not touch the textures themselves.
+func (scene *Scene) SetPalette(palette int) {
+	pixels := make([]byte, 0, 256*3)
+	for _, rgb := range scene.playpal.Palettes[palette].Table {
+		pixels = append(pixels, rgb.Red, rgb.Green, rgb.Blue)
+	}
+	if scene.palette == 0 {
+		gl.GenTextures(1, &scene.palette)
+	}
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, scene.palette)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	defer gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGB8, 256, 1, 0, gl.RGB, gl.UNSIGNED_BYTE, gl.Ptr(pixels))
+}
+
+// Delete releases the vertex buffer and the atlas pages of the scene.
+func (scene *Scene) Delete() {
+	gl.DeleteVertexArrays(1, &scene.vao)
+	gl.DeleteBuffers(1, &scene.vbo)
+	if scene.palette != 0 {
+		gl.DeleteTextures(1, &scene.palette)
+	}
+	for _, pages := range [][]uint32{scene.wallPages, scene.flatPages} {
+		if len(pages) > 0 {
+			gl.DeleteTextures(int32(len(pages)), &pages[0])
+		}
+	}
+}
+
+// Upload uploads the vertices of all meshes into a single vertex buffer
+// that every mesh draws a range of, and the textures into atlases.
+func (scene *Scene) Upload() error {
+	gl.GenVertexArrays(1, &scene.vao)
+	gl.BindVertexArray(scene.vao)
+
+	gl.GenBuffers(1, &scene.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, scene.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(scene.vertices)*4, gl.Ptr(scene.vertices), gl.STATIC_DRAW)
+
+	for _, attrib := range vertexAttribs {
+		gl.VertexAttribPointer(attrib.location, attrib.size, gl.FLOAT, false, vertexFloats*4, gl.PtrOffset(attrib.offset*4))
+		gl.EnableVertexAttribArray(attrib.location)
+	}
+
+	scene.vertices = nil
+
+	if err := scene.uploadAtlases(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// uploadAtlases packs the cached wall textures and flats into atlases,
+// uploads the atlas pages, and points every mesh at its atlas tile.
+func (scene *Scene) uploadAtlases() error {
+	var maxTextureSize int32
+	gl.GetIntegerv(gl.MAX_TEXTURE_SIZE, &maxTextureSize)
+	pageSize := int(maxTextureSize)
+	if pageSize > maxAtlasSize || pageSize <= 0 {
+		pageSize = maxAtlasSize
+	}
+
+	wallAtlas, err := NewAtlas(scene.textures, pageSize)
+	if err != nil {
+		return err
+	}
+	flatAtlas, err := NewAtlas(scene.flats, pageSize)
 	if err != nil {
 		return err
 	}
-	scene.textures[name] = texture
+	upload := func(page *image.RGBA, filter string) uint32 {
+		return uploadTexture(page, scene.settings, filter)
+	}
+	if scene.settings.Indexed {
+		upload = func(page *image.RGBA, filter string) uint32 {
+			return uploadIndexedTexture(page)
+		}
+		scene.SetPalette(scene.settings.Palette)
+	}
+	for _, page := range wallAtlas.Pages {
+		scene.wallPages = append(scene.wallPages, upload(page, scene.settings.WallFilter))
+	}
+	for _, page := range flatAtlas.Pages {
+		scene.flatPages = append(scene.flatPages, upload(page, scene.settings.FlatFilter))
+	}
+	scene.wallAtlas = wallAtlas
+
+	for id, meshes := range scene.meshes {
+		for i := range meshes {
+			mesh := &meshes[i]
+			atlas, pages := wallAtlas, scene.wallPages
+			if mesh.flat {
+				atlas, pages = flatAtlas, scene.flatPages
+			}
+			entry, ok := atlas.Lookup(mesh.texture)
+			if !ok {
+				continue
+			}
+			mesh.atlasPage = pages[entry.Page]
+			mesh.atlasRect = entry.Rect
+			mesh.fullbright = isFullbright(mesh.texture, scene.settings.Fullbright)
+		}
+		scene.meshes[id] = meshes
+	}
+	scene.textures = nil
+	scene.flats = nil
+	// The pages have been uploaded, only the atlas entries are needed for
+	// retexturing walls.
+	scene.wallAtlas.Pages = nil
 	return nil
 }
 
-func NewMesh(texture string, lightLevel int16, vertices []Point3) Mesh {
-	var vao uint32
-	gl.GenVertexArrays(1, &vao)
-	gl.BindVertexArray(vao)
+// SetWallTexture changes the texture of the walls of a sidedef from one
+// texture to another. The new texture must have been cached before the
+// scene was uploaded.
+func (scene *Scene) SetWallTexture(sidedef int, from string, to string) {
+	entry, ok := scene.wallAtlas.Lookup(to)
+	if !ok {
+		return
+	}
+	for _, meshes := range scene.meshes {
+		for i := range meshes {
+			mesh := &meshes[i]
+			if mesh.sidedef != sidedef || mesh.texture != from {
+				continue
+			}
+			mesh.texture = to
+			mesh.atlasPage = scene.wallPages[entry.Page]
+			mesh.atlasRect = entry.Rect
+			mesh.fullbright = isFullbright(to, scene.settings.Fullbright)
+		}
+	}
+}
+
+// CacheSwitchTextures caches the other texture of every switch whose
+// texture is used by the scene so that switches can be toggled.
+func (scene *Scene) CacheSwitchTextures(switches []wad.Switch) {
+	for _, sw := range switches {
+		if _, ok := scene.textures[sw.Off]; ok {
+			scene.CacheTexture(sw.On)
+		}
+		if _, ok := scene.textures[sw.On]; ok {
+			scene.CacheTexture(sw.Off)
+		}
+	}
+}
+
+func genSubsector(w *wad.WAD, level *wad.Level, ssectorId int, polygon []wad.Point, scene *Scene) {
+	ssector := level.SSectors[ssectorId]
+	for seg := ssector.StartSeg; seg < ssector.StartSeg+ssector.Numsegs; seg++ {
+		genSeg(w, level, ssectorId, int(seg), scene)
+	}
+	genFlats(w, level, ssectorId, polygon, scene)
+}
+
+// genFlats generates the floor and ceiling of a subsector.
+func genFlats(w *wad.WAD, level *wad.Level, ssectorId int, polygon []wad.Point, scene *Scene) {
+	sector, sectorId := level.SubsectorSector(ssectorId)
+	if sector == nil {
+		return
+	}
+	triangles := triangulatePolygon(cleanPolygon(polygon))
+	if len(triangles) == 0 {
+		triangles = triangulateSubsector(level, ssectorId)
+	}
+	if len(triangles) == 0 {
+		fmt.Printf("warning: Skipping floor and ceiling of sector %d subsector %d: degenerate subsector\n", sectorId, ssectorId)
+		return
+	}
+
+	meshes := scene.meshes[ssectorId]
+
+	floorTexture := wad.ToString(sector.Floorpic)
+	floor := scene.NewMesh(floorTexture, sector.Lightlevel, flatVertices(scene.settings.transform(), triangles, sector.FloorHeight))
+	floor.flat = true
+	floor.sector = sectorId
+	meshes = append(meshes, floor)
+	scene.CacheFlat(floorTexture)
+
+	ceilingTexture := wad.ToString(sector.Ceilingpic)
+	if ceilingTexture != skyFlat {
+		ceiling := scene.NewMesh(ceilingTexture, sector.Lightlevel, flatVertices(scene.settings.transform(), reverseWinding(triangles), sector.CeilingHeight))
+		ceiling.flat = true
+		ceiling.sector = sectorId
+		meshes = append(meshes, ceiling)
+		scene.CacheFlat(ceilingTexture)
+	}
+
+	scene.meshes[ssectorId] = meshes
+}
+
+// subsectorPolygons computes the outline of every subsector as a convex
+// polygon. Segs only cover the parts of a subsector's boundary that lie on
+// linedefs, so the polygon is found by clipping the map bounds against the
+// partition lines on the way down the BSP tree and finally against the
+// subsector's own segs.
+func subsectorPolygons(level *wad.Level) map[int][]wad.Point {
+	polygons := make(map[int][]wad.Point)
+	if len(level.Nodes) == 0 {
+		return polygons
+	}
+	root := level.Nodes[len(level.Nodes)-1]
+	top := math.Max(float64(root.BBox[0].Top), float64(root.BBox[1].Top))
+	bottom := math.Min(float64(root.BBox[0].Bottom), float64(root.BBox[1].Bottom))
+	left := math.Min(float64(root.BBox[0].Left), float64(root.BBox[1].Left))
+	right := math.Max(float64(root.BBox[0].Right), float64(root.BBox[1].Right))
+	bounds := []vec2{{left, bottom}, {left, top}, {right, top}, {right, bottom}}
+
+	var walk func(idx int, polygon []vec2)
+	walk = func(idx int, polygon []vec2) {
+		if idx&wad.SubsectorBit == wad.SubsectorBit {
+			ssectorId := int(uint16(idx) & ^uint16(wad.SubsectorBit))
+			polygons[ssectorId] = toPoints(clipSubsector(level, ssectorId, polygon))
+			return
+		}
+		node := level.Nodes[idx]
+		origin := vec2{float64(node.X), float64(node.Y)}
+		direction := vec2{float64(node.DX), float64(node.DY)}
+		walk(int(node.Child[0]), clipPolygon(polygon, origin, direction, 0))
+		walk(int(node.Child[1]), clipPolygon(polygon, origin, direction, 1))
+	}
+	walk(len(level.Nodes)-1, bounds)
+	return polygons
+}
+
+type vec2 struct {
+	x float64
+	y float64
+}
 
-	var vbo uint32
-	gl.GenBuffers(1, &vbo)
-	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+// clipSubsector clips a polygon to the front side of every seg in a
+// subsector.
+func clipSubsector(level *wad.Level, ssectorId int, polygon []vec2) []vec2 {
+	ssector := level.SSectors[ssectorId]
+	for segIdx := ssector.StartSeg; segIdx < ssector.StartSeg+ssector.Numsegs; segIdx++ {
+		seg := level.Segs[segIdx]
+		start := level.Vertexes[seg.VertexStart]
+		end := level.Vertexes[seg.VertexEnd]
+		origin := vec2{float64(start.XCoord), float64(start.YCoord)}
+		direction := vec2{float64(end.XCoord) - origin.x, float64(end.YCoord) - origin.y}
+		polygon = clipPolygon(polygon, origin, direction, 0)
+	}
+	return polygon
+}
 
-	vbo_data := []float32{}
-	for _, vertex := range vertices {
-		vbo_data = append(vbo_data, float32(vertex.X), float32(vertex.Y), float32(vertex.Z), vertex.U, vertex.V)
+// clipPolygon clips a convex polygon to one side of a partition line. Side
+// 0 is the front (right) side and side 1 is the back (left) side, which
+// matches the child order of BSP nodes.
+func clipPolygon(polygon []vec2, origin vec2, direction vec2, side int) []vec2 {
+	distance := func(p vec2) float64 {
+		d := direction.y*(p.x-origin.x) - direction.x*(p.y-origin.y)
+		if side == 1 {
+			return -d
+		}
+		return d
+	}
+	result := []vec2{}
+	for i, current := range polygon {
+		next := polygon[(i+1)%len(polygon)]
+		dc := distance(current)
+		dn := distance(next)
+		if dc >= 0 {
+			result = append(result, current)
+		}
+		if (dc > 0 && dn < 0) || (dc < 0 && dn > 0) {
+			t := dc / (dc - dn)
+			result = append(result, vec2{current.x + (next.x-current.x)*t, current.y + (next.y-current.y)*t})
+		}
 	}
-	gl.BufferData(gl.ARRAY_BUFFER, len(vbo_data)*4, gl.Ptr(vbo_data), gl.STATIC_DRAW)
+	return result
+}
 
-	vertexAttrib := uint32(0)
-	gl.VertexAttribPointer(vertexAttrib, 3, gl.FLOAT, false, 5*4, gl.PtrOffset(0))
-	gl.EnableVertexAttribArray(vertexAttrib)
+// toPoints rounds a polygon to map coordinates, dropping vertices that
+// collapse onto another vertex.
+func toPoints(polygon []vec2) []wad.Point {
+	points := pointSet{}
+	for _, v := range polygon {
+		points.add(wad.Point{X: int16(math.Floor(v.x + 0.5)), Y: int16(math.Floor(v.y + 0.5))})
+	}
+	return points.points
+}
 
-	texCoordAttrib := uint32(1)
-	gl.VertexAttribPointer(texCoordAttrib, 2, gl.FLOAT, false, 5*4, gl.PtrOffset(3*4))
-	gl.EnableVertexAttribArray(texCoordAttrib)
+// pointSet collects the outline of a subsector in order, ignoring points
+// that are already in it.
+type pointSet struct {
+	seen   map[wad.Point]bool
+	points []wad.Point
+}
 
-	return Mesh{vao: vao, vbo: vbo, texture: texture, count: len(vbo_data), lightLevel: float32(lightLevel) / 255.0}
+func (set *pointSet) add(p wad.Point) {
+	if set.seen == nil {
+		set.seen = make(map[wad.Point]bool)
+	}
+	if set.seen[p] {
+		return
+	}
+	set.seen[p] = true
+	set.points = append(set.points, p)
 }
 
-func genSubsector(wad *WAD, level *Level, ssectorId int, scene *Scene) {
+// triangulateSubsector fan-triangulates a subsector using only its segs.
+// Subsectors are convex, so walking the segs in order and closing the gaps
+// between consecutive segs gives the subsector outline. The implicit edges
+// that lie on partition lines are approximated by straight closing edges.
+func triangulateSubsector(level *wad.Level, ssectorId int) []wad.Point {
 	ssector := level.SSectors[ssectorId]
-	for seg := ssector.StartSeg; seg < ssector.StartSeg+ssector.Numsegs; seg++ {
-		genSeg(wad, level, ssectorId, int(seg), scene)
+	polygon := pointSet{}
+	for segIdx := ssector.StartSeg; segIdx < ssector.StartSeg+ssector.Numsegs; segIdx++ {
+		seg := level.Segs[segIdx]
+		start := level.Vertexes[seg.VertexStart]
+		end := level.Vertexes[seg.VertexEnd]
+		polygon.add(wad.Point{X: start.XCoord, Y: start.YCoord})
+		polygon.add(wad.Point{X: end.XCoord, Y: end.YCoord})
+	}
+	return triangulatePolygon(cleanPolygon(polygon.points))
+}
+
+// cleanPolygon removes points that are collinear with their neighbours
+// from a convex polygon without duplicate points, which would otherwise
+// produce zero-area triangles. The result has fewer than three points if
+// the polygon has no area.
+func cleanPolygon(polygon []wad.Point) []wad.Point {
+	points := append([]wad.Point{}, polygon...)
+	for removed := true; removed && len(points) >= 3; {
+		removed = false
+		for i := range points {
+			prev := points[(i+len(points)-1)%len(points)]
+			next := points[(i+1)%len(points)]
+			if cross(prev, points[i], next) == 0 {
+				points = append(points[:i], points[i+1:]...)
+				removed = true
+				break
+			}
+		}
+	}
+	if len(points) < 3 {
+		return nil
+	}
+	return points
+}
+
+// cross returns the cross product of the edges from b to a and from b to
+// c, which is zero if the points are collinear.
+func cross(a wad.Point, b wad.Point, c wad.Point) int {
+	return (int(a.X)-int(b.X))*(int(c.Y)-int(b.Y)) - (int(a.Y)-int(b.Y))*(int(c.X)-int(b.X))
+}
+
+// triangulatePolygon fan-triangulates a convex polygon and returns the
+// triangle vertices.
+func triangulatePolygon(polygon []wad.Point) []wad.Point {
+	triangles := []wad.Point{}
+	for i := 1; i < len(polygon)-1; i++ {
+		triangles = append(triangles, polygon[0], polygon[i], polygon[i+1])
+	}
+	return triangles
+}
+
+// reverseWinding reverses the vertex order of triangles so that they face
+// the other way.
+func reverseWinding(triangles []wad.Point) []wad.Point {
+	reversed := make([]wad.Point, 0, len(triangles))
+	for i := 0; i+2 < len(triangles); i += 3 {
+		reversed = append(reversed, triangles[i], triangles[i+2], triangles[i+1])
+	}
+	return reversed
+}
+
+// flatVertices places triangles at the given height. Flats tile on a 64x64
+// map unit grid aligned to the map origin with north at the top of the flat
+// like in vanilla Doom.
+func flatVertices(transform worldTransform, triangles []wad.Point, height int16) []Point3 {
+	vertices := []Point3{}
+	for _, p := range triangles {
+		vertices = append(vertices, Point3{Position: transform.doomToWorld(p.X, p.Y, height), U: float32(p.X) / flatSize, V: -float32(p.Y) / flatSize})
 	}
+	return vertices
 }
 
-func genSeg(wad *WAD, level *Level, ssectorId int, segId int, scene *Scene) {
+func genSeg(w *wad.WAD, level *wad.Level, ssectorId int, segId int, scene *Scene) {
 	seg := level.Segs[segId]
 
 	linedefId := int(seg.LineNum)
 
 	meshes := scene.meshes[ssectorId]
+	firstMesh := len(meshes)
 
 	linedef := level.Linedefs[linedefId]
 
-	sidedef := segSidedef(level, &seg, &linedef)
+	sidedef := level.SegSidedef(&seg, &linedef)
 	if sidedef == nil {
 		return
 	}
+	sidedefId := int(linedef.SidedefRight)
+	if seg.Segside != 0 {
+		sidedefId = int(linedef.SidedefLeft)
+	}
 	sector := level.Sectors[sidedef.SectorRef]
 
-	oppositeSidedef := segOppositeSidedef(level, &seg, &linedef)
+	oppositeSidedef := level.SegOppositeSidedef(&seg, &linedef)
 
 	start := level.Vertexes[seg.VertexStart]
 	end := level.Vertexes[seg.VertexEnd]
 
-	upperTexture := ToString(sidedef.UpperTexture)
-	middleTexture := ToString(sidedef.MiddleTexture)
-	lowerTexture := ToString(sidedef.LowerTexture)
+	// With smooth lighting, the edges that a wall shares with the floor or
+	// ceiling of the opposite sector get the average light level of both
+	// sectors, so the light fades across the line instead of changing at
+	// once.
+	edgeLight := float32(0)
+	var oppositeSector *wad.Sector
+	if oppositeSidedef != nil && scene.settings.SmoothLighting {
+		oppositeSector = &level.Sectors[oppositeSidedef.SectorRef]
+		edgeLight = (float32(oppositeSector.Lightlevel) - float32(sector.Lightlevel)) / 2 / 255.0
+	}
+	wallPoint := func(vertex *wad.Vertex, height int16, u float32, v float32) Point3 {
+		point := Point3{Position: scene.settings.transform().doomToWorld(vertex.XCoord, vertex.YCoord, height), U: u, V: v}
+		if oppositeSector != nil && (height == oppositeSector.FloorHeight || height == oppositeSector.CeilingHeight) {
+			point.Light = edgeLight
+		}
+		return point
+	}
+
+	length := segLength(&seg, &start, &end)
+	uStart := float32(seg.Segoffset) + float32(sidedef.XOffset)
+	uEnd := uStart + length
+
+	upperTexture := wad.ToString(sidedef.UpperTexture)
+	middleTexture := wad.ToString(sidedef.MiddleTexture)
+	lowerTexture := wad.ToString(sidedef.LowerTexture)
 
 	if upperTexture != "-" && oppositeSidedef != nil {
 		oppositeSector := level.Sectors[oppositeSidedef.SectorRef]
 
+		u0, u1 := textureU(w, upperTexture, uStart, uEnd)
+
 		vertices := []Point3{}
 
-		vertices = append(vertices, Point3{X: -start.XCoord, Y: sector.CeilingHeight, Z: start.YCoord, U: 0.0, V: 1.0})
-		vertices = append(vertices, Point3{X: -start.XCoord, Y: oppositeSector.CeilingHeight, Z: start.YCoord, U: 0.0, V: 0.0})
-		vertices = append(vertices, Point3{X: -end.XCoord, Y: oppositeSector.CeilingHeight, Z: end.YCoord, U: 1.0, V: 0.0})
+		vertices = append(vertices, wallPoint(&start, oppositeSector.CeilingHeight, u0, 0.0))
+		vertices = append(vertices, wallPoint(&start, sector.CeilingHeight, u0, 1.0))
+		vertices = append(vertices, wallPoint(&end, sector.CeilingHeight, u1, 1.0))
 
-		vertices = append(vertices, Point3{X: -end.XCoord, Y: oppositeSector.CeilingHeight, Z: end.YCoord, U: 1.0, V: 0.0})
-		vertices = append(vertices, Point3{X: -end.XCoord, Y: sector.CeilingHeight, Z: end.YCoord, U: 1.0, V: 1.0})
-		vertices = append(vertices, Point3{X: -start.XCoord, Y: sector.CeilingHeight, Z: start.YCoord, U: 0.0, V: 1.0})
+		vertices = append(vertices, wallPoint(&end, sector.CeilingHeight, u1, 1.0))
+		vertices = append(vertices, wallPoint(&end, oppositeSector.CeilingHeight, u1, 0.0))
+		vertices = append(vertices, wallPoint(&start, oppositeSector.CeilingHeight, u0, 0.0))
 
-		meshes = append(meshes, NewMesh(upperTexture, sector.Lightlevel, vertices))
+		meshes = append(meshes, scene.NewMesh(upperTexture, sector.Lightlevel, vertices))
 
-		scene.CacheTexture(wad, upperTexture)
+		scene.CacheTexture(upperTexture)
 	}
 
 	if middleTexture != "-" {
+		u0, u1 := textureU(w, middleTexture, uStart, uEnd)
+
 		vertices := []Point3{}
 
-		vertices = append(vertices, Point3{X: -start.XCoord, Y: sector.FloorHeight, Z: start.YCoord, U: 0.0, V: 1.0})
-		vertices = append(vertices, Point3{X: -start.XCoord, Y: sector.CeilingHeight, Z: start.YCoord, U: 0.0, V: 0.0})
-		vertices = append(vertices, Point3{X: -end.XCoord, Y: sector.CeilingHeight, Z: end.YCoord, U: 1.0, V: 0.0})
+		vertices = append(vertices, wallPoint(&start, sector.FloorHeight, u0, 1.0))
+		vertices = append(vertices, wallPoint(&start, sector.CeilingHeight, u0, 0.0))
+		vertices = append(vertices, wallPoint(&end, sector.CeilingHeight, u1, 0.0))
 
-		vertices = append(vertices, Point3{X: -end.XCoord, Y: sector.CeilingHeight, Z: end.YCoord, U: 1.0, V: 0.0})
-		vertices = append(vertices, Point3{X: -end.XCoord, Y: sector.FloorHeight, Z: end.YCoord, U: 1.0, V: 1.0})
-		vertices = append(vertices, Point3{X: -start.XCoord, Y: sector.FloorHeight, Z: start.YCoord, U: 0.0, V: 1.0})
+		vertices = append(vertices, wallPoint(&end, sector.CeilingHeight, u1, 0.0))
+		vertices = append(vertices, wallPoint(&end, sector.FloorHeight, u1, 1.0))
+		vertices = append(vertices, wallPoint(&start, sector.FloorHeight, u0, 1.0))
 
-		meshes = append(meshes, NewMesh(middleTexture, sector.Lightlevel, vertices))
+		mesh := scene.NewMesh(middleTexture, sector.Lightlevel, vertices)
+		if oppositeSidedef != nil {
+			mesh.doubleSided = true
+			if linedef.Function == linedefTranslucent && linedef.Tag == 0 {
+				mesh.alpha = translucentAlpha
+			}
+		}
+		meshes = append(meshes, mesh)
 
-		scene.CacheTexture(wad, middleTexture)
+		scene.CacheTexture(middleTexture)
 	}
 
 	if lowerTexture != "-" && oppositeSidedef != nil {
 		oppositeSector := level.Sectors[oppositeSidedef.SectorRef]
 
+		u0, u1 := textureU(w, lowerTexture, uStart, uEnd)
+
 		vertices := []Point3{}
 
-		vertices = append(vertices, Point3{X: -start.XCoord, Y: sector.FloorHeight, Z: start.YCoord, U: 0.0, V: 1.0})
-		vertices = append(vertices, Point3{X: -start.XCoord, Y: oppositeSector.FloorHeight, Z: start.YCoord, U: 0.0, V: 0.0})
-		vertices = append(vertices, Point3{X: -end.XCoord, Y: oppositeSector.FloorHeight, Z: end.YCoord, U: 1.0, V: 0.0})
+		vertices = append(vertices, wallPoint(&start, sector.FloorHeight, u0, 1.0))
+		vertices = append(vertices, wallPoint(&start, oppositeSector.FloorHeight, u0, 0.0))
+		vertices = append(vertices, wallPoint(&end, oppositeSector.FloorHeight, u1, 0.0))
 
-		vertices = append(vertices, Point3{X: -end.XCoord, Y: oppositeSector.FloorHeight, Z: end.YCoord, U: 1.0, V: 0.0})
-		vertices = append(vertices, Point3{X: -end.XCoord, Y: sector.FloorHeight, Z: end.YCoord, U: 1.0, V: 1.0})
-		vertices = append(vertices, Point3{X: -start.XCoord, Y: sector.FloorHeight, Z: start.YCoord, U: 0.0, V: 1.0})
+		vertices = append(vertices, wallPoint(&end, oppositeSector.FloorHeight, u1, 0.0))
+		vertices = append(vertices, wallPoint(&end, sector.FloorHeight, u1, 1.0))
+		vertices = append(vertices, wallPoint(&start, sector.FloorHeight, u0, 1.0))
 
-		meshes = append(meshes, NewMesh(lowerTexture, sector.Lightlevel, vertices))
+		meshes = append(meshes, scene.NewMesh(lowerTexture, sector.Lightlevel, vertices))
 
-		scene.CacheTexture(wad, lowerTexture)
+		scene.CacheTexture(lowerTexture)
 	}
 
+	for i := firstMesh; i < len(meshes); i++ {
+		meshes[i].sidedef = sidedefId
+		meshes[i].sector = int(sidedef.SectorRef)
+		if linedef.Function == linedefScrollLeft && seg.Segside == 0 {
+			meshes[i].scrollTics = int(textureWidth(w, meshes[i].texture))
+		}
+	}
 	scene.meshes[ssectorId] = meshes
 }
 
-func segSidedef(level *Level, seg *Seg, linedef *Linedef) *Sidedef {
-	if seg.Segside == 0 {
-		return &level.Sidedefs[linedef.SidedefRight]
-	} else {
-		if linedef.SidedefLeft == -1 {
-			return nil
-		}
-		return &level.Sidedefs[linedef.SidedefLeft]
-	}
+// segLength returns the length of a seg in map units. The seg's BAMS angle
+// gives its direction, so the length is the projection of the vertex delta
+// onto that direction.
+func segLength(seg *wad.Seg, start *wad.Vertex, end *wad.Vertex) float32 {
+	sin, cos := math.Sincos(wad.BamsToRadians(seg.Bams))
+	dx := float64(end.XCoord) - float64(start.XCoord)
+	dy := float64(end.YCoord) - float64(start.YCoord)
+	return float32(math.Abs(dx*cos + dy*sin))
 }
 
-func segOppositeSidedef(level *Level, seg *Seg, linedef *Linedef) *Sidedef {
-	if seg.Segside == 0 {
-		if linedef.SidedefLeft == -1 {
-			return nil
-		}
-		return &level.Sidedefs[linedef.SidedefLeft]
-	} else {
-		return &level.Sidedefs[linedef.SidedefRight]
+// textureU converts horizontal texture offsets in map units to texture
+// coordinates for the named wall texture.
+func textureU(w *wad.WAD, name string, start float32, end float32) (float32, float32) {
+	width := textureWidth(w, name)
+	return start / width, end / width
+}
+
+// textureWidth returns the width of a wall texture, or 64 if the texture
+// cannot be loaded.
+func textureWidth(w *wad.WAD, name string) float32 {
+	texture, err := w.LoadTexture(name)
+	if err == nil && texture.Header != nil && texture.Header.Width > 0 {
+		return float32(texture.Header.Width)
 	}
+	return 64
 }
 
-type bspFilter func(level *Level, nodeId int) bool
+type bspFilter func(level *wad.Level, nodeId int) bool
 
-type bspAction func(level *Level, subsectorId int)
+type bspAction func(level *wad.Level, subsectorId int)
 
-func traverseBsp(level *Level, point *Point, idx int, filter bspFilter, action bspAction) {
-	if idx&subsectorBit == subsectorBit {
+func traverseBsp(level *wad.Level, point *wad.Point, idx int, filter bspFilter, action bspAction) {
+	if idx&wad.SubsectorBit == wad.SubsectorBit {
+		// A level with a single subsector has no nodes.
 		if idx == -1 {
-			action(level, 0)
+			if len(level.SSectors) > 0 {
+				action(level, 0)
+			}
 			return
 		} else {
-			action(level, int(uint16(idx) & ^uint16(subsectorBit)))
+			action(level, int(uint16(idx) & ^uint16(wad.SubsectorBit)))
 			return
 		}
 	}
 	node := level.Nodes[idx]
-	side := pointOnSide(point, &node)
+	side := wad.PointOnSide(point, &node)
 	sideIdx := int(node.Child[side])
 	traverseBsp(level, point, sideIdx, filter, action)
 	oppositeSide := side ^ 1
@@ -260,241 +999,1375 @@ func traverseBsp(level *Level, point *Point, idx int, filter bspFilter, action b
 	}
 }
 
-func pointOnSide(point *Point, node *Node) int {
-	dx := int(point.X) - int(node.X)
-	dy := int(point.Y) - int(node.Y)
-	// Perp dot product:
-	left := int(node.DY>>16) * dx
-	right := int(node.DX>>16) * dy
-	if right < left {
-		// Point is on front side:
-		return 0
-	}
-	// Point is on the back side:
-	return 1
-}
-
-func intersects(point *Point, bbox *BBox) bool {
-	return point.X > bbox.Left && point.X < bbox.Right && point.Y > bbox.Bottom && point.Y < bbox.Top
-}
-
-func findSector(level *Level, point *Point, idx int) *Sector {
-	if idx&subsectorBit == subsectorBit {
-		idx = int(uint16(idx) & ^uint16(subsectorBit))
-		ssector := level.SSectors[idx]
-		for segIdx := ssector.StartSeg; segIdx < ssector.StartSeg+ssector.Numsegs; segIdx++ {
-			seg := level.Segs[segIdx]
-			linedef := level.Linedefs[seg.LineNum]
-			sidedef := segSidedef(level, &seg, &linedef)
-			if sidedef != nil {
-				return &level.Sectors[sidedef.SectorRef]
-			}
-			oppositeSidedef := segOppositeSidedef(level, &seg, &linedef)
-			if oppositeSidedef != nil {
-				return &level.Sectors[oppositeSidedef.SectorRef]
-			}
-		}
-	}
-	node := level.Nodes[idx]
-	if intersects(point, &node.BBox[0]) {
-		return findSector(level, point, int(node.Child[0]))
-	}
-	if intersects(point, &node.BBox[1]) {
-		return findSector(level, point, int(node.Child[1]))
-	}
-	return nil
-}
+// verbose receives progress messages that are only printed with --verbose.
+var verbose = log.New(io.Discard, "", 0)
 
 func main() {
 	runtime.LockOSThread()
 	app := cli.NewApp()
 	app.Name = "godoom"
 	app.Usage = "A Doom clone written in Go!"
+	app.HideVersion = true
+	app.Before = func(c *cli.Context) error {
+		if c.Bool("verbose") {
+			verbose.SetOutput(os.Stdout)
+			wad.Logger.SetOutput(os.Stdout)
+		}
+		return nil
+	}
 	app.Flags = []cli.Flag{
+		cli.BoolFlag{
+			Name:  "verbose,v",
+			Usage: "Print progress messages while loading",
+		},
 		cli.StringFlag{
 			Name:  "file,f",
 			Usage: "WAD archive",
 			Value: "doom1.wad",
 		},
+		cli.IntFlag{
+			Name:  "skill",
+			Usage: "Skill level from 1 (I'm too young to die) to 5 (Nightmare!)",
+			Value: SkillMedium,
+		},
+		cli.StringSliceFlag{
+			Name:  "pwad",
+			Usage: "PWAD whose lumps and levels are added to the WAD archive, can be repeated",
+		},
 		cli.IntFlag{
 			Name:  "level,l",
 			Usage: "Level number",
 			Value: 1,
 		},
-	}
-	app.Action = func(c *cli.Context) {
-		file := c.String("file")
-		levelNumber := c.Int("level")
-		levelIdx := levelNumber - 1
-		fmt.Printf("Loading WAD archive '%s' ...\n", file)
-		wad, err := ReadWAD(file)
-		if err != nil {
-			fmt.Printf("error: %s\n", err)
-			os.Exit(1)
-		}
-		levelNames := wad.LevelNames()
-		if len(levelNames) == 0 {
-			fmt.Printf("error: No levels found!\n")
-			os.Exit(1)
-		}
-		if levelIdx >= len(levelNames) {
-			fmt.Printf("error: No such level number %d!\n", levelNumber)
-			os.Exit(1)
-		}
-		fmt.Printf("Levels:\n")
-		for i, level := range wad.LevelNames() {
-			selected := ""
-			if i == levelIdx {
-				selected = " [*]"
-			}
-			fmt.Printf("  %s%s\n", level, selected)
-		}
-		levelName := levelNames[levelIdx]
-		fmt.Printf("Loading level %s ...\n", levelName)
-		level, err := wad.ReadLevel(levelName)
-		if err != nil {
-			fmt.Printf("error: %s\n", err)
-			os.Exit(1)
-		}
-		player1 := level.Things[1]
-		position := &Point{
-			X: player1.XPosition,
-			Y: player1.YPosition,
-		}
-		game(wad, level, position, player1.Angle)
-	}
-	app.Run(os.Args)
-}
-
-func game(wad *WAD, level *Level, startPos *Point, startAngle int16) {
-	runtime.LockOSThread()
-
-	if err := glfw.Init(); err != nil {
-		log.Fatalln("failed to initialize glfw:", err)
-	}
-	defer glfw.Terminate()
-
-	glfw.WindowHint(glfw.Resizable, glfw.True)
-	glfw.WindowHint(glfw.ContextVersionMajor, 3)
-	glfw.WindowHint(glfw.ContextVersionMinor, 3)
-	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
-	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+		cli.IntFlag{
+			Name:  "episode",
+			Usage: "Episode number of an ExMy level, used with --map",
+		},
+		cli.IntFlag{
+			Name:  "map",
+			Usage: "Map number of an ExMy level, used with --episode",
+		},
+		cli.StringFlag{
+			Name:  "start-pos",
+			Usage: "Start position as X,Y in map coordinates instead of the player 1 start",
+		},
+		cli.IntFlag{
+			Name:  "start-angle",
+			Usage: "Start angle in degrees instead of the player 1 start angle",
+		},
+		cli.Float64Flag{
+			Name:  "fog",
+			Usage: "Fog density (0 disables fog)",
+			Value: 0,
+		},
+		cli.StringFlag{
+			Name:  "fog-color",
+			Usage: "Fog color as RRGGBB",
+			Value: "4c4c4c",
+		},
+		cli.StringFlag{
+			Name:  "filter",
+			Usage: "Texture filtering (linear or nearest)",
+			Value: FilterLinear,
+		},
+		cli.StringFlag{
+			Name:  "wall-filter",
+			Usage: "Texture filtering of walls, overrides --filter",
+		},
+		cli.StringFlag{
+			Name:  "flat-filter",
+			Usage: "Texture filtering of floors and ceilings, overrides --filter",
+		},
+		cli.BoolFlag{
+			Name:  "sector-highlight",
+			Usage: "Tint the sector that the player is in for debugging",
+		},
+		cli.BoolFlag{
+			Name:  "indexed",
+			Usage: "Upload textures as palette indices and apply the palette in the shader",
+		},
+		cli.BoolFlag{
+			Name:  "smooth-lighting",
+			Usage: "Blend wall light levels across sector boundaries",
+		},
+		cli.BoolFlag{
+			Name:  "mirror",
+			Usage: "Mirror the map left to right in the 3D view",
+		},
+		cli.BoolFlag{
+			Name:  "no-vsync",
+			Usage: "Disable vertical synchronization",
+		},
+		cli.BoolFlag{
+			Name:  "no-mipmaps",
+			Usage: "Disable mipmapping for the vanilla look",
+		},
+		cli.Float64Flag{
+			Name:  "anisotropy",
+			Usage: "Anisotropic filtering level (1 disables it)",
+			Value: 16,
+		},
+		cli.StringFlag{
+			Name:  "window-size",
+			Usage: "Initial window size as WIDTHxHEIGHT",
+			Value: "1280x720",
+		},
+		cli.StringFlag{
+			Name:  "render-to",
+			Usage: "Render one frame offscreen to a PNG image and exit",
+		},
+		cli.StringFlag{
+			Name:  "render-size",
+			Usage: "Image size of --render-to as WIDTHxHEIGHT",
+			Value: "1920x1080",
+		},
+		cli.IntFlag{
+			Name:  "bench",
+			Usage: "Render N frames without vsync, print frame timings, and exit",
+		},
+		cli.Float64Flag{
+			Name:  "gamma",
+			Usage: "Gamma correction (F11 cycles through levels in game)",
+			Value: 1.0,
+		},
+		cli.BoolFlag{
+			Name:  "scene-cache",
+			Usage: "Cache generated scenes on disk to speed up startup",
+		},
+		cli.StringFlag{
+			Name:  "fullbright",
+			Usage: "Comma-separated texture name prefixes rendered at full brightness",
+			Value: strings.Join(fullbrightTextures, ","),
+		},
+		cli.Float64Flag{
+			Name:  "view-bob",
+			Usage: "Amplitude of the view bob while walking in map units (0 disables it)",
+		},
+		cli.Float64Flag{
+			Name:  "near",
+			Usage: "Distance of the near clip plane in map units",
+			Value: defaultNear,
+		},
+		cli.Float64Flag{
+			Name:  "far",
+			Usage: "Distance of the far clip plane in map units",
+			Value: defaultFar,
+		},
+		cli.StringFlag{
+			Name:  "replay",
+			Usage: "Replay a camera path recorded with F6 and exit",
+		},
+		cli.Float64Flag{
+			Name:  "time-scale",
+			Usage: "Speed of game time, below 1 for slow motion",
+			Value: 1.0,
+		},
+		cli.Float64Flag{
+			Name:  "thing-scale",
+			Usage: "Size of thing sprites in map units per pixel",
+			Value: 1.0,
+		},
+		cli.Float64Flag{
+			Name:  "deadzone",
+			Usage: "Gamepad stick deflection that is ignored, from 0 to 1",
+			Value: defaultGamepadDeadzone,
+		},
+		cli.IntFlag{
+			Name:  "palette",
+			Usage: fmt.Sprintf("PLAYPAL palette used for textures and flats (0-%d)", wad.NumPalettes-1),
+		},
+	}
+	app.Action = func(c *cli.Context) {
+		file := c.String("file")
+		fogColor, err := parseColor(c.String("fog-color"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		filter := c.String("filter")
+		wallFilter, flatFilter := filter, filter
+		if c.IsSet("wall-filter") {
+			wallFilter = c.String("wall-filter")
+		}
+		if c.IsSet("flat-filter") {
+			flatFilter = c.String("flat-filter")
+		}
+		for _, filter := range []string{filter, wallFilter, flatFilter} {
+			if filter != FilterLinear && filter != FilterNearest {
+				fmt.Printf("error: Unknown texture filter '%s'!\n", filter)
+				os.Exit(1)
+			}
+		}
+		settings := &RenderSettings{
+			FogDensity:     float32(c.Float64("fog")),
+			FogColor:       fogColor,
+			WallFilter:     wallFilter,
+			FlatFilter:     flatFilter,
+			Mipmaps:        !c.Bool("no-mipmaps"),
+			Anisotropy:     float32(c.Float64("anisotropy")),
+			Bench:          c.Int("bench"),
+			Gamma:          float32(c.Float64("gamma")),
+			SceneCache:     c.Bool("scene-cache"),
+			Fullbright:     strings.Split(c.String("fullbright"), ","),
+			Palette:        c.Int("palette"),
+			ViewBob:        float32(c.Float64("view-bob")),
+			Replay:         c.String("replay"),
+			Near:           float32(c.Float64("near")),
+			Far:            float32(c.Float64("far")),
+			VSync:          !c.Bool("no-vsync"),
+			Highlight:      c.Bool("sector-highlight"),
+			Indexed:        c.Bool("indexed"),
+			SmoothLighting: c.Bool("smooth-lighting"),
+			Mirror:         c.Bool("mirror"),
+			RenderTo:       c.String("render-to"),
+			ThingScale:     float32(c.Float64("thing-scale")),
+			Deadzone:       float32(c.Float64("deadzone")),
+		}
+		if settings.Gamma <= 0 {
+			fmt.Printf("error: Gamma must be positive!\n")
+			os.Exit(1)
+		}
+		if settings.ThingScale <= 0 {
+			fmt.Printf("error: Thing scale must be positive!\n")
+			os.Exit(1)
+		}
+		if settings.Deadzone < 0 || settings.Deadzone >= 1 {
+			fmt.Printf("error: Deadzone must be at least 0 and less than 1!\n")
+			os.Exit(1)
+		}
+		if settings.Near <= 0 || settings.Far <= settings.Near {
+			fmt.Printf("error: Clip planes must satisfy 0 < near < far!\n")
+			os.Exit(1)
+		}
+		skill := c.Int("skill")
+		if skill < SkillBaby || skill > SkillNightmare {
+			fmt.Printf("error: Skill must be between %d and %d!\n", SkillBaby, SkillNightmare)
+			os.Exit(1)
+		}
+		gameSettings := NewGameSettings(skill)
+		gameSettings.TimeScale = c.Float64("time-scale")
+		if gameSettings.TimeScale <= 0 {
+			fmt.Printf("error: Time scale must be positive!\n")
+			os.Exit(1)
+		}
+		if settings.Palette < 0 || settings.Palette >= wad.NumPalettes {
+			fmt.Printf("error: Palette must be between 0 and %d!\n", wad.NumPalettes-1)
+			os.Exit(1)
+		}
+		settings.Width, settings.Height, err = parseWindowSize(c.String("window-size"))
+		if err != nil {
+			fmt.Printf("warning: %s, using %dx%d\n", err, defaultWidth, defaultHeight)
+			settings.Width, settings.Height = defaultWidth, defaultHeight
+		}
+		if settings.RenderTo != "" {
+			settings.RenderWidth, settings.RenderHeight, err = parseWindowSize(c.String("render-size"))
+			if err != nil {
+				fmt.Printf("error: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		verbose.Printf("Loading WAD archive '%s' ...\n", file)
+		w, err := wad.ReadWAD(file)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		for _, pwad := range c.StringSlice("pwad") {
+			verbose.Printf("Adding PWAD '%s' ...\n", pwad)
+			if err := w.AddPWAD(pwad); err != nil {
+				fmt.Printf("error: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		levelName, err := selectLevelFlags(w, c)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		verbose.Printf("Levels:\n")
+		for _, level := range w.LevelNames() {
+			selected := ""
+			if level == levelName {
+				selected = " [*]"
+			}
+			verbose.Printf("  %s%s\n", level, selected)
+		}
+		verbose.Printf("Loading level %s ...\n", levelName)
+		settings.LevelName = levelName
+		level, err := w.ReadLevel(levelName)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		position, angle, startErr := playerStart(level)
+		if startPos := c.String("start-pos"); startPos != "" {
+			position, err = parsePosition(startPos)
+			if err != nil {
+				fmt.Printf("error: %s\n", err)
+				os.Exit(1)
+			}
+			if bbox := level.Bounds(); position.X < bbox.Left || position.X > bbox.Right || position.Y < bbox.Bottom || position.Y > bbox.Top {
+				fmt.Printf("warning: Start position (%d, %d) is outside of the map (%d, %d) - (%d, %d)\n", position.X, position.Y, bbox.Left, bbox.Bottom, bbox.Right, bbox.Top)
+			}
+		} else if startErr != nil {
+			fmt.Printf("error: %s\n", startErr)
+			os.Exit(1)
+		}
+		if c.IsSet("start-angle") {
+			angle = int16(c.Int("start-angle"))
+		}
+		if err := game(w, level, position, angle, settings, gameSettings); err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	app.Commands = []cli.Command{
+		statsCommand,
+		dumpTexturesCommand,
+		dumpFlatsCommand,
+		dumpSpritesCommand,
+		intermissionCommand,
+		showpicCommand,
+		texinfoCommand,
+		patchesCommand,
+		demosCommand,
+		thingsCommand,
+		renderCommand,
+	}
+	app.Run(os.Args)
+}
+
+// playerStart returns the start position and angle of player 1.
+func playerStart(level *wad.Level) (*wad.Point, int16, error) {
+	for _, thing := range level.Things {
+		if thing.Type == wad.ThingPlayer1Start {
+			return &wad.Point{X: thing.XPosition, Y: thing.YPosition}, thing.Angle, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("no player 1 start")
+}
+
+// selectLevel returns the name of the level with the given one-based level
+// number.
+func selectLevel(w *wad.WAD, levelNumber int) (string, error) {
+	levelNames := w.LevelNames()
+	if len(levelNames) == 0 {
+		return "", fmt.Errorf("No levels found!")
+	}
+	if levelNumber < 1 || levelNumber > len(levelNames) {
+		return "", fmt.Errorf("No such level number %d!", levelNumber)
+	}
+	return levelNames[levelNumber-1], nil
+}
+
+// selectEpisodeLevel returns the name of the ExMy level of an episodic
+// WAD archive.
+func selectEpisodeLevel(w *wad.WAD, episode int, mapNumber int) (string, error) {
+	name := fmt.Sprintf("E%dM%d", episode, mapNumber)
+	for _, levelName := range w.LevelNames() {
+		if levelName == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("No such level %s!", name)
+}
+
+// selectLevelFlags returns the name of the level selected on the command
+// line either with --episode and --map or with --level.
+func selectLevelFlags(w *wad.WAD, c *cli.Context) (string, error) {
+	episode, mapNumber := c.Int("episode"), c.Int("map")
+	if episode == 0 && mapNumber == 0 {
+		return selectLevel(w, c.Int("level"))
+	}
+	if episode < 1 || mapNumber < 1 {
+		return "", fmt.Errorf("Both --episode and --map must be given!")
+	}
+	return selectEpisodeLevel(w, episode, mapNumber)
+}
+
+// parseWindowSize parses a window size in WIDTHxHEIGHT notation.
+func parseWindowSize(s string) (int, int, error) {
+	var width, height int
+	if n, err := fmt.Sscanf(s, "%dx%d", &width, &height); err != nil || n != 2 || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid window size '%s'", s)
+	}
+	return width, height, nil
+}
+
+// parsePosition parses a map position in X,Y notation.
+func parsePosition(s string) (*wad.Point, error) {
+	var x, y int16
+	if n, err := fmt.Sscanf(s, "%d,%d", &x, &y); err != nil || n != 2 {
+		return nil, fmt.Errorf("invalid position '%s'", s)
+	}
+	return &wad.Point{X: x, Y: y}, nil
+}
+
+// parseColor parses a color in RRGGBB hex notation.
+func parseColor(s string) (mgl32.Vec3, error) {
+	var r, g, b uint8
+	if n, err := fmt.Sscanf(strings.TrimPrefix(s, "#"), "%02x%02x%02x", &r, &g, &b); err != nil || n != 3 {
+		return mgl32.Vec3{}, fmt.Errorf("invalid color '%s'", s)
+	}
+	return mgl32.Vec3{float32(r) / 255.0, float32(g) / 255.0, float32(b) / 255.0}, nil
+}
+
+// openWindow initializes GLFW and opens a window with a current OpenGL 3.3
+// core context. The error explains which version is missing if the driver
+// cannot create one. The caller is responsible for destroying the window and
+// terminating GLFW unless an error is returned.
+func openWindow(settings *RenderSettings) (*glfw.Window, error) {
+	runtime.LockOSThread()
+
+	if err := glfw.Init(); err != nil {
+		return nil, fmt.Errorf("failed to initialize glfw: %s", err)
+	}
+
+	glfw.WindowHint(glfw.Resizable, glfw.True)
+	glfw.WindowHint(glfw.ContextVersionMajor, glMajorVersion)
+	glfw.WindowHint(glfw.ContextVersionMinor, glMinorVersion)
+	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+	if settings.RenderTo != "" {
+		// Offscreen rendering only needs the context of the window.
+		glfw.WindowHint(glfw.Visible, glfw.False)
+	}
+
+	window, err := glfw.CreateWindow(settings.Width, settings.Height, "GoDoom", nil, nil)
+	if err != nil {
+		// The shaders need GLSL 3.30, so there is no lower version to
+		// fall back to. Tell the user what the driver offers instead.
+		available := availableGLVersion()
+		glfw.Terminate()
+		return nil, fmt.Errorf("failed to create window with an OpenGL %d.%d core profile context (%s): %s. "+
+			"Update the graphics driver, or on a headless system run under a virtual display such as Xvfb with Mesa",
+			glMajorVersion, glMinorVersion, available, err)
+	}
+
+	window.MakeContextCurrent()
+	glfw.SwapInterval(swapInterval(settings.VSync))
+
+	if err := gl.Init(); err != nil {
+		window.Destroy()
+		glfw.Terminate()
+		return nil, fmt.Errorf("failed to initialize OpenGL: %s", err)
+	}
+	verbose.Printf("OpenGL %s on %s\n", gl.GoStr(gl.GetString(gl.VERSION)), gl.GoStr(gl.GetString(gl.RENDERER)))
+
+	// On HiDPI displays the window size is in screen coordinates, which
+	// differs from the framebuffer size in pixels. Rendering always uses
+	// the framebuffer size, so follow it when the window is resized or
+	// moved to a display with a different scale.
+	window.SetFramebufferSizeCallback(func(window *glfw.Window, width int, height int) {
+		gl.Viewport(0, 0, int32(width), int32(height))
+	})
 
-	window, err := glfw.CreateWindow(640, 480, "GoDoom", nil, nil)
+	return window, nil
+}
+
+// The OpenGL version that the renderer needs.
+const (
+	glMajorVersion = 3
+	glMinorVersion = 3
+)
+
+// availableGLVersion describes the OpenGL version of a context that the
+// driver creates without any version requested, for error messages.
+func availableGLVersion() string {
+	glfw.DefaultWindowHints()
+	glfw.WindowHint(glfw.Visible, glfw.False)
+	window, err := glfw.CreateWindow(1, 1, "GoDoom", nil, nil)
 	if err != nil {
-		panic(err)
+		return "no OpenGL context is available"
 	}
+	defer window.Destroy()
+	major := window.GetAttrib(glfw.ContextVersionMajor)
+	minor := window.GetAttrib(glfw.ContextVersionMinor)
+	return fmt.Sprintf("the driver provides OpenGL %d.%d", major, minor)
+}
 
+// swapInterval returns the buffer swap interval for a vertical
+// synchronization setting.
+func swapInterval(vsync bool) int {
+	if vsync {
+		return 1
+	}
+	return 0
+}
+
+func game(w *wad.WAD, level *wad.Level, startPos *wad.Point, startAngle int16, settings *RenderSettings, gameSettings *GameSettings) error {
+	window, err := openWindow(settings)
+	if err != nil {
+		return err
+	}
+	defer glfw.Terminate()
 	defer window.Destroy()
 
 	window.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
 
-	window.MakeContextCurrent()
-	glfw.SwapInterval(1)
+	settings.Anisotropy = supportedAnisotropy(settings.Anisotropy)
 
-	gl.Init()
+	gamepad := NewGamepad(settings.Deadzone)
 
+	for {
+		next, err := playLevel(window, gamepad, w, level, startPos, startAngle, settings, gameSettings)
+		if err != nil || next == "" {
+			return err
+		}
+		verbose.Printf("Loading level %s ...\n", next)
+		level, err = w.ReadLevel(next)
+		if err != nil {
+			return err
+		}
+		settings.LevelName = next
+		startPos, startAngle, err = playerStart(level)
+		if err != nil {
+			return fmt.Errorf("level %s: %s", next, err)
+		}
+	}
+}
+
+// playLevel plays a level until the window is closed or another level is
+// picked from the menu. It returns the name of the level to play next or an
+// empty string to quit.
+func playLevel(window *glfw.Window, gamepad *Gamepad, w *wad.WAD, level *wad.Level, startPos *wad.Point, startAngle int16, settings *RenderSettings, gameSettings *GameSettings) (string, error) {
 	speed := float32(5.0)
 
 	position := mgl32.Vec2{float32(startPos.X), float32(startPos.Y)}
 
-	angle := startAngle
+	transform := settings.transform()
+	angle := transform.mapAngleToView(startAngle)
 
-	fmt.Printf("Generating scene ...\n")
-	scene := NewScene()
-	var all bspFilter = func(level *Level, nodeId int) bool {
-		return true
+	scene, err := buildScene(w, level, settings)
+	if err != nil {
+		return "", err
+	}
+	if err := scene.Upload(); err != nil {
+		return "", err
+	}
+	defer scene.Delete()
+
+	renderer, err := NewRenderer(settings)
+	if err != nil {
+		return "", err
+	}
+	defer renderer.Delete()
+	if sky, err := NewSky(w, settings.LevelName, settings); err != nil {
+		fmt.Printf("warning: Sky disabled: %s\n", err)
+	} else {
+		defer sky.Delete()
+		renderer.SetSky(sky)
+	}
+	sprites, err := NewSprites(w, level, settings, gameSettings)
+	if err != nil {
+		return "", err
+	}
+	defer sprites.Delete()
+	renderer.SetSprites(sprites)
+
+	gl.Enable(gl.DEPTH_TEST)
+	gl.DepthFunc(gl.LESS)
+	gl.ClearColor(settings.FogColor.X(), settings.FogColor.Y(), settings.FogColor.Z(), 1.0)
+
+	floorHeight := int16(0)
+
+	if settings.Bench > 0 {
+		glfw.SwapInterval(0)
+		bench(window, renderer, level, scene, position, angle, settings.Bench)
+		return "", nil
+	}
+
+	if settings.RenderTo != "" {
+		eye := transform.mapToWorld(position, float32(eyeHeight(level, position, floorHeight)))
+		return "", renderToPNG(renderer, level, scene, eye, viewDirection(float32(angle)), settings)
+	}
+
+	automap, err := NewAutomap(level, gameSettings)
+	if err != nil {
+		return "", err
+	}
+	defer automap.Delete()
+
+	grid, err := NewGrid(level, transform)
+	if err != nil {
+		return "", err
+	}
+	defer grid.Delete()
+
+	pictureRenderer, err := NewPictureRenderer(false)
+	if err != nil {
+		return "", err
+	}
+	defer pictureRenderer.Delete()
+	font, err := NewFont(w)
+	if err != nil {
+		fmt.Printf("warning: %s\n", err)
+	} else {
+		defer font.Delete()
+	}
+	statusBar, err := NewStatusBar(w)
+	if err != nil {
+		fmt.Printf("warning: Status bar disabled: %s\n", err)
+	} else {
+		defer statusBar.Delete()
+	}
+
+	secrets := NewSecrets(level)
+	sectorId := -1
+	message := ""
+	messageExpires := time.Time{}
+	player := NewPlayer()
+
+	switches, err := w.ReadSwitches()
+	if err != nil {
+		fmt.Printf("warning: Switches disabled: %s\n", err)
+	}
+	switchTextures := switchPairs(switches)
+	clock := NewClock(gameSettings.TimeScale)
+	renderer.SetClock(clock)
+
+	wireframe := false
+	automapActive := false
+	spectating := false
+	gridActive := false
+	spectator := Spectator{}
+	var recording *CameraPath
+	var replay *CameraPath
+	replayFrame := 0
+	if settings.Replay != "" {
+		replay, err = LoadCameraPath(settings.Replay)
+		if err != nil {
+			return "", err
+		}
+	}
+	// The player steps on wall time, so pausing or scaling the game clock
+	// does not change how the player moves.
+	pose := PlayerPose{Position: position, Angle: normalizeAngle(float32(angle))}
+	previousPose := pose
+	playerClock := NewClock(1.0)
+	playerSteps := NewFixedStep(playerClock)
+	worldSteps := NewFixedStep(clock)
+
+	nextLevel := ""
+	pausedBeforeMenu := false
+	levelNames := w.LevelNames()
+	levelIndex := 0
+	for i, name := range levelNames {
+		if name == settings.LevelName {
+			levelIndex = i
+		}
+	}
+	toggleFilter := func() {
+		settings.WallFilter = nextFilter(settings.WallFilter)
+		settings.FlatFilter = nextFilter(settings.FlatFilter)
+		scene.ApplyFilter()
+	}
+	toggleVSync := func() {
+		settings.VSync = !settings.VSync
+		glfw.SwapInterval(swapInterval(settings.VSync))
+	}
+	var menu *Menu
+	menu = NewMenu([]MenuItem{
+		{
+			Label: func() string { return "Resume" },
+			Activate: func() {
+				menu.Active = false
+				clock.SetPaused(pausedBeforeMenu)
+			},
+		},
+		{
+			Label: func() string { return "Level " + levelNames[levelIndex] },
+			Activate: func() {
+				nextLevel = levelNames[levelIndex]
+			},
+			Change: func(delta int) {
+				levelIndex = (levelIndex + len(levelNames) + delta) % len(levelNames)
+			},
+		},
+		{
+			Label:    func() string { return "Filter " + filterLabel(settings) },
+			Activate: toggleFilter,
+			Change:   func(delta int) { toggleFilter() },
+		},
+		{
+			Label:    func() string { return "Vsync " + onOff(settings.VSync) },
+			Activate: toggleVSync,
+			Change:   func(delta int) { toggleVSync() },
+		},
+		{
+			Label: func() string { return "Quit" },
+			Activate: func() {
+				window.SetShouldClose(true)
+			},
+		},
+	})
+
+	for !window.ShouldClose() {
+		clock.Tick()
+		playerClock.Tick()
+
+		width, height := window.GetFramebufferSize()
+		if width == 0 || height == 0 {
+			// The window is minimized and there is nothing to draw.
+			glfw.WaitEvents()
+			continue
+		}
+
+		gamepadInput := gamepad.Poll()
+
+		// Gameplay advances in fixed steps of a tic, as many as the clocks
+		// have advanced by since the previous frame, and the frame is
+		// rendered between the two latest steps of the player.
+		for playerSteps.Next() {
+			previousPose = pose
+			if !spectating && !menu.Active && replay == nil {
+				pose = pose.Move(window, gamepadInput, clock.Paused(), transform)
+			}
+			position = pose.Position
+			if _, id := level.SectorAt(int16(position.X()), int16(position.Y())); id != sectorId {
+				sectorId = id
+				if settings.Highlight {
+					renderer.SetHighlight(sectorId)
+				}
+				if secrets.Enter(sectorId) {
+					message = secretMessage
+					messageExpires = time.Now().Add(messageDuration)
+					verbose.Printf("%s (%d of %d secrets)\n", message, secrets.Found(), secrets.Total())
+				}
+			}
+		}
+		for worldSteps.Next() {
+			if worldSteps.Tic()%damageTics != 0 || sectorId < 0 {
+				continue
+			}
+			if damage := level.Sectors[sectorId].DamagePerTic(); damage > 0 && !player.Dead() {
+				player.Damage(gameSettings.ScaleDamage(damage))
+				verbose.Printf("Health %d\n", player.Health)
+			}
+		}
+		view := previousPose.Lerp(pose, playerSteps.Fraction())
+
+		floorHeight = eyeHeight(level, view.Position, floorHeight)
+
+		bob := viewBob(settings.ViewBob, view.BobPhase, view.Speed)
+
+		eye := transform.mapToWorld(view.Position, float32(floorHeight)+bob)
+
+		// The camera looks from eye towards eye+direction. Moving the map
+		// position by worldToMap(direction) moves the eye by
+		// mapToWorld(worldToMap(direction)), which is direction again, so
+		// moving forward always heads towards the center of the screen.
+		direction := viewDirection(view.Angle)
+		mapDirection, _ := transform.worldToMap(direction)
+
+		camera := Spectator{Eye: eye, Angle: view.RoundedAngle(), Pitch: view.Pitch}
+		if spectating {
+			camera = spectator
+		}
+		if replay != nil {
+			if replayFrame == len(replay.Frames) {
+				break
+			}
+			camera = replay.Frames[replayFrame]
+			replayFrame++
+		}
+		if recording != nil {
+			recording.Frames = append(recording.Frames, camera)
+		}
+		cameraEye, cameraDirection := camera.Eye, camera.Direction()
+		if !spectating && replay == nil {
+			// The player's view keeps the fraction of a degree that the
+			// camera of a recording rounds away.
+			cameraDirection = pitchDirection(direction, view.Pitch)
+		}
+
+		if automapActive {
+			gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+			gl.Viewport(0, 0, int32(width), int32(height))
+			gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+			automap.Render(width, height, view.Position, mapDirection)
+		} else {
+			renderer.Render(level, scene, cameraEye, cameraDirection, width, height, wireframe)
+			if gridActive {
+				grid.Render(renderer.ViewProjection(cameraEye, cameraDirection, width, height))
+			}
+		}
+
+		if statusBar != nil && !automapActive {
+			statusBar.Draw(pictureRenderer, player, width, height)
+		}
+		if font != nil && menu.Active {
+			menu.Draw(pictureRenderer, font, width, height)
+		} else if font != nil && clock.Paused() {
+			font.Draw(pictureRenderer, pausedMessage, 0, 0, width, height)
+		} else if font != nil && time.Now().Before(messageExpires) {
+			font.Draw(pictureRenderer, message, 0, 0, width, height)
+		}
+
+		window.SwapBuffers()
+		glfw.PollEvents()
+
+		// Escape quits right away when there is no font to draw the menu
+		// with or when replaying a camera path.
+		if keyPressed(window, glfw.KeyEscape) {
+			if font == nil || replay != nil {
+				window.SetShouldClose(true)
+			} else if menu.Active {
+				menu.Active = false
+				clock.SetPaused(pausedBeforeMenu)
+			} else {
+				menu.Active = true
+				pausedBeforeMenu = clock.Paused()
+				clock.SetPaused(true)
+			}
+		}
+		if replay != nil {
+			continue
+		}
+		if menu.Active {
+			menu.Update(window)
+			if nextLevel != "" {
+				break
+			}
+			continue
+		}
+		if keyPressed(window, glfw.KeyF6) {
+			if recording == nil {
+				recording = &CameraPath{}
+				fmt.Printf("Recording camera path ...\n")
+			} else {
+				saveCameraPath(recording)
+				recording = nil
+			}
+		}
+		if keyPressed(window, glfw.KeyF2) {
+			wireframe = !wireframe
+		}
+		if keyPressed(window, glfw.KeyF7) {
+			gridActive = !gridActive
+		}
+		if keyPressed(window, glfw.KeyF11) {
+			settings.Gamma = nextGamma(settings.Gamma)
+			fmt.Printf("Gamma correction %.1f\n", settings.Gamma)
+		}
+		if keyPressed(window, glfw.KeyP) {
+			clock.SetPaused(!clock.Paused())
+			if clock.Paused() {
+				fmt.Printf("%s\n", pausedMessage)
+			}
+		}
+		if (keyPressed(window, glfw.KeySpace) || gamepadInput.Use) && !clock.Paused() {
+			if linedef := useLine(level, position, mapDirection); linedef >= 0 {
+				useSwitch(level, scene, switchTextures, linedef, position)
+			}
+		}
+		if keyPressed(window, glfw.KeyTab) {
+			automapActive = !automapActive
+		}
+		if automapActive {
+			if window.GetKey(glfw.KeyEqual) == glfw.Press || window.GetKey(glfw.KeyKPAdd) == glfw.Press {
+				automap.Zoom(0.97)
+			}
+			if window.GetKey(glfw.KeyMinus) == glfw.Press || window.GetKey(glfw.KeyKPSubtract) == glfw.Press {
+				automap.Zoom(1.03)
+			}
+			if window.GetKey(glfw.KeyW) == glfw.Press {
+				automap.Pan(0, 0.02)
+			}
+			if window.GetKey(glfw.KeyS) == glfw.Press {
+				automap.Pan(0, -0.02)
+			}
+			if window.GetKey(glfw.KeyA) == glfw.Press {
+				automap.Pan(-0.02, 0)
+			}
+			if window.GetKey(glfw.KeyD) == glfw.Press {
+				automap.Pan(0.02, 0)
+			}
+			if window.GetKey(glfw.Key0) == glfw.Press {
+				automap.Reset()
+			}
+		}
+		if keyPressed(window, glfw.KeyF3) {
+			spectating = !spectating
+			if spectating {
+				spectator = Spectator{Eye: eye, Angle: view.RoundedAngle(), Pitch: view.Pitch}
+			}
+		}
+		if spectating {
+			spectator.Update(window, speed)
+		}
 	}
-	var gen bspAction = func(level *Level, idx int) {
-		genSubsector(wad, level, idx, &scene)
+	if recording != nil {
+		saveCameraPath(recording)
 	}
-	traverseBsp(level, &Point{int16(position.X()), int16(position.Y())}, len(level.Nodes)-1, all, gen)
+	return nextLevel, nil
+}
 
-	vertex_shader, err := compileShader(vertex, gl.VERTEX_SHADER)
+func newProgram(vertexSource string, fragmentSource string) (uint32, error) {
+	vertexShader, err := compileShader(vertexSource, gl.VERTEX_SHADER)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	fragment_shader, err := compileShader(fragment, gl.FRAGMENT_SHADER)
+	fragmentShader, err := compileShader(fragmentSource, gl.FRAGMENT_SHADER)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
 	program := gl.CreateProgram()
-	gl.AttachShader(program, vertex_shader)
-	gl.AttachShader(program, fragment_shader)
+	gl.AttachShader(program, vertexShader)
+	gl.AttachShader(program, fragmentShader)
 
-	gl.DeleteShader(vertex_shader)
-	gl.DeleteShader(fragment_shader)
+	gl.DeleteShader(vertexShader)
+	gl.DeleteShader(fragmentShader)
 
 	gl.BindFragDataLocation(program, 0, gl.Str("outColor\x00"))
 	gl.LinkProgram(program)
 
-	lightLevelID := gl.GetUniformLocation(program, gl.Str("LightLevel\x00"))
-	matrixID := gl.GetUniformLocation(program, gl.Str("MVP\x00"))
+	var status int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLength)
 
-	gl.Enable(gl.DEPTH_TEST)
-	gl.DepthFunc(gl.LESS)
-	gl.ClearColor(0.3, 0.3, 0.3, 1.0)
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetProgramInfoLog(program, logLength, nil, gl.Str(log))
+		gl.DeleteProgram(program)
 
-	floorHeight := int16(0)
+		return 0, fmt.Errorf("failed to link shader program: %s", strings.TrimRight(log, "\x00\n"))
+	}
 
-	for !window.ShouldClose() {
-		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	return program, nil
+}
+
+// buildScene generates the scene of a level, or loads it from the scene
+// cache if enabled. Levels without subsectors have nothing to draw and are
+// an error.
+func buildScene(w *wad.WAD, level *wad.Level, settings *RenderSettings) (*Scene, error) {
+	if len(level.SSectors) == 0 {
+		return nil, fmt.Errorf("level %s has no nodes", settings.LevelName)
+	}
+	cachePath := ""
+	if settings.SceneCache {
+		path, err := sceneCachePath(w, settings.LevelName)
+		if err != nil {
+			fmt.Printf("warning: Scene cache disabled: %s\n", err)
+		} else if scene, ok := loadSceneCache(path, w, settings); ok {
+			verbose.Printf("Loaded scene from cache '%s'\n", path)
+			scene.playpal = w.Playpal
+			return scene, nil
+		} else {
+			cachePath = path
+		}
+	}
 
-		gl.UseProgram(program)
+	verbose.Printf("Generating scene ...\n")
+	scene := NewScene(settings)
+	scene.playpal = w.Playpal
+	var all bspFilter = func(level *wad.Level, nodeId int) bool {
+		return true
+	}
+	polygons := subsectorPolygons(level)
+	var gen bspAction = func(level *wad.Level, idx int) {
+		genSubsector(w, level, idx, polygons[idx], &scene)
+	}
+	traverseBsp(level, &wad.Point{X: 0, Y: 0}, len(level.Nodes)-1, all, gen)
 
-		sector := findSector(level, &Point{int16(position.X()), int16(position.Y())}, len(level.Nodes)-1)
-		if sector != nil {
-			floorHeight = sector.FloorHeight + 30
+	switches, err := w.ReadSwitches()
+	if err != nil {
+		fmt.Printf("warning: Switches disabled: %s\n", err)
+	}
+	scene.CacheSwitchTextures(switches)
+
+	start := time.Now()
+	scene.Compose(w)
+	if scene.newMeshes > 0 {
+		verbose.Printf("Shared the vertices of %d of %d meshes (%.1f%%)\n", scene.shared, scene.newMeshes, 100*float64(scene.shared)/float64(scene.newMeshes))
+	}
+	verbose.Printf("Composed %d textures and %d flats in %.3f ms\n", len(scene.textures), len(scene.flats), milliseconds(time.Since(start)))
+
+	if cachePath != "" {
+		if err := saveSceneCache(cachePath, w, &scene); err != nil {
+			fmt.Printf("warning: Failed to write scene cache: %s\n", err)
 		}
+	}
+	return &scene, nil
+}
 
-		eye := mgl32.Vec3{-position.X(), float32(floorHeight), position.Y()}
+// eyeHeight returns the height of the player's eyes at a position. The
+// previous height is kept if the level has no sectors.
+func eyeHeight(level *wad.Level, position mgl32.Vec2, previous int16) int16 {
+	sector, _ := level.SectorAt(int16(position.X()), int16(position.Y()))
+	if sector == nil {
+		return previous
+	}
+	return sector.FloorHeight + 30
+}
 
-		y, x := math.Sincos(float64(angle) * math.Pi / 180)
+// damageTics is how often damaging floors hurt the player, in tics.
+const damageTics = 32
 
-		direction := mgl32.Vec3{float32(x), 0.0, float32(y)}
+// viewBobFrequency is the bob phase advanced per map unit walked.
+const viewBobFrequency = 0.05
 
-		width, height := window.GetFramebufferSize()
-		gl.Viewport(0, 0, int32(width), int32(height))
-		projection := mgl32.Perspective(64.0, float32(width)/float32(height), 1.0, 10000.0)
-		view := mgl32.LookAt(eye.X(), eye.Y(), eye.Z(), eye.X()+direction.X(), eye.Y()+direction.Y(), eye.Z()+direction.Z(), 0.0, 1.0, 0.0)
-		model := mgl32.Ident4()
-		mvp := projection.Mul4(view).Mul4(model)
+// viewBob returns the vertical eye offset for a bob phase. The offset is
+// scaled by the movement speed relative to full walking speed so that the
+// view stays still when the player stands still.
+func viewBob(amplitude float32, phase float32, speed float32) float32 {
+	if speed > 1.0 {
+		speed = 1.0
+	}
+	return amplitude * speed * float32(math.Sin(float64(phase)))
+}
+
+// viewDirection returns the view direction in world coordinates for a view
+// angle in degrees. Angles grow clockwise when seen from above, so turning
+// right increases the angle.
+func viewDirection(angle float32) mgl32.Vec3 {
+	y, x := math.Sincos(float64(angle) * math.Pi / 180)
+	return mgl32.Vec3{float32(x), 0.0, float32(y)}
+}
+
+// Renderer draws the 3D view of a scene.
+type Renderer struct {
+	program      uint32
+	settings     *RenderSettings
+	lightLevelID int32
+	fullbrightID int32
+	alphaID      int32
+	texRectID    int32
+	scrollID     int32
+	highlightID  int32
+	indexedID    int32
+	paletteID    int32
+	matrixID     int32
+	eyeID        int32
+	originID     int32
+	fogDensityID int32
+	fogColorID   int32
+	gammaID      int32
+	clock        *Clock   // Game clock that animates the scene, nil for a still scene.
+	highlight    int      // Index of the highlighted sector, -1 if none.
+	sky          *Sky     // Sky drawn behind the scene, nil for the fog color.
+	sprites      *Sprites // Sprites of the things, nil to draw none.
+}
+
+func NewRenderer(settings *RenderSettings) (*Renderer, error) {
+	program, err := newProgram(vertex, fragment)
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{
+		program:      program,
+		settings:     settings,
+		lightLevelID: gl.GetUniformLocation(program, gl.Str("LightLevel\x00")),
+		fullbrightID: gl.GetUniformLocation(program, gl.Str("Fullbright\x00")),
+		alphaID:      gl.GetUniformLocation(program, gl.Str("Alpha\x00")),
+		texRectID:    gl.GetUniformLocation(program, gl.Str("TexRect\x00")),
+		scrollID:     gl.GetUniformLocation(program, gl.Str("Scroll\x00")),
+		highlightID:  gl.GetUniformLocation(program, gl.Str("Highlight\x00")),
+		indexedID:    gl.GetUniformLocation(program, gl.Str("Indexed\x00")),
+		paletteID:    gl.GetUniformLocation(program, gl.Str("Palette\x00")),
+		matrixID:     gl.GetUniformLocation(program, gl.Str("MVP\x00")),
+		eyeID:        gl.GetUniformLocation(program, gl.Str("Eye\x00")),
+		originID:     gl.GetUniformLocation(program, gl.Str("Origin\x00")),
+		fogDensityID: gl.GetUniformLocation(program, gl.Str("FogDensity\x00")),
+		fogColorID:   gl.GetUniformLocation(program, gl.Str("FogColor\x00")),
+		gammaID:      gl.GetUniformLocation(program, gl.Str("Gamma\x00")),
+		highlight:    -1,
+	}, nil
+}
+
+// Delete releases the shader program of the renderer. The sky and the
+// sprites belong to the caller.
+func (r *Renderer) Delete() {
+	gl.DeleteProgram(r.program)
+}
+
+// SetClock sets the game clock that animates scrolling walls.
+func (r *Renderer) SetClock(clock *Clock) {
+	r.clock = clock
+}
+
+// SetSky sets the sky that is drawn behind the scene, or nil to leave the
+// fog color behind it.
+func (r *Renderer) SetSky(sky *Sky) {
+	r.sky = sky
+}
+
+// SetSprites sets the sprites that are drawn in the scene, or nil to draw
+// none.
+func (r *Renderer) SetSprites(sprites *Sprites) {
+	r.sprites = sprites
+}
+
+// SetHighlight sets the sector whose floor, ceiling, and walls are tinted
+// for debugging, or -1 to tint none.
+func (r *Renderer) SetHighlight(sectorId int) {
+	r.highlight = sectorId
+}
+
+// scrollOffset returns the texture coordinate offset of a wall that
+// scrolls by its texture width every scrollTics tics, a fraction into a
+// tic. The tics wrap around in integers before they are converted, so that
+// the offset keeps its precision however long the scroll runs.
+func scrollOffset(scrollTics int, tic int, fraction float32) float32 {
+	if scrollTics <= 0 {
+		return 0
+	}
+	return (float32(tic%scrollTics) + fraction) / float32(scrollTics)
+}
+
+// ViewProjection returns the model view projection matrix of the 3D view.
+func (r *Renderer) ViewProjection(eye mgl32.Vec3, direction mgl32.Vec3, width int, height int) mgl32.Mat4 {
+	return viewProjection(r.settings, eye, direction, width, height)
+}
+
+// viewProjection returns the matrix that transforms world coordinates to
+// clip coordinates for a camera at eye looking in direction.
+func viewProjection(settings *RenderSettings, eye mgl32.Vec3, direction mgl32.Vec3, width int, height int) mgl32.Mat4 {
+	projection := mgl32.Perspective(64.0, float32(width)/float32(height), settings.Near, settings.Far)
+	view := mgl32.LookAt(eye.X(), eye.Y(), eye.Z(), eye.X()+direction.X(), eye.Y()+direction.Y(), eye.Z()+direction.Z(), 0.0, 1.0, 0.0)
+	model := mgl32.Ident4()
+	return projection.Mul4(view).Mul4(model)
+}
+
+// Render draws the scene as seen from the eye looking in the given
+// direction into a framebuffer of the given size.
+func (r *Renderer) Render(level *wad.Level, scene *Scene, eye mgl32.Vec3, direction mgl32.Vec3, width int, height int, wireframe bool) {
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+
+	gl.Viewport(0, 0, int32(width), int32(height))
+	if r.sky != nil && !wireframe {
+		r.sky.Render(r.settings, direction, width, height)
+	}
+
+	gl.UseProgram(r.program)
+
+	mvp := r.ViewProjection(eye, direction, width, height)
+
+	gl.UniformMatrix4fv(r.matrixID, 1, false, &mvp[0])
+	gl.Uniform3f(r.eyeID, eye.X(), eye.Y(), eye.Z())
+	gl.Uniform1f(r.fogDensityID, r.settings.FogDensity)
+	gl.Uniform3f(r.fogColorID, r.settings.FogColor.X(), r.settings.FogColor.Y(), r.settings.FogColor.Z())
+	gl.Uniform1f(r.gammaID, r.settings.Gamma)
+
+	tic, fraction := 0, float32(0)
+	if r.clock != nil {
+		tic, fraction = r.clock.Tics(), r.clock.TicFraction()
+	}
+
+	// The palette of indexed textures stays bound to the second texture
+	// unit while the atlas pages are switched on the first.
+	if scene.palette != 0 {
+		gl.Uniform1i(r.indexedID, 1)
+		gl.Uniform1i(r.paletteID, 1)
+		gl.ActiveTexture(gl.TEXTURE1)
+		gl.BindTexture(gl.TEXTURE_2D, scene.palette)
+	} else {
+		gl.Uniform1i(r.indexedID, 0)
+	}
+
+	gl.ActiveTexture(gl.TEXTURE0)
 
-		gl.UniformMatrix4fv(matrixID, 1, false, &mvp[0])
+	if wireframe {
+		gl.PolygonMode(gl.FRONT_AND_BACK, gl.LINE)
+	} else {
+		gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+	}
+
+	gl.BindVertexArray(scene.vao)
+
+	// Walls are wound clockwise seen from their front side and flats seen
+	// from the side that faces the sector, so back faces are culled except
+	// for double-sided meshes. Mirroring the map reverses the winding.
+	gl.Enable(gl.CULL_FACE)
+	if r.settings.Mirror {
+		gl.FrontFace(gl.CCW)
+	} else {
+		gl.FrontFace(gl.CW)
+	}
+	gl.CullFace(gl.BACK)
+	defer gl.Disable(gl.CULL_FACE)
+	culling := true
 
-		gl.ActiveTexture(gl.TEXTURE0)
+	// Flats are pushed slightly back in depth so that they don't z-fight
+	// with the bottom and top edges of the walls that they meet.
+	gl.PolygonOffset(flatOffsetFactor, flatOffsetUnits)
+	defer gl.Disable(gl.POLYGON_OFFSET_FILL)
+	offset := false
 
-		var render bspAction = func(level *Level, idx int) {
-			for _, mesh := range scene.meshes[idx] {
-				gl.Uniform1f(lightLevelID, mesh.lightLevel)
-				gl.BindTexture(gl.TEXTURE_2D, scene.textures[mesh.texture])
-				gl.BindVertexArray(mesh.vao)
-				gl.DrawArrays(gl.TRIANGLES, 0, int32(mesh.count))
+	var all bspFilter = func(level *wad.Level, nodeId int) bool {
+		return true
+	}
+	boundPage := uint32(0)
+	draw := func(mesh *Mesh) {
+		if !wireframe {
+			gl.Uniform1f(r.lightLevelID, mesh.lightLevel)
+			if mesh.fullbright {
+				gl.Uniform1i(r.fullbrightID, 1)
+			} else {
+				gl.Uniform1i(r.fullbrightID, 0)
+			}
+			gl.Uniform1f(r.alphaID, mesh.alpha)
+			if r.highlight >= 0 && mesh.sector == r.highlight {
+				gl.Uniform1i(r.highlightID, 1)
+			} else {
+				gl.Uniform1i(r.highlightID, 0)
+			}
+			gl.Uniform4f(r.texRectID, mesh.atlasRect.X(), mesh.atlasRect.Y(), mesh.atlasRect.Z(), mesh.atlasRect.W())
+			gl.Uniform2f(r.scrollID, scrollOffset(mesh.scrollTics, tic, fraction), 0)
+			if mesh.atlasPage != boundPage {
+				gl.BindTexture(gl.TEXTURE_2D, mesh.atlasPage)
+				boundPage = mesh.atlasPage
+			}
+		}
+		if mesh.flat != offset {
+			offset = mesh.flat
+			if offset {
+				gl.Enable(gl.POLYGON_OFFSET_FILL)
+			} else {
+				gl.Disable(gl.POLYGON_OFFSET_FILL)
+			}
+		}
+		if mesh.doubleSided == culling {
+			culling = !mesh.doubleSided
+			if culling {
+				gl.Enable(gl.CULL_FACE)
+			} else {
+				gl.Disable(gl.CULL_FACE)
+			}
+		}
+		gl.Uniform3f(r.originID, mesh.origin.X(), mesh.origin.Y(), mesh.origin.Z())
+		gl.DrawArrays(gl.TRIANGLES, int32(mesh.first), int32(mesh.count))
+	}
+	// The BSP is traversed front to back. Opaque meshes are drawn right
+	// away and translucent meshes are collected to be blended afterwards.
+	translucent := []*Mesh{}
+	var render bspAction = func(level *wad.Level, idx int) {
+		meshes := scene.meshes[idx]
+		for i := range meshes {
+			mesh := &meshes[i]
+			if mesh.atlasPage == 0 {
+				continue
 			}
+			if mesh.alpha < 1.0 {
+				translucent = append(translucent, mesh)
+				continue
+			}
+			draw(mesh)
 		}
-		traverseBsp(level, &Point{int16(position.X()), int16(position.Y())}, len(level.Nodes)-1, all, render)
+	}
+	eyePosition, _ := r.settings.transform().worldToMap(eye)
+	traverseBsp(level, &wad.Point{X: int16(eyePosition.X()), Y: int16(eyePosition.Y())}, len(level.Nodes)-1, all, render)
+
+	// Sprites are drawn between the opaque and the translucent meshes, so
+	// that they can be seen through translucent walls. They use a program
+	// and GL state of their own.
+	if r.sprites != nil && !wireframe {
+		r.sprites.Render(r.settings, eye, direction, width, height, tic)
+		gl.UseProgram(r.program)
+		gl.BindVertexArray(scene.vao)
+		boundPage, culling, offset = 0, false, false
+	}
 
+	if len(translucent) == 0 {
+		return
+	}
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.DepthMask(false)
+	for i := len(translucent) - 1; i >= 0; i-- {
+		draw(translucent[i])
+	}
+	gl.DepthMask(true)
+	gl.Disable(gl.BLEND)
+}
+
+// bench renders a number of frames from a fixed position while turning the
+// camera a full circle and reports the frame times.
+func bench(window *glfw.Window, renderer *Renderer, level *wad.Level, scene *Scene, position mgl32.Vec2, angle int16, frames int) {
+	floorHeight := eyeHeight(level, position, 0)
+	eye := renderer.settings.transform().mapToWorld(position, float32(floorHeight))
+	width, height := window.GetFramebufferSize()
+
+	var total, min, max time.Duration
+	for i := 0; i < frames; i++ {
+		direction := viewDirection(float32(angle + int16(360*i/frames)))
+		start := time.Now()
+		renderer.Render(level, scene, eye, direction, width, height, false)
 		window.SwapBuffers()
+		gl.Finish()
+		elapsed := time.Since(start)
 		glfw.PollEvents()
 
-		if window.GetKey(glfw.KeyEscape) == glfw.Press {
-			window.SetShouldClose(true)
-		}
-		if window.GetKey(glfw.KeyUp) == glfw.Press {
-			position = position.Add(mgl32.Vec2{-direction.X(), direction.Z()}.Mul(speed))
-		}
-		if window.GetKey(glfw.KeyDown) == glfw.Press {
-			position = position.Sub(mgl32.Vec2{-direction.X(), direction.Z()}.Mul(speed))
+		total += elapsed
+		if i == 0 || elapsed < min {
+			min = elapsed
 		}
-		if window.GetKey(glfw.KeyLeft) == glfw.Press {
-			angle -= int16(speed)
-		}
-		if window.GetKey(glfw.KeyRight) == glfw.Press {
-			angle += int16(speed)
+		if elapsed > max {
+			max = elapsed
 		}
 	}
+	avg := total / time.Duration(frames)
+	fmt.Printf("frames=%d total_ms=%.3f min_ms=%.3f avg_ms=%.3f max_ms=%.3f\n",
+		frames, milliseconds(total), milliseconds(min), milliseconds(avg), milliseconds(max))
+}
+
+// renderToPNG renders one frame into an offscreen framebuffer of the
+// render size in the settings and writes it to a PNG image. Unlike the
+// window, the framebuffer has the same size on every display.
+func renderToPNG(renderer *Renderer, level *wad.Level, scene *Scene, eye mgl32.Vec3, direction mgl32.Vec3, settings *RenderSettings) error {
+	width, height := settings.RenderWidth, settings.RenderHeight
+
+	var framebuffer uint32
+	gl.GenFramebuffers(1, &framebuffer)
+	defer gl.DeleteFramebuffers(1, &framebuffer)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, framebuffer)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+
+	var renderbuffers [2]uint32
+	gl.GenRenderbuffers(2, &renderbuffers[0])
+	defer gl.DeleteRenderbuffers(2, &renderbuffers[0])
+	gl.BindRenderbuffer(gl.RENDERBUFFER, renderbuffers[0])
+	gl.RenderbufferStorage(gl.RENDERBUFFER, gl.RGBA8, int32(width), int32(height))
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.RENDERBUFFER, renderbuffers[0])
+	gl.BindRenderbuffer(gl.RENDERBUFFER, renderbuffers[1])
+	gl.RenderbufferStorage(gl.RENDERBUFFER, gl.DEPTH_COMPONENT24, int32(width), int32(height))
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, renderbuffers[1])
+	if status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER); status != gl.FRAMEBUFFER_COMPLETE {
+		return fmt.Errorf("offscreen framebuffer of %dx%d is incomplete (status 0x%x)", width, height, status)
+	}
+
+	renderer.Render(level, scene, eye, direction, width, height, false)
+
+	// GL rows start at the bottom of the image.
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	gl.ReadPixels(0, 0, int32(width), int32(height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(rgba.Pix))
+	flipped := image.NewRGBA(rgba.Bounds())
+	for y := 0; y < height; y++ {
+		copy(flipped.Pix[y*flipped.Stride:(y+1)*flipped.Stride], rgba.Pix[(height-1-y)*rgba.Stride:])
+	}
+	// The frame is opaque even where the fog color was blended with alpha.
+	for i := 3; i < len(flipped.Pix); i += 4 {
+		flipped.Pix[i] = 255
+	}
+
+	file, err := os.Create(settings.RenderTo)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := png.Encode(file, flipped); err != nil {
+		return err
+	}
+	verbose.Printf("Rendered %dx%d frame to '%s'\n", width, height, settings.RenderTo)
+	return nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
 }
 
 func compileShader(source string, shaderType uint32) (uint32, error) {
@@ -512,53 +2385,244 @@ func compileShader(source string, shaderType uint32) (uint32, error) {
 
 		log := strings.Repeat("\x00", int(logLength+1))
 		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
+		gl.DeleteShader(shader)
 
-		return 0, fmt.Errorf("failed to compile %v: %v", source, log)
+		log = strings.TrimRight(log, "\x00\n")
+		if line, ok := shaderErrorLine(source, log); ok {
+			log += "\n" + line
+		}
+		return 0, fmt.Errorf("failed to compile %s shader: %s", shaderTypeName(shaderType), log)
 	}
 
 	return shader, nil
 }
 
-func loadTexture(wad *WAD, texname string) (uint32, error) {
-	texture, err := wad.LoadTexture(texname)
+func shaderTypeName(shaderType uint32) string {
+	switch shaderType {
+	case gl.VERTEX_SHADER:
+		return "vertex"
+	case gl.FRAGMENT_SHADER:
+		return "fragment"
+	}
+	return fmt.Sprintf("0x%x", shaderType)
+}
+
+// shaderLogLine matches the source line number of the first error in a
+// shader info log. Drivers write it as 0:12 or 0(12) after the source
+// string number.
+var shaderLogLine = regexp.MustCompile(`\b0[:(](\d+)`)
+
+// shaderErrorLine returns the source line that the first error of a shader
+// info log refers to, prefixed with its number.
+func shaderErrorLine(source string, log string) (string, bool) {
+	match := shaderLogLine.FindStringSubmatch(log)
+	if match == nil {
+		return "", false
+	}
+	number, err := strconv.Atoi(match[1])
+	lines := strings.Split(source, "\n")
+	if err != nil || number < 1 || number > len(lines) {
+		return "", false
+	}
+	return fmt.Sprintf("%4d: %s", number, strings.TrimSpace(lines[number-1])), true
+}
+
+// composeKey identifies a composed texture or flat.
+type composeKey struct {
+	w       *wad.WAD
+	name    string
+	flat    bool
+	palette int
+}
+
+type composeResult struct {
+	image *image.RGBA
+	err   error
+}
+
+// composeCache memoizes composed textures and flats. Failures are
+// remembered too so that a missing texture is reported only once. The
+// cache is safe for concurrent use, but the same image may be composed
+// twice if it is requested concurrently.
+type composeCache struct {
+	mu      sync.Mutex
+	results map[composeKey]composeResult
+}
+
+var composed = composeCache{results: map[composeKey]composeResult{}}
+
+// Texture returns a composed wall texture.
+func (cache *composeCache) Texture(w *wad.WAD, name string, palette int) (*image.RGBA, error) {
+	key := composeKey{w: w, name: name, palette: palette}
+	return cache.lookup(key, func() (*image.RGBA, error) {
+		return composeTexture(w, name, palette)
+	})
+}
+
+// Flat returns a composed flat.
+func (cache *composeCache) Flat(w *wad.WAD, name string, palette int) (*image.RGBA, error) {
+	key := composeKey{w: w, name: name, flat: true, palette: palette}
+	return cache.lookup(key, func() (*image.RGBA, error) {
+		return composeFlat(w, name, palette)
+	})
+}
+
+// lookup returns the cached result for a key, or composes the image with
+// compose and caches it. The cache is not locked while composing.
+func (cache *composeCache) lookup(key composeKey, compose func() (*image.RGBA, error)) (*image.RGBA, error) {
+	cache.mu.Lock()
+	result, ok := cache.results[key]
+	cache.mu.Unlock()
+	if !ok {
+		result.image, result.err = compose()
+		cache.mu.Lock()
+		cache.results[key] = result
+		cache.mu.Unlock()
+	}
+	return result.image, result.err
+}
+
+// indexedPalette composes images with the palette index of every pixel in
+// the red channel instead of its color, for looking up the palette in the
+// fragment shader.
+const indexedPalette = -1
+
+// paletteColor returns the opaque color of a palette index in a PLAYPAL
+// palette, or the index itself with indexedPalette.
+func paletteColor(w *wad.WAD, palette int, index byte) color.RGBA {
+	if palette == indexedPalette {
+		return color.RGBA{index, 0, 0, 255}
+	}
+	rgb := w.Playpal.Palettes[palette].Table[index]
+	return color.RGBA{rgb.Red, rgb.Green, rgb.Blue, 255}
+}
+
+// composeTexture composes a wall texture from its patches using the given
+// PLAYPAL palette.
+func composeTexture(w *wad.WAD, texname string, palette int) (*image.RGBA, error) {
+	texture, err := w.LoadTexture(texname)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	if texture.Header == nil {
-		// FIXME: Why do we have a texture with no header?
-		return 0, nil
+		return nil, fmt.Errorf("texture %s not found", texname)
 	}
 	bounds := image.Rect(0, 0, int(texture.Header.Width), int(texture.Header.Height))
 	rgba := image.NewRGBA(bounds)
 	if rgba.Stride != rgba.Rect.Size().X*4 {
-		return 0, fmt.Errorf("unsupported stride")
+		return nil, fmt.Errorf("unsupported stride")
 	}
 	for _, patch := range texture.Patches {
-		image, err := wad.LoadImage(patch.PNameNumber)
+		image, err := w.LoadImage(patch.PNameNumber)
 		if err != nil {
-			return 0, err
+			fmt.Printf("warning: texture %s: %s\n", texname, err)
+			continue
 		}
 		for y := 0; y < image.Height; y++ {
 			for x := 0; x < image.Width; x++ {
 				pixel := image.Pixels[y*image.Width+x]
-				var alpha uint8
-				if pixel == wad.TransparentPaletteIndex {
-					alpha = 0
-				} else {
-					alpha = 255
+				c := paletteColor(w, palette, pixel)
+				if pixel == w.TransparentPaletteIndex {
+					c.A = 0
 				}
-				rgb := wad.Playpal.Palettes[0].Table[pixel]
-				rgba.Set(int(patch.XOffset)+x, int(patch.YOffset)+y, color.RGBA{rgb.Red, rgb.Green, rgb.Blue, alpha})
+				rgba.Set(int(patch.XOffset)+x, int(patch.YOffset)+y, c)
+			}
+		}
+	}
+	return rgba, nil
+}
+
+// pictureToRGBA converts a decoded picture to an image using the given
+// PLAYPAL palette. Transparent pixels get a zero alpha.
+func pictureToRGBA(w *wad.WAD, picture *wad.Image, palette int) *image.RGBA {
+	rgba := image.NewRGBA(image.Rect(0, 0, picture.Width, picture.Height))
+	for y := 0; y < picture.Height; y++ {
+		for x := 0; x < picture.Width; x++ {
+			pixel := picture.Pixels[y*picture.Width+x]
+			if pixel == w.TransparentPaletteIndex {
+				continue
 			}
+			rgba.Set(x, y, paletteColor(w, palette, pixel))
+		}
+	}
+	return rgba
+}
+
+// composeFlat converts a flat to an image using the given PLAYPAL palette.
+func composeFlat(w *wad.WAD, flatname string, palette int) (*image.RGBA, error) {
+	flat, err := w.LoadFlat(flatname)
+	if err != nil {
+		return nil, err
+	}
+	if len(flat.Data) != flatSize*flatSize {
+		return nil, fmt.Errorf("flat %s: unexpected size %d", flatname, len(flat.Data))
+	}
+	bounds := image.Rect(0, 0, flatSize, flatSize)
+	rgba := image.NewRGBA(bounds)
+	for y := 0; y < flatSize; y++ {
+		for x := 0; x < flatSize; x++ {
+			rgba.Set(x, y, paletteColor(w, palette, flat.Data[y*flatSize+x]))
+		}
+	}
+	return rgba, nil
+}
+
+// textureFilters returns the minification and magnification filters for a
+// texture filtering mode and the mipmapping of the render settings.
+func textureFilters(settings *RenderSettings, filter string) (int32, int32) {
+	if settings.Indexed {
+		return gl.NEAREST, gl.NEAREST
+	}
+	minFilter, magFilter := int32(gl.LINEAR), int32(gl.LINEAR)
+	if filter == FilterNearest {
+		minFilter, magFilter = gl.NEAREST, gl.NEAREST
+	}
+	if settings.Mipmaps {
+		if filter == FilterNearest {
+			minFilter = gl.NEAREST_MIPMAP_NEAREST
+		} else {
+			minFilter = gl.LINEAR_MIPMAP_LINEAR
 		}
 	}
+	return minFilter, magFilter
+}
+
+// nextFilter returns the texture filtering mode that follows a mode.
+func nextFilter(filter string) string {
+	if filter == FilterLinear {
+		return FilterNearest
+	}
+	return FilterLinear
+}
+
+// filterLabel returns a menu label for the wall and flat filtering modes,
+// or the mode of both if they are the same.
+func filterLabel(settings *RenderSettings) string {
+	if settings.WallFilter == settings.FlatFilter {
+		return settings.WallFilter
+	}
+	return "walls " + settings.WallFilter + ", flats " + settings.FlatFilter
+}
+
+// onOff returns a menu label for a setting that is on or off.
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// uploadTexture uploads an image as a GL texture with a texture filtering
+// mode.
+func uploadTexture(rgba *image.RGBA, settings *RenderSettings, filter string) uint32 {
+	minFilter, magFilter := textureFilters(settings, filter)
 
 	var texId uint32
 	gl.GenTextures(1, &texId)
 	gl.ActiveTexture(gl.TEXTURE0)
 	gl.BindTexture(gl.TEXTURE_2D, texId)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, minFilter)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, magFilter)
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
 	gl.TexImage2D(
@@ -571,5 +2635,52 @@ func loadTexture(wad *WAD, texname string) (uint32, error) {
 		gl.RGBA,
 		gl.UNSIGNED_BYTE,
 		gl.Ptr(rgba.Pix))
-	return texId, nil
+	if settings.Mipmaps {
+		gl.GenerateMipmap(gl.TEXTURE_2D)
+	}
+	if settings.Anisotropy > 1 {
+		gl.TexParameterf(gl.TEXTURE_2D, textureMaxAnisotropy, settings.Anisotropy)
+	}
+	return texId
+}
+
+// uploadIndexedTexture uploads an image composed with indexedPalette as a
+// GL texture with the palette index in the red channel and the alpha in the
+// green channel, which takes half the memory of an RGBA texture. Indices
+// can't be blended, so the texture is always sampled with nearest
+// filtering and without mipmaps.
+func uploadIndexedTexture(rgba *image.RGBA) uint32 {
+	size := rgba.Rect.Size()
+	pixels := make([]byte, 0, size.X*size.Y*2)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		pixels = append(pixels, rgba.Pix[i], rgba.Pix[i+3])
+	}
+
+	var texId uint32
+	gl.GenTextures(1, &texId)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, texId)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	defer gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RG8, int32(size.X), int32(size.Y), 0, gl.RG, gl.UNSIGNED_BYTE, gl.Ptr(pixels))
+	return texId
+}
+
+// supportedAnisotropy clamps the requested anisotropic filtering level to
+// what the driver supports. It returns zero if anisotropic filtering is not
+// available.
+func supportedAnisotropy(requested float32) float32 {
+	if requested <= 1 || !glfw.ExtensionSupported("GL_EXT_texture_filter_anisotropic") {
+		return 0
+	}
+	var max float32
+	gl.GetFloatv(maxTextureMaxAnisotropy, &max)
+	if requested > max {
+		return max
+	}
+	return requested
 }
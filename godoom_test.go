@@ -0,0 +1,214 @@
+package main
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/internal/wadtest"
+	"github.com/penberg/godoom/wad"
+	"math"
+	"testing"
+)
+
+func TestSegLength(t *testing.T) {
+	tests := []struct {
+		start wad.Vertex
+		end   wad.Vertex
+		bams  int16
+		want  float32
+	}{
+		{wad.Vertex{XCoord: 0, YCoord: 0}, wad.Vertex{XCoord: 128, YCoord: 0}, 0, 128},
+		{wad.Vertex{XCoord: 0, YCoord: 0}, wad.Vertex{XCoord: 0, YCoord: 64}, 0x4000, 64},
+		{wad.Vertex{XCoord: 64, YCoord: 0}, wad.Vertex{XCoord: 0, YCoord: 0}, -0x8000, 64},
+		{wad.Vertex{XCoord: 0, YCoord: 0}, wad.Vertex{XCoord: 30, YCoord: 40}, int16(math.Atan2(40, 30) / (2 * math.Pi) * 65536), 50},
+	}
+	for _, test := range tests {
+		seg := wad.Seg{Bams: test.bams}
+		if got := segLength(&seg, &test.start, &test.end); math.Abs(float64(got-test.want)) > 0.01 {
+			t.Errorf("segLength from %v to %v at %#x = %f, want %f", test.start, test.end, test.bams, got, test.want)
+		}
+	}
+}
+
+func TestSubsectorFlatArea(t *testing.T) {
+	level := wadtest.Level()
+	polygons := subsectorPolygons(level)
+	// The convex hull of the room would cover another 128 by 128 square,
+	// which the floor must not leak into.
+	want := map[int]float64{0: 128 * 128, 1: 128 * 256}
+	for ssectorId, area := range want {
+		if got := triangleArea(triangulatePolygon(cleanPolygon(polygons[ssectorId]))); got != area {
+			t.Errorf("subsector %d: clipped polygon area = %f, want %f", ssectorId, got, area)
+		}
+		if got := triangleArea(triangulateSubsector(level, ssectorId)); got != area {
+			t.Errorf("subsector %d: seg polygon area = %f, want %f", ssectorId, got, area)
+		}
+	}
+}
+
+func TestBuildSceneWithoutNodes(t *testing.T) {
+	w := wadtest.MustReadIWAD(t, testWADLumps(0))
+	level := wadtest.Level()
+	level.SSectors = nil
+	level.Nodes = nil
+	_, err := buildScene(w, level, &RenderSettings{LevelName: "MAP01"})
+	if want := "level MAP01 has no nodes"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+// testWallVertices returns the two triangles of a wall of a size at a
+// position.
+func testWallVertices(x float32, z float32, width float32, height float32) []Point3 {
+	corner := func(dx float32, dy float32) Point3 {
+		return Point3{Position: mgl32.Vec3{x + dx, dy, z}, U: dx / 64, V: dy / 128}
+	}
+	return []Point3{corner(0, 0), corner(0, height), corner(width, height), corner(width, height), corner(width, 0), corner(0, 0)}
+}
+
+func TestNewMeshSharesVertices(t *testing.T) {
+	scene := NewScene(&RenderSettings{})
+	first := scene.NewMesh("WALL", 160, testWallVertices(0, 0, 128, 128))
+	tests := []struct {
+		name     string
+		texture  string
+		vertices []Point3
+		shared   bool
+	}{
+		{"moved wall", "WALL", testWallVertices(256, -512, 128, 128), true},
+		{"other texture", "DOOR", testWallVertices(256, -512, 128, 128), false},
+		{"other size", "WALL", testWallVertices(256, -512, 64, 128), false},
+	}
+	for _, test := range tests {
+		mesh := scene.NewMesh(test.texture, 160, test.vertices)
+		if shared := mesh.first == first.first; shared != test.shared {
+			t.Errorf("%s: shares vertices %v, want %v", test.name, shared, test.shared)
+		}
+		for i, vertex := range test.vertices {
+			if got := scene.vertexPosition(&mesh, i); got != vertex.Position {
+				t.Errorf("%s: vertex %d is at %v, want %v", test.name, i, got, vertex.Position)
+			}
+		}
+	}
+	if scene.shared != 1 || scene.newMeshes != 4 {
+		t.Errorf("shared %d of %d meshes, want 1 of 4", scene.shared, scene.newMeshes)
+	}
+	if got, want := len(scene.vertices), 3*6*vertexFloats; got != want {
+		t.Errorf("got %d floats of vertex data, want %d", got, want)
+	}
+}
+
+func BenchmarkTriangulateSubsector(b *testing.B) {
+	level := wadtest.Level()
+	for i := 0; i < b.N; i++ {
+		for ssectorId := range level.SSectors {
+			triangulateSubsector(level, ssectorId)
+		}
+	}
+}
+
+func BenchmarkTriangulateClippedSubsector(b *testing.B) {
+	level := wadtest.Level()
+	for i := 0; i < b.N; i++ {
+		for _, polygon := range subsectorPolygons(level) {
+			triangulatePolygon(cleanPolygon(polygon))
+		}
+	}
+}
+
+// benchmarkTextures is the number of textures composed by the composition
+// benchmarks, about as many as a Doom II level uses.
+const benchmarkTextures = 64
+
+// resetComposeCache forgets every composed texture and flat.
+func resetComposeCache() {
+	composed.mu.Lock()
+	composed.results = map[composeKey]composeResult{}
+	composed.mu.Unlock()
+}
+
+func BenchmarkComposeSerial(b *testing.B) {
+	w := wadtest.MustReadIWAD(b, testWADLumps(benchmarkTextures))
+	textures := w.TextureNames()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, name := range textures {
+			if _, err := composeTexture(w, name, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for _, name := range []string{"FLOOR", "CEIL"} {
+			if _, err := composeFlat(w, name, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkCompose(b *testing.B) {
+	w := wadtest.MustReadIWAD(b, testWADLumps(benchmarkTextures))
+	textures := w.TextureNames()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		resetComposeCache()
+		scene := NewScene(&RenderSettings{})
+		for _, name := range textures {
+			scene.CacheTexture(name)
+		}
+		scene.CacheFlat("FLOOR")
+		scene.CacheFlat("CEIL")
+		b.StartTimer()
+		scene.Compose(w)
+		if len(scene.textures) != len(textures) || len(scene.flats) != 2 {
+			b.Fatalf("composed %d textures and %d flats, want %d and 2", len(scene.textures), len(scene.flats), len(textures))
+		}
+	}
+}
+
+func TestScrollOffset(t *testing.T) {
+	tests := []struct {
+		name       string
+		scrollTics int
+		tic        int
+		fraction   float32
+		want       float32
+	}{
+		{"not scrolling", 0, 100, 0.5, 0},
+		{"start", 64, 0, 0, 0},
+		{"into a tic", 64, 16, 0.5, 16.5 / 64},
+		{"wrapped", 64, 64 + 32, 0, 0.5},
+		{"just before wrapping", 128, 127, 0.99, 127.99 / 128},
+		{"after eight hours", 64, 8*126000 + 5, 0.5, 5.5 / 64},
+	}
+	for _, test := range tests {
+		if got := scrollOffset(test.scrollTics, test.tic, test.fraction); math.Abs(float64(got-test.want)) > 1e-7 {
+			t.Errorf("%s: scrollOffset(%d, %d, %f) = %f, want %f", test.name, test.scrollTics, test.tic, test.fraction, got, test.want)
+		}
+	}
+}
+
+func TestShaderErrorLine(t *testing.T) {
+	source := "#version 330\n\nuniform mat4 MVP;\n    gl_Position = MVP * vert;\n"
+	tests := []struct {
+		name string
+		log  string
+		want string
+		ok   bool
+	}{
+		{"Mesa", "0:4(17): error: `vert' undeclared", "   4: gl_Position = MVP * vert;", true},
+		{"NVIDIA", "0(3) : error C0000: syntax error, unexpected identifier", "   3: uniform mat4 MVP;", true},
+		{"AMD", "ERROR: 0:1: '' : version '330' is not supported", "   1: #version 330", true},
+		{"first of several errors", "0:3(1): error: a\n0:4(1): error: b", "   3: uniform mat4 MVP;", true},
+		{"empty line", "0:2(1): error: unexpected end", "   2: ", true},
+		{"line zero", "0:0(1): error: preprocessor error", "", false},
+		{"past the end", "0:99(1): error: syntax error", "", false},
+		{"no line number", "error: linking failed", "", false},
+		{"other source string", "10:4(1): error: syntax error", "", false},
+		{"empty log", "", "", false},
+	}
+	for _, test := range tests {
+		got, ok := shaderErrorLine(source, test.log)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s: shaderErrorLine() = %q, %t, want %q, %t", test.name, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/wad"
+	"math"
+)
+
+const (
+	gridVertex = `#version 330
+
+layout(location = 0) in vec3 vertex;
+layout(location = 1) in vec3 vertColor;
+
+uniform mat4 MVP;
+
+out vec3 fragColor;
+
+void main()
+{
+    fragColor = vertColor;
+    gl_Position = MVP * vec4(vertex, 1.0);
+}` + "\x00"
+
+	gridFragment = `#version 330
+
+in vec3 fragColor;
+
+out vec4 outColor;
+
+void main()
+{
+    outColor = vec4(fragColor, 1.0);
+}` + "\x00"
+)
+
+const (
+	gridStride     = 6
+	gridSpacing    = 128 // Matches the blockmap block size.
+	gridAxisLength = 512
+)
+
+var (
+	gridColor  = mgl32.Vec3{0.3, 0.3, 0.3}
+	gridXColor = mgl32.Vec3{1.0, 0.0, 0.0}
+	gridYColor = mgl32.Vec3{0.0, 1.0, 0.0}
+	gridZColor = mgl32.Vec3{0.0, 0.0, 1.0}
+)
+
+// Grid draws the world axes and a ground grid at height zero in the 3D view
+// for debugging the world coordinate system. The X axis is red, the Y (up)
+// axis green, and the Z axis blue.
+type Grid struct {
+	program  uint32
+	matrixID int32
+	vao      uint32
+	vbo      uint32
+	count    int
+}
+
+// NewGrid uploads a grid that covers the bounding box of a level in the
+// world coordinates of transform.
+func NewGrid(level *wad.Level, transform worldTransform) (*Grid, error) {
+	program, err := newProgram(gridVertex, gridFragment)
+	if err != nil {
+		return nil, err
+	}
+	bbox := level.Bounds()
+	// The transform negates map X unless the map is mirrored, so either
+	// edge can have the smallest world X.
+	low := transform.doomToWorld(bbox.Right, bbox.Bottom, 0)
+	high := transform.doomToWorld(bbox.Left, bbox.Top, 0)
+	if low.X() > high.X() {
+		low[0], high[0] = high.X(), low.X()
+	}
+	x0 := float32(math.Floor(float64(low.X())/gridSpacing) * gridSpacing)
+	x1 := float32(math.Ceil(float64(high.X())/gridSpacing) * gridSpacing)
+	z0 := float32(math.Floor(float64(low.Z())/gridSpacing) * gridSpacing)
+	z1 := float32(math.Ceil(float64(high.Z())/gridSpacing) * gridSpacing)
+
+	lines := []float32{}
+	for x := x0; x <= x1; x += gridSpacing {
+		lines = appendGridLine(lines, mgl32.Vec3{x, 0, z0}, mgl32.Vec3{x, 0, z1}, gridColor)
+	}
+	for z := z0; z <= z1; z += gridSpacing {
+		lines = appendGridLine(lines, mgl32.Vec3{x0, 0, z}, mgl32.Vec3{x1, 0, z}, gridColor)
+	}
+	origin := mgl32.Vec3{}
+	lines = appendGridLine(lines, origin, mgl32.Vec3{gridAxisLength, 0, 0}, gridXColor)
+	lines = appendGridLine(lines, origin, mgl32.Vec3{0, gridAxisLength, 0}, gridYColor)
+	lines = appendGridLine(lines, origin, mgl32.Vec3{0, 0, gridAxisLength}, gridZColor)
+
+	grid := &Grid{
+		program:  program,
+		matrixID: gl.GetUniformLocation(program, gl.Str("MVP\x00")),
+		count:    len(lines) / gridStride,
+	}
+	gl.GenVertexArrays(1, &grid.vao)
+	gl.BindVertexArray(grid.vao)
+	gl.GenBuffers(1, &grid.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, grid.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(lines)*4, gl.Ptr(lines), gl.STATIC_DRAW)
+
+	vertexAttrib := uint32(0)
+	gl.VertexAttribPointer(vertexAttrib, 3, gl.FLOAT, false, gridStride*4, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(vertexAttrib)
+
+	colorAttrib := uint32(1)
+	gl.VertexAttribPointer(colorAttrib, 3, gl.FLOAT, false, gridStride*4, gl.PtrOffset(3*4))
+	gl.EnableVertexAttribArray(colorAttrib)
+
+	return grid, nil
+}
+
+func appendGridLine(lines []float32, start mgl32.Vec3, end mgl32.Vec3, color mgl32.Vec3) []float32 {
+	lines = append(lines, start.X(), start.Y(), start.Z(), color.X(), color.Y(), color.Z())
+	return append(lines, end.X(), end.Y(), end.Z(), color.X(), color.Y(), color.Z())
+}
+
+// Delete releases the shader program and the vertex buffer of the grid.
+func (grid *Grid) Delete() {
+	gl.DeleteProgram(grid.program)
+	gl.DeleteVertexArrays(1, &grid.vao)
+	gl.DeleteBuffers(1, &grid.vbo)
+}
+
+// Render draws the grid with the view projection of the 3D view. The grid
+// is depth tested against the scene.
+func (grid *Grid) Render(mvp mgl32.Mat4) {
+	gl.UseProgram(grid.program)
+	gl.UniformMatrix4fv(grid.matrixID, 1, false, &mvp[0])
+	gl.BindVertexArray(grid.vao)
+	gl.DrawArrays(gl.LINES, 0, int32(grid.count))
+}
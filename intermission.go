@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/glfw/v3.1/glfw"
+	"github.com/penberg/godoom/wad"
+	"os"
+	"strings"
+)
+
+var stretchFlag = cli.BoolFlag{
+	Name:  "stretch",
+	Usage: "Stretch the picture to fill the window instead of keeping 4:3",
+}
+
+var intermissionCommand = cli.Command{
+	Name:  "intermission",
+	Usage: "Show the intermission background of an episode",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "WAD archive",
+			Value: "doom1.wad",
+		},
+		cli.IntFlag{
+			Name:  "episode",
+			Usage: "Episode number",
+			Value: 1,
+		},
+		stretchFlag,
+	},
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		name := intermissionPicture(w, c.Int("episode"))
+		picture, err := w.LoadPicture(name)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := showPicture(w, picture, c.Bool("stretch")); err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var showpicCommand = cli.Command{
+	Name:  "showpic",
+	Usage: "Show a full-screen picture lump such as TITLEPIC or CREDIT",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "WAD archive",
+			Value: "doom1.wad",
+		},
+		cli.StringFlag{
+			Name:  "name,n",
+			Usage: "Picture lump name",
+			Value: "TITLEPIC",
+		},
+		stretchFlag,
+	},
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		picture, err := w.LoadPicture(strings.ToUpper(c.String("name")))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := showPicture(w, picture, c.Bool("stretch")); err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// intermissionPicture returns the name of the intermission background of
+// an episode. The first three episodes have a map of their own and the
+// rest, as well as DOOM II, use INTERPIC.
+func intermissionPicture(w *wad.WAD, episode int) string {
+	if episode >= 1 && episode <= 3 {
+		name := fmt.Sprintf("WIMAP%d", episode-1)
+		if w.HasLump(name) {
+			return name
+		}
+	}
+	return "INTERPIC"
+}
+
+// showPicture displays a full-screen picture until the window is closed or
+// Esc is pressed.
+func showPicture(w *wad.WAD, picture *wad.Image, stretch bool) error {
+	settings := &RenderSettings{Width: defaultWidth, Height: defaultHeight}
+	window, err := openWindow(settings)
+	if err != nil {
+		return err
+	}
+	defer glfw.Terminate()
+	defer window.Destroy()
+
+	renderer, err := NewPictureRenderer(stretch)
+	if err != nil {
+		return err
+	}
+	texture := UploadPicture(pictureToRGBA(w, picture, 0))
+
+	gl.ClearColor(0.0, 0.0, 0.0, 1.0)
+	for !window.ShouldClose() {
+		width, height := window.GetFramebufferSize()
+		gl.Viewport(0, 0, int32(width), int32(height))
+		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		renderer.Draw(texture, 0, 0, picture.Width, picture.Height, width, height)
+
+		window.SwapBuffers()
+		glfw.PollEvents()
+
+		if window.GetKey(glfw.KeyEscape) == glfw.Press {
+			window.SetShouldClose(true)
+		}
+	}
+	return nil
+}
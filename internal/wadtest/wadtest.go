@@ -0,0 +1,189 @@
+// Package wadtest builds WAD files and levels in memory for the tests of
+// the game and of package wad.
+package wadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/penberg/godoom/wad"
+	"math"
+	"testing"
+)
+
+// Level returns an L-shaped room with one sector that a partition line
+// along x = 128 splits into two rectangular subsectors:
+//
+//	(0,256) +-------+ (128,256)
+//	        |       |
+//	        |   1   +-------+ (256,128)
+//	        |       |   0   |
+//	  (0,0) +-------+-------+ (256,0)
+//
+// Subsector 0 is in front of the partition line and subsector 1 behind it.
+// The player starts in subsector 1 at (64, 64) facing north.
+func Level() *wad.Level {
+	level := &wad.Level{
+		Vertexes: []wad.Vertex{
+			{XCoord: 0, YCoord: 0},
+			{XCoord: 0, YCoord: 256},
+			{XCoord: 128, YCoord: 256},
+			{XCoord: 128, YCoord: 128},
+			{XCoord: 256, YCoord: 128},
+			{XCoord: 256, YCoord: 0},
+			{XCoord: 128, YCoord: 0},
+		},
+		Sidedefs: []wad.Sidedef{
+			{MiddleTexture: Name8("WALL"), UpperTexture: Name8("-"), LowerTexture: Name8("-"), SectorRef: 0},
+		},
+		Sectors: []wad.Sector{
+			{FloorHeight: 0, CeilingHeight: 128, Floorpic: Name8("FLOOR"), Ceilingpic: Name8("CEIL"), Lightlevel: 192},
+		},
+		SSectors: []wad.SSector{
+			{Numsegs: 3, StartSeg: 0},
+			{Numsegs: 4, StartSeg: 3},
+		},
+		Nodes: []wad.Node{
+			{
+				X: 128, Y: 0, DX: 0, DY: 256,
+				BBox: [2]wad.BBox{
+					{Top: 128, Bottom: 0, Left: 128, Right: 256},
+					{Top: 256, Bottom: 0, Left: 0, Right: 128},
+				},
+				Child: [2]int16{SubsectorChild(0), SubsectorChild(1)},
+			},
+		},
+		Things: []wad.Thing{
+			{XPosition: 64, YPosition: 64, Angle: 90, Type: 1},
+		},
+	}
+	// The walls go clockwise around the room, so that their right sides
+	// face into it.
+	outline := []int16{0, 1, 2, 3, 4, 5}
+	for i, start := range outline {
+		end := outline[(i+1)%len(outline)]
+		level.Linedefs = append(level.Linedefs, wad.Linedef{VertexStart: start, VertexEnd: end, Flags: wad.LinedefBlocking, SidedefRight: 0, SidedefLeft: -1})
+	}
+	addSeg := func(start int16, end int16, linedef int16, offset int16) {
+		level.Segs = append(level.Segs, wad.Seg{
+			VertexStart: start,
+			VertexEnd:   end,
+			Bams:        Bams(level.Vertexes[start], level.Vertexes[end]),
+			LineNum:     linedef,
+			Segoffset:   offset,
+		})
+	}
+	addSeg(3, 4, 3, 0)
+	addSeg(4, 5, 4, 0)
+	addSeg(5, 6, 5, 0)
+	addSeg(6, 0, 5, 128)
+	addSeg(0, 1, 0, 0)
+	addSeg(1, 2, 1, 0)
+	addSeg(2, 3, 2, 0)
+	return level
+}
+
+// SubsectorChild returns the node child that refers to a subsector.
+func SubsectorChild(ssectorId int) int16 {
+	return int16(uint16(ssectorId | wad.SubsectorBit))
+}
+
+// Bams returns the binary angle of the direction from one vertex to
+// another.
+func Bams(start wad.Vertex, end wad.Vertex) int16 {
+	angle := math.Atan2(float64(end.YCoord-start.YCoord), float64(end.XCoord-start.XCoord))
+	return int16(int32(math.Floor(angle/(2*math.Pi)*65536 + 0.5)))
+}
+
+// Name8 returns a name padded to a lump name.
+func Name8(name string) wad.String8 {
+	var s wad.String8
+	copy(s[:], name)
+	return s
+}
+
+// Lump is a lump of a WAD archive built by BuildWAD.
+type Lump struct {
+	Name string
+	Data []byte
+}
+
+// BuildWAD lays out lumps in a WAD file with the directory at the end.
+func BuildWAD(magic string, lumps []Lump) []byte {
+	var data bytes.Buffer
+	directory := []byte{}
+	offset := 12
+	for _, lump := range lumps {
+		directory = append(directory, EncodeLE(int32(offset), int32(len(lump.Data)), Name8(lump.Name))...)
+		data.Write(lump.Data)
+		offset += len(lump.Data)
+	}
+	header := append([]byte(magic), EncodeLE(int32(len(lumps)), int32(offset))...)
+	return append(append(header, data.Bytes()...), directory...)
+}
+
+// EncodeLE encodes values in little-endian byte order.
+func EncodeLE(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			panic(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// SetDirectoryEntry overwrites the offset and size of a lump in the
+// directory of a WAD file built by BuildWAD.
+func SetDirectoryEntry(data []byte, lumpIdx int, offset int32, size int32) {
+	directory := int(binary.LittleEndian.Uint32(data[8:]))
+	copy(data[directory+16*lumpIdx:], EncodeLE(offset, size))
+}
+
+// IWADLumps returns the lumps that every IWAD must have: a palette and
+// empty patch, texture, and flat lists.
+func IWADLumps() []Lump {
+	return []Lump{
+		{"PLAYPAL", make([]byte, wad.NumPalettes*256*3)},
+		{"PNAMES", EncodeLE(uint32(0))},
+		{"TEXTURE1", EncodeLE(uint32(0))},
+		{"F_START", nil},
+		{"F_END", nil},
+	}
+}
+
+// LevelLumps returns the lumps of a level in the Doom map format.
+func LevelLumps(name string, level *wad.Level) []Lump {
+	things := []byte{}
+	for _, thing := range level.Things {
+		things = append(things, EncodeLE(thing.XPosition, thing.YPosition, thing.Angle, thing.Type, thing.Options)...)
+	}
+	return []Lump{
+		{name, nil},
+		{"THINGS", things},
+		{"LINEDEFS", EncodeLE(level.Linedefs)},
+		{"SIDEDEFS", EncodeLE(level.Sidedefs)},
+		{"VERTEXES", EncodeLE(level.Vertexes)},
+		{"SEGS", EncodeLE(level.Segs)},
+		{"SSECTORS", EncodeLE(level.SSectors)},
+		{"NODES", EncodeLE(level.Nodes)},
+		{"SECTORS", EncodeLE(level.Sectors)},
+		{"REJECT", nil},
+		// A blockmap of one block without linedefs.
+		{"BLOCKMAP", EncodeLE([]uint16{0, 0, 1, 1, 5, 0, 0xffff})},
+	}
+}
+
+// ReadWAD reads a WAD file from memory.
+func ReadWAD(data []byte) (*wad.WAD, error) {
+	return wad.ReadWADContext(context.Background(), bytes.NewReader(data), int64(len(data)))
+}
+
+// MustReadIWAD reads an IWAD built from lumps.
+func MustReadIWAD(tb testing.TB, lumps []Lump) *wad.WAD {
+	w, err := ReadWAD(BuildWAD("IWAD", lumps))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return w
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/go-gl/glfw/v3.1/glfw"
+)
+
+// keyDown holds whether every key that keyPressed checks was down the
+// previous time that it was checked.
+var keyDown = map[glfw.Key]bool{}
+
+// keyPressed returns true if a key went down since the previous time that
+// it was checked, so that a toggle fires once per press however long the
+// key is held down. Keys are polled once per frame, so a key that is not
+// checked every frame can fire late for a press it missed.
+func keyPressed(window *glfw.Window, key glfw.Key) bool {
+	down := window.GetKey(key) == glfw.Press
+	pressed := down && !keyDown[key]
+	keyDown[key] = down
+	return pressed
+}
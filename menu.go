@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/go-gl/glfw/v3.1/glfw"
+)
+
+// The menu is drawn as a column of lines on the Doom screen.
+const (
+	menuX          = 96
+	menuY          = 64
+	menuLineHeight = 12
+	menuCursor     = "> "
+)
+
+// MenuItem is an entry of the menu. Activate is called when Enter is
+// pressed on the item, and Change when Left or Right is pressed with -1 or
+// 1 respectively. Either can be nil.
+type MenuItem struct {
+	Label    func() string
+	Activate func()
+	Change   func(delta int)
+}
+
+// Menu is an in-game menu navigated with the arrow keys and Enter.
+type Menu struct {
+	Items    []MenuItem
+	Active   bool
+	selected int
+}
+
+// NewMenu returns an inactive menu with the first item selected.
+func NewMenu(items []MenuItem) *Menu {
+	return &Menu{Items: items}
+}
+
+// Update moves the selection and activates or changes the selected item by
+// the keys pressed.
+func (menu *Menu) Update(window *glfw.Window) {
+	if keyPressed(window, glfw.KeyUp) {
+		menu.selected = (menu.selected + len(menu.Items) - 1) % len(menu.Items)
+	}
+	if keyPressed(window, glfw.KeyDown) {
+		menu.selected = (menu.selected + 1) % len(menu.Items)
+	}
+	item := menu.Items[menu.selected]
+	if keyPressed(window, glfw.KeyLeft) && item.Change != nil {
+		item.Change(-1)
+	}
+	if keyPressed(window, glfw.KeyRight) && item.Change != nil {
+		item.Change(1)
+	}
+	enter := keyPressed(window, glfw.KeyEnter)
+	if keyPressed(window, glfw.KeyKPEnter) || enter {
+		if item.Activate != nil {
+			item.Activate()
+		}
+	}
+}
+
+// Draw draws the menu with a cursor in front of the selected item.
+func (menu *Menu) Draw(renderer *PictureRenderer, font *Font, fbWidth int, fbHeight int) {
+	for i, item := range menu.Items {
+		cursor := "  "
+		if i == menu.selected {
+			cursor = menuCursor
+		}
+		font.Draw(renderer, cursor+item.Label(), menuX, menuY+i*menuLineHeight, fbWidth, fbHeight)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/penberg/godoom/wad"
+	"os"
+)
+
+var patchesCommand = cli.Command{
+	Name:  "patches",
+	Usage: "Print every PNAMES entry with its lump, size, and offsets",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "WAD archive",
+			Value: "doom1.wad",
+		},
+	},
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		printPatches(w)
+	},
+}
+
+// printPatches prints the lump, size, and picture offsets of every patch
+// in PNAMES. Patches without a lump are flagged as missing and patches
+// whose lump could not be decoded as unreadable.
+func printPatches(w *wad.WAD) {
+	missing, unreadable := 0, 0
+	for i := 0; i < w.PatchCount(); i++ {
+		name, _ := w.PatchName(int16(i))
+		lumpIdx, ok := w.LumpIndex(name)
+		if !ok {
+			missing++
+			fmt.Printf("  %4d: %-8s MISSING\n", i, name)
+			continue
+		}
+		patch, err := w.LoadImage(int16(i))
+		if err != nil {
+			unreadable++
+			fmt.Printf("  %4d: %-8s lump %d, UNREADABLE\n", i, name, lumpIdx)
+			continue
+		}
+		fmt.Printf("  %4d: %-8s lump %d, %dx%d, offsets (%d, %d)\n", i, name, lumpIdx, patch.Width, patch.Height, patch.LeftOffset, patch.TopOffset)
+	}
+	fmt.Printf("%d patches, %d missing, %d unreadable\n", w.PatchCount(), missing, unreadable)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"image"
+)
+
+const (
+	pictureVertex = `#version 330
+
+layout(location = 0) in vec2 vertex;
+layout(location = 1) in vec2 vertTexCoord;
+
+uniform mat4 MVP;
+
+out vec2 fragTexCoord;
+
+void main()
+{
+    fragTexCoord = vertTexCoord;
+    gl_Position = MVP * vec4(vertex, 0.0, 1.0);
+}` + "\x00"
+
+	pictureFragment = `#version 330
+
+uniform sampler2D tex;
+
+in vec2 fragTexCoord;
+
+out vec4 outColor;
+
+void main()
+{
+    vec4 texel = texture(tex, fragTexCoord);
+    if (texel.a == 0.0) {
+        discard;
+    }
+    outColor = texel;
+}` + "\x00"
+)
+
+// Doom draws 2D graphics on a 320x200 screen that is displayed with a 4:3
+// aspect ratio.
+const (
+	screenWidth  = 320
+	screenHeight = 200
+	screenAspect = 4.0 / 3.0
+)
+
+// PictureRenderer draws 2D pictures in the coordinates of the 320x200 Doom
+// screen.
+type PictureRenderer struct {
+	stretch  bool // Fill the whole framebuffer instead of pillarboxing.
+	program  uint32
+	matrixID int32
+	vao      uint32
+	vbo      uint32
+}
+
+// NewPictureRenderer creates a renderer for 2D pictures. The Doom screen
+// is stretched to fill the framebuffer if stretch is set and presented at
+// its 4:3 aspect ratio otherwise.
+func NewPictureRenderer(stretch bool) (*PictureRenderer, error) {
+	program, err := newProgram(pictureVertex, pictureFragment)
+	if err != nil {
+		return nil, err
+	}
+	r := &PictureRenderer{
+		stretch:  stretch,
+		program:  program,
+		matrixID: gl.GetUniformLocation(program, gl.Str("MVP\x00")),
+	}
+	gl.GenVertexArrays(1, &r.vao)
+	gl.BindVertexArray(r.vao)
+	gl.GenBuffers(1, &r.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, r.vbo)
+
+	vertexAttrib := uint32(0)
+	gl.VertexAttribPointer(vertexAttrib, 2, gl.FLOAT, false, 4*4, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(vertexAttrib)
+
+	texCoordAttrib := uint32(1)
+	gl.VertexAttribPointer(texCoordAttrib, 2, gl.FLOAT, false, 4*4, gl.PtrOffset(2*4))
+	gl.EnableVertexAttribArray(texCoordAttrib)
+
+	return r, nil
+}
+
+// Delete releases the shader program and the vertex buffer of the
+// renderer.
+func (r *PictureRenderer) Delete() {
+	gl.DeleteProgram(r.program)
+	gl.DeleteVertexArrays(1, &r.vao)
+	gl.DeleteBuffers(1, &r.vbo)
+}
+
+// UploadPicture uploads a picture as a GL texture with nearest filtering
+// to keep the pixels sharp.
+func UploadPicture(rgba *image.RGBA) uint32 {
+	return uploadTexture(rgba, &RenderSettings{}, FilterNearest)
+}
+
+// screenViewport returns the viewport that presents the 320x200 Doom
+// screen in a framebuffer. The screen keeps its 4:3 aspect ratio and is
+// centered with black bars on the sides of wide framebuffers and above and
+// below tall ones, unless it is stretched to fill the framebuffer.
+func screenViewport(fbWidth int, fbHeight int, stretch bool) (int, int, int, int) {
+	if stretch || fbWidth <= 0 || fbHeight <= 0 {
+		return 0, 0, fbWidth, fbHeight
+	}
+	width, height := fbWidth, fbHeight
+	if float32(fbWidth)/float32(fbHeight) > screenAspect {
+		width = int(float32(fbHeight)*screenAspect + 0.5)
+	} else {
+		height = int(float32(fbWidth)/screenAspect + 0.5)
+	}
+	return (fbWidth - width) / 2, (fbHeight - height) / 2, width, height
+}
+
+// Draw draws a texture of the given size at a position on the Doom screen.
+func (r *PictureRenderer) Draw(texture uint32, x int, y int, width int, height int, fbWidth int, fbHeight int) {
+	viewX, viewY, viewWidth, viewHeight := screenViewport(fbWidth, fbHeight, r.stretch)
+	gl.Viewport(int32(viewX), int32(viewY), int32(viewWidth), int32(viewHeight))
+	defer gl.Viewport(0, 0, int32(fbWidth), int32(fbHeight))
+
+	// The screen origin is at the top left corner like in Doom.
+	mvp := mgl32.Ortho2D(0, screenWidth, screenHeight, 0)
+
+	x0, y0 := float32(x), float32(y)
+	x1, y1 := float32(x+width), float32(y+height)
+	vertices := []float32{
+		x0, y0, 0, 0,
+		x0, y1, 0, 1,
+		x1, y1, 1, 1,
+		x1, y1, 1, 1,
+		x1, y0, 1, 0,
+		x0, y0, 0, 0,
+	}
+
+	gl.Disable(gl.DEPTH_TEST)
+	defer gl.Enable(gl.DEPTH_TEST)
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+
+	gl.UseProgram(r.program)
+	gl.UniformMatrix4fv(r.matrixID, 1, false, &mvp[0])
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, texture)
+	gl.BindVertexArray(r.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, r.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*4, gl.Ptr(vertices), gl.STREAM_DRAW)
+	gl.DrawArrays(gl.TRIANGLES, 0, int32(len(vertices)/4))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"github.com/go-gl/glfw/v3.1/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+	"math"
+)
+
+// Doom players start a level with full health and no armor. Armor absorbs
+// a third of the damage like the green armor.
+const (
+	playerMaxHealth = 100
+	armorAbsorption = 3
+)
+
+// The player moves and turns once per tic.
+const (
+	playerMoveSpeed = 8.0 // Map units per tic.
+	playerTurnSpeed = 8.0 // Degrees per tic.
+	playerLookSpeed = 4.0 // Degrees of pitch per tic.
+)
+
+// playerMaxPitch is how far the player can look up or down in degrees.
+// Walls stay upright, so looking further would distort the view.
+const playerMaxPitch = 45.0
+
+// Player holds the gameplay state of the player.
+type Player struct {
+	Health int
+	Armor  int
+}
+
+// NewPlayer returns a player with full health and no armor.
+func NewPlayer() *Player {
+	return &Player{Health: playerMaxHealth}
+}
+
+// Damage hurts the player. Part of the damage is absorbed by armor while
+// it lasts, and health never drops below zero.
+func (player *Player) Damage(amount int) {
+	saved := amount / armorAbsorption
+	if saved > player.Armor {
+		saved = player.Armor
+	}
+	player.Armor -= saved
+	player.Health -= amount - saved
+	if player.Health < 0 {
+		player.Health = 0
+	}
+}
+
+// Dead returns true if the player has no health left.
+func (player *Player) Dead() bool {
+	return player.Health <= 0
+}
+
+// PlayerPose is where the player is and looks at the end of a tic.
+type PlayerPose struct {
+	Position mgl32.Vec2 // Position in map coordinates.
+	Angle    float32    // View angle in degrees like the angle of viewDirection.
+	Pitch    float32    // Pitch in degrees, positive is up.
+	BobPhase float32    // Phase of the view bob.
+	Speed    float32    // Distance moved in the tic relative to full speed.
+}
+
+// Move returns the pose after a tic of moving and turning by the arrow keys
+// held down and the gamepad sticks. The right stick also looks up and down.
+// While paused the player can look around but not move. transform is the
+// transform of the 3D view, so that moving forward heads where the view
+// looks.
+func (pose PlayerPose) Move(window *glfw.Window, gamepad GamepadInput, paused bool, transform worldTransform) PlayerPose {
+	next := pose
+	// Forward is towards the center of the screen and right is a quarter
+	// turn clockwise from it.
+	mapDirection, _ := transform.worldToMap(viewDirection(pose.Angle))
+	mapRight, _ := transform.worldToMap(viewDirection(pose.Angle + 90))
+	forward, strafe, turn := gamepad.Forward, gamepad.Strafe, gamepad.Turn
+	if window.GetKey(glfw.KeyUp) == glfw.Press {
+		forward++
+	}
+	if window.GetKey(glfw.KeyDown) == glfw.Press {
+		forward--
+	}
+	if window.GetKey(glfw.KeyLeft) == glfw.Press {
+		turn--
+	}
+	if window.GetKey(glfw.KeyRight) == glfw.Press {
+		turn++
+	}
+	moveSpeed := float32(playerMoveSpeed)
+	if gamepad.Run {
+		moveSpeed *= playerRunFactor
+	}
+	if !paused {
+		step := mapDirection.Mul(clampUnit(forward)).Add(mapRight.Mul(clampUnit(strafe)))
+		next.Position = next.Position.Add(step.Mul(moveSpeed))
+	}
+	next.Angle = normalizeAngle(next.Angle + clampUnit(turn)*playerTurnSpeed)
+	next.Pitch = mgl32.Clamp(next.Pitch+gamepad.Look*playerLookSpeed, -playerMaxPitch, playerMaxPitch)
+	moved := next.Position.Sub(pose.Position).Len()
+	next.BobPhase += moved * viewBobFrequency
+	next.Speed = moved / playerMoveSpeed
+	return next
+}
+
+// Lerp returns the pose a fraction t of the way from pose to next. The
+// angle turns the short way around, so that turning past north does not
+// swing the view all the way around.
+func (pose PlayerPose) Lerp(next PlayerPose, t float32) PlayerPose {
+	lerp := func(from float32, to float32) float32 {
+		return from + (to-from)*t
+	}
+	turn := normalizeAngle(next.Angle - pose.Angle)
+	if turn > 180 {
+		turn -= 360
+	}
+	return PlayerPose{
+		Position: pose.Position.Add(next.Position.Sub(pose.Position).Mul(t)),
+		Angle:    normalizeAngle(pose.Angle + turn*t),
+		Pitch:    lerp(pose.Pitch, next.Pitch),
+		BobPhase: lerp(pose.BobPhase, next.BobPhase),
+		Speed:    lerp(pose.Speed, next.Speed),
+	}
+}
+
+// RoundedAngle returns the view angle rounded to whole degrees.
+func (pose PlayerPose) RoundedAngle() int16 {
+	return int16(math.Floor(float64(pose.Angle)+0.5)) % 360
+}
+
+// pitchDirection tilts a level view direction up by pitch degrees.
+func pitchDirection(direction mgl32.Vec3, pitch float32) mgl32.Vec3 {
+	sin, cos := math.Sincos(float64(pitch) * math.Pi / 180)
+	return direction.Mul(float32(cos)).Add(mgl32.Vec3{0, float32(sin), 0})
+}
+
+// normalizeAngle wraps an angle in degrees to the range from 0 up to 360.
+func normalizeAngle(angle float32) float32 {
+	angle = float32(math.Mod(float64(angle), 360))
+	if angle < 0 {
+		angle += 360
+	}
+	// Tiny negative angles round up to 360.
+	if angle >= 360 {
+		angle = 0
+	}
+	return angle
+}
+
+// clampUnit clamps a value to the range from -1 to 1, so that a key and a
+// stick held in the same direction do not add up.
+func clampUnit(value float32) float32 {
+	return mgl32.Clamp(value, -1, 1)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeAngle(t *testing.T) {
+	tests := []struct {
+		angle float32
+		want  float32
+	}{
+		{0, 0},
+		{90, 90},
+		{360, 0},
+		{368, 8},
+		{-8, 352},
+		{-720, 0},
+		{-1e-6, 0},
+		{1e6, 280},
+	}
+	for _, test := range tests {
+		if got := normalizeAngle(test.angle); math.Abs(float64(got-test.want)) > 1e-3 {
+			t.Errorf("normalizeAngle(%f) = %f, want %f", test.angle, got, test.want)
+		}
+	}
+}
+
+func TestPlayerPoseLerp(t *testing.T) {
+	tests := []struct {
+		from, to float32
+		t        float32
+		want     float32
+	}{
+		{10, 30, 0.5, 20},
+		{356, 4, 0.5, 0},
+		{356, 4, 0.25, 358},
+		{4, 356, 0.75, 358},
+		{90, 270, 0.5, 180},
+	}
+	for _, test := range tests {
+		got := PlayerPose{Angle: test.from}.Lerp(PlayerPose{Angle: test.to}, test.t).Angle
+		if math.Abs(float64(got-test.want)) > 1e-3 {
+			t.Errorf("Lerp from %f to %f by %f turns to %f, want %f", test.from, test.to, test.t, got, test.want)
+		}
+	}
+}
+
+func TestRoundedAngle(t *testing.T) {
+	for _, angle := range []float32{0, 45.4, 180, 359.4, 359.6} {
+		got := PlayerPose{Angle: angle}.RoundedAngle()
+		if got < 0 || got >= 360 {
+			t.Errorf("RoundedAngle() of %f = %d, want 0 up to 360", angle, got)
+		}
+	}
+}
+
+func TestPitchDirection(t *testing.T) {
+	direction := viewDirection(30)
+	for _, pitch := range []float32{-playerMaxPitch, 0, 20, playerMaxPitch} {
+		got := pitchDirection(direction, pitch)
+		want := float32(math.Sin(float64(pitch) * math.Pi / 180))
+		if math.Abs(float64(got.Len()-1)) > 1e-5 || math.Abs(float64(got.Y()-want)) > 1e-5 {
+			t.Errorf("pitchDirection(%v, %f) = %v, want a unit vector with height %f", direction, pitch, got, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/wad"
+	"image"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sceneCacheVersion must be bumped whenever the layout of the cached scene
+// data changes.
+const sceneCacheVersion = 11
+
+// sceneCache is the on-disk representation of a generated scene before it
+// is uploaded to the GPU.
+type sceneCache struct {
+	Version    int
+	WADModTime time.Time
+	Palette    int
+	Smooth     bool
+	Mirror     bool
+	Meshes     map[int][]cachedMesh
+	Vertices   []float32
+	Textures   map[string]*image.RGBA
+	Flats      map[string]*image.RGBA
+}
+
+type cachedMesh struct {
+	Texture     string
+	Flat        bool
+	First       int
+	Count       int
+	Origin      mgl32.Vec3
+	LightLevel  float32
+	Sidedef     int
+	Sector      int
+	DoubleSided bool
+	Alpha       float32
+	ScrollTics  int
+}
+
+// sceneCachePath returns the cache file of a level. The file name is keyed
+// by the checksum of the WAD archive so that a modified archive never hits
+// a stale cache.
+func sceneCachePath(w *wad.WAD, levelName string) (string, error) {
+	checksum, err := w.Checksum()
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "godoom", fmt.Sprintf("%x-%s.scene", checksum, levelName)), nil
+}
+
+// loadSceneCache reads a cached scene. It returns false if there is no
+// usable cache for the WAD archive.
+func loadSceneCache(path string, w *wad.WAD, settings *RenderSettings) (*Scene, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	var cache sceneCache
+	if err := gob.NewDecoder(file).Decode(&cache); err != nil {
+		fmt.Printf("warning: Ignoring unreadable scene cache: %s\n", err)
+		return nil, false
+	}
+	modTime, err := w.ModTime()
+	if err != nil || cache.Version != sceneCacheVersion || !cache.WADModTime.Equal(modTime) || cache.Palette != settings.composePalette() || cache.Smooth != settings.SmoothLighting || cache.Mirror != settings.Mirror {
+		return nil, false
+	}
+	scene := NewScene(settings)
+	for id, meshes := range cache.Meshes {
+		for _, m := range meshes {
+			scene.meshes[id] = append(scene.meshes[id], Mesh{
+				texture:     m.Texture,
+				flat:        m.Flat,
+				first:       m.First,
+				count:       m.Count,
+				origin:      m.Origin,
+				lightLevel:  m.LightLevel,
+				sidedef:     m.Sidedef,
+				sector:      m.Sector,
+				doubleSided: m.DoubleSided,
+				alpha:       m.Alpha,
+				scrollTics:  m.ScrollTics,
+			})
+		}
+	}
+	scene.vertices = cache.Vertices
+	scene.textures = cache.Textures
+	scene.flats = cache.Flats
+	return &scene, true
+}
+
+// saveSceneCache writes a generated scene that has not been uploaded yet.
+func saveSceneCache(path string, w *wad.WAD, scene *Scene) error {
+	modTime, err := w.ModTime()
+	if err != nil {
+		return err
+	}
+	cache := sceneCache{
+		Version:    sceneCacheVersion,
+		WADModTime: modTime,
+		Palette:    scene.settings.composePalette(),
+		Smooth:     scene.settings.SmoothLighting,
+		Mirror:     scene.settings.Mirror,
+		Meshes:     make(map[int][]cachedMesh),
+		Vertices:   scene.vertices,
+		Textures:   scene.textures,
+		Flats:      scene.flats,
+	}
+	for id, meshes := range scene.meshes {
+		for _, m := range meshes {
+			cache.Meshes[id] = append(cache.Meshes[id], cachedMesh{
+				Texture:     m.texture,
+				Flat:        m.flat,
+				First:       m.first,
+				Count:       m.count,
+				Origin:      m.origin,
+				LightLevel:  m.lightLevel,
+				Sidedef:     m.sidedef,
+				Sector:      m.sector,
+				DoubleSided: m.doubleSided,
+				Alpha:       m.alpha,
+				ScrollTics:  m.scrollTics,
+			})
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(&cache); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/penberg/godoom/wad"
+)
+
+// secretMessage is shown when the player enters a secret sector.
+const secretMessage = "A secret is revealed!"
+
+// Secrets tracks the secret sectors of a level that the player has found.
+type Secrets struct {
+	found map[int]bool // Found state indexed by the ID of every secret sector.
+	count int
+}
+
+// NewSecrets returns the secret state of a level with no secrets found.
+func NewSecrets(level *wad.Level) *Secrets {
+	secrets := &Secrets{found: make(map[int]bool)}
+	for id, sector := range level.Sectors {
+		if sector.SpecialSector == wad.SectorSecret {
+			secrets.found[id] = false
+		}
+	}
+	return secrets
+}
+
+// Enter marks a secret sector as found when the player enters it. It
+// returns true if the sector is a secret that had not been found yet.
+func (secrets *Secrets) Enter(sectorId int) bool {
+	found, secret := secrets.found[sectorId]
+	if !secret || found {
+		return false
+	}
+	secrets.found[sectorId] = true
+	secrets.count++
+	return true
+}
+
+// Found returns the number of secrets found.
+func (secrets *Secrets) Found() int {
+	return secrets.count
+}
+
+// Total returns the number of secrets on the level.
+func (secrets *Secrets) Total() int {
+	return len(secrets.found)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/penberg/godoom/wad"
+)
+
+// Skill levels, numbered like the -skill parameter of Doom.
+const (
+	SkillBaby      = 1 // I'm too young to die.
+	SkillEasy      = 2 // Hey, not too rough.
+	SkillMedium    = 3 // Hurt me plenty.
+	SkillHard      = 4 // Ultra-Violence.
+	SkillNightmare = 5 // Nightmare!
+)
+
+// GameSettings are the options of a game that change how it plays rather
+// than how it looks.
+type GameSettings struct {
+	Skill           int
+	FastMonsters    bool    // Monsters move and attack faster.
+	RespawnMonsters bool    // Killed monsters come back after a while.
+	TimeScale       float64 // Speed of game time relative to wall time.
+}
+
+// NewGameSettings returns the settings of a game on a skill level with game
+// time at normal speed. Like in Doom, Nightmare makes monsters fast and
+// respawn.
+func NewGameSettings(skill int) *GameSettings {
+	return &GameSettings{
+		Skill:           skill,
+		FastMonsters:    skill == SkillNightmare,
+		RespawnMonsters: skill == SkillNightmare,
+		TimeScale:       1.0,
+	}
+}
+
+// ScaleDamage returns the damage that the player takes from an amount of
+// damage. The easiest skill halves it.
+func (settings *GameSettings) ScaleDamage(damage int) int {
+	if settings.Skill == SkillBaby {
+		return damage / 2
+	}
+	return damage
+}
+
+// SpawnsThing returns true if a thing is present in a single player game
+// on the skill level. Like in Doom, player 1 starts on every skill and
+// deathmatch starts only matter in deathmatch.
+func (settings *GameSettings) SpawnsThing(thing *wad.Thing) bool {
+	switch thing.Type {
+	case wad.ThingPlayer1Start:
+		return true
+	case wad.ThingPlayer2Start, wad.ThingPlayer3Start, wad.ThingPlayer4Start, wad.ThingDeathmatchStart:
+		return false
+	}
+	if thing.Options&wad.ThingMultiplayer != 0 {
+		return false
+	}
+	switch settings.Skill {
+	case SkillBaby, SkillEasy:
+		return thing.Options&wad.ThingSkillEasy != 0
+	case SkillMedium:
+		return thing.Options&wad.ThingSkillMedium != 0
+	}
+	return thing.Options&wad.ThingSkillHard != 0
+}
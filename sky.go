@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/wad"
+	"strconv"
+	"strings"
+)
+
+const (
+	// skyVertex draws a triangle that covers the screen.
+	skyVertex = `#version 330
+
+out vec2 fragNDC;
+
+void main()
+{
+    vec2 position = vec2((gl_VertexID & 1) * 4.0 - 1.0, (gl_VertexID >> 1) * 4.0 - 1.0);
+    fragNDC = position;
+    gl_Position = vec4(position, 1.0, 1.0);
+}` + "\x00"
+
+	// skyFragment looks the sky up on a cylinder around the eye by the
+	// direction of every pixel, so the sky does not stretch with the field
+	// of view or the aspect ratio.
+	skyFragment = `#version 330
+
+const float Pi = 3.14159265;
+
+uniform mat4 InverseViewProjection;
+uniform float XSign;
+uniform vec2 TexSize;
+uniform float Gamma;
+uniform sampler2D tex;
+
+in vec2 fragNDC;
+
+out vec4 outColor;
+
+void main()
+{
+    vec4 far = InverseViewProjection * vec4(fragNDC, 1.0, 1.0);
+    vec3 direction = far.xyz / far.w;
+    vec2 mapDirection = vec2(XSign * direction.x, direction.z);
+    // Like in Doom, 256 sky columns span 90 degrees and the horizon is on
+    // row 100, with as many rows per unit of slope as columns per unit of
+    // tangent at the center of a 320 pixel wide screen.
+    float angle = atan(mapDirection.y, mapDirection.x);
+    float u = angle / (Pi / 2.0) * 256.0 / TexSize.x;
+    float slope = direction.y / length(mapDirection);
+    float v = clamp((100.0 - slope * 160.0) / TexSize.y, 0.0, 1.0);
+    vec4 texel = textureLod(tex, vec2(fract(u), v), 0.0);
+    outColor = vec4(pow(texel.rgb, vec3(1.0 / Gamma)), 1.0);
+}` + "\x00"
+)
+
+// Sky draws the sky texture of a level behind the scene. It pans with the
+// view angle like the sky of vanilla Doom, and stays put when looking up
+// or down.
+type Sky struct {
+	program   uint32
+	inverseID int32
+	xSignID   int32
+	texSizeID int32
+	gammaID   int32
+	vao       uint32
+	texture   uint32
+	size      mgl32.Vec2
+}
+
+// skyTextureName returns the name of the sky texture of a level. Doom
+// uses SKY1 to SKY4 for the episodes and Doom II switches from SKY1 to
+// SKY2 at MAP12 and to SKY3 at MAP21.
+func skyTextureName(levelName string) string {
+	var episode, mapNumber int
+	if n, err := fmt.Sscanf(levelName, "E%dM%d", &episode, &mapNumber); err == nil && n == 2 {
+		return fmt.Sprintf("SKY%d", episode)
+	}
+	if strings.HasPrefix(levelName, "MAP") {
+		if mapNumber, err := strconv.Atoi(levelName[3:]); err == nil {
+			switch {
+			case mapNumber >= 21:
+				return "SKY3"
+			case mapNumber >= 12:
+				return "SKY2"
+			}
+		}
+	}
+	return "SKY1"
+}
+
+// NewSky uploads the sky texture of a level.
+func NewSky(w *wad.WAD, levelName string, settings *RenderSettings) (*Sky, error) {
+	name := skyTextureName(levelName)
+	rgba, err := composeTexture(w, name, settings.Palette)
+	if err != nil {
+		return nil, err
+	}
+	program, err := newProgram(skyVertex, skyFragment)
+	if err != nil {
+		return nil, err
+	}
+	sky := &Sky{
+		program:   program,
+		inverseID: gl.GetUniformLocation(program, gl.Str("InverseViewProjection\x00")),
+		xSignID:   gl.GetUniformLocation(program, gl.Str("XSign\x00")),
+		texSizeID: gl.GetUniformLocation(program, gl.Str("TexSize\x00")),
+		gammaID:   gl.GetUniformLocation(program, gl.Str("Gamma\x00")),
+		texture:   uploadTexture(rgba, &RenderSettings{}, settings.WallFilter),
+		size:      mgl32.Vec2{float32(rgba.Rect.Dx()), float32(rgba.Rect.Dy())},
+	}
+	gl.GenVertexArrays(1, &sky.vao)
+	return sky, nil
+}
+
+// Delete releases the shader program and the texture of the sky.
+func (sky *Sky) Delete() {
+	gl.DeleteProgram(sky.program)
+	gl.DeleteVertexArrays(1, &sky.vao)
+	gl.DeleteTextures(1, &sky.texture)
+}
+
+// Render draws the sky for a view direction without writing depth, so that
+// everything drawn afterwards covers it.
+func (sky *Sky) Render(settings *RenderSettings, direction mgl32.Vec3, width int, height int) {
+	// The sky is infinitely far away, so only the direction of the view
+	// matters and the eye stays at the origin.
+	inverse := viewProjection(settings, mgl32.Vec3{}, direction, width, height).Inv()
+
+	gl.UseProgram(sky.program)
+	gl.UniformMatrix4fv(sky.inverseID, 1, false, &inverse[0])
+	gl.Uniform1f(sky.xSignID, settings.transform().xSign)
+	gl.Uniform2f(sky.texSizeID, sky.size.X(), sky.size.Y())
+	gl.Uniform1f(sky.gammaID, settings.Gamma)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, sky.texture)
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.DepthMask(false)
+	gl.BindVertexArray(sky.vao)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+	gl.DepthMask(true)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/wad"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+)
+
+var renderCommand = cli.Command{
+	Name:  "render",
+	Usage: "Render the view from the player 1 start into a PNG image without a GPU",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "WAD archive",
+			Value: "doom1.wad",
+		},
+		cli.IntFlag{
+			Name:  "level,l",
+			Usage: "Level number",
+			Value: 1,
+		},
+		cli.IntFlag{
+			Name:  "episode",
+			Usage: "Episode number of an ExMy level, used with --map",
+		},
+		cli.IntFlag{
+			Name:  "map",
+			Usage: "Map number of an ExMy level, used with --episode",
+		},
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "Output PNG image, named after the level by default",
+		},
+		cli.IntFlag{
+			Name:  "width",
+			Usage: "Image width",
+			Value: defaultWidth,
+		},
+		cli.IntFlag{
+			Name:  "height",
+			Usage: "Image height",
+			Value: defaultHeight,
+		},
+		cli.StringFlag{
+			Name:  "compare",
+			Usage: "Compare the image with a reference PNG instead of writing it and fail if they differ",
+		},
+		cli.IntFlag{
+			Name:  "tolerance",
+			Usage: "Largest difference of a color channel that --compare accepts",
+			Value: defaultCompareTolerance,
+		},
+	},
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		levelName, err := selectLevelFlags(w, c)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		level, err := w.ReadLevel(levelName)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		width, height := c.Int("width"), c.Int("height")
+		if width <= 0 || height <= 0 {
+			fmt.Printf("error: Invalid image size %dx%d!\n", width, height)
+			os.Exit(1)
+		}
+		output := c.String("output")
+		if output == "" {
+			output = levelName + ".png"
+		}
+		settings := &RenderSettings{
+			Width:     width,
+			Height:    height,
+			Gamma:     1.0,
+			Near:      defaultNear,
+			Far:       defaultFar,
+			LevelName: levelName,
+		}
+		scene, err := buildScene(w, level, settings)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		start, angle, err := playerStart(level)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		position := mgl32.Vec2{float32(start.X), float32(start.Y)}
+		transform := settings.transform()
+		eye := transform.mapToWorld(position, float32(eyeHeight(level, position, 0)))
+		direction := viewDirection(float32(transform.mapAngleToView(angle)))
+		rgba := NewSoftwareRenderer(settings).Render(scene, eye, direction, width, height)
+		if reference := c.String("compare"); reference != "" {
+			if err := compareWithPNG(rgba, reference, c.Int("tolerance")); err != nil {
+				fmt.Printf("error: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		file, err := os.Create(output)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		if err := png.Encode(file, rgba); err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// defaultCompareTolerance absorbs rounding differences between platforms
+// in the color channels of compared images.
+const defaultCompareTolerance = 2
+
+// compareWithPNG compares an image with a reference PNG. It fails if the
+// sizes differ or if any color channel differs by more than tolerance, and
+// reports how many pixels do.
+func compareWithPNG(rgba *image.RGBA, path string, tolerance int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reference, err := png.Decode(file)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	bounds := rgba.Bounds()
+	if reference.Bounds().Size() != bounds.Size() {
+		return fmt.Errorf("%s: image is %v, want %v", path, bounds.Size(), reference.Bounds().Size())
+	}
+	origin := reference.Bounds().Min
+	mismatched := 0
+	worst := 0
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			got := rgba.RGBAAt(x, y)
+			r, g, b, _ := reference.At(origin.X+x, origin.Y+y).RGBA()
+			want := [3]int{int(r >> 8), int(g >> 8), int(b >> 8)}
+			pixelWorst := 0
+			for k, channel := range [3]uint8{got.R, got.G, got.B} {
+				diff := int(channel) - want[k]
+				if diff < 0 {
+					diff = -diff
+				}
+				if diff > pixelWorst {
+					pixelWorst = diff
+				}
+			}
+			if pixelWorst > tolerance {
+				mismatched++
+			}
+			if pixelWorst > worst {
+				worst = pixelWorst
+			}
+		}
+	}
+	if mismatched > 0 {
+		return fmt.Errorf("%d pixels differ from %s by up to %d", mismatched, path, worst)
+	}
+	return nil
+}
+
+// flatDepthBias pushes flats back in view depth so that they don't fight
+// with the walls that they meet, like the polygon offset of the GPU path.
+const flatDepthBias = 1.001
+
+// SoftwareRenderer rasterizes a scene on the CPU without a GL context. It
+// draws the textured triangles of every mesh with the sector light, fog,
+// translucency, and gamma of the GPU path, sampling textures without
+// filtering. Scrolling and sector highlighting are not drawn. The scene
+// must not have been uploaded, as uploading drops its vertices.
+type SoftwareRenderer struct {
+	settings *RenderSettings
+}
+
+func NewSoftwareRenderer(settings *RenderSettings) *SoftwareRenderer {
+	return &SoftwareRenderer{settings: settings}
+}
+
+// softVertex is a vertex of a triangle being rasterized.
+type softVertex struct {
+	clip     mgl32.Vec4
+	u, v     float32
+	light    float32
+	distance float32 // Distance from the eye, for fog.
+}
+
+// softTarget is the image being rendered into and its depth buffer, which
+// holds the view depth of every pixel.
+type softTarget struct {
+	image *image.RGBA
+	depth []float32
+}
+
+// Render draws the scene as seen from the eye looking in the given
+// direction into a new image of the given size.
+func (r *SoftwareRenderer) Render(scene *Scene, eye mgl32.Vec3, direction mgl32.Vec3, width int, height int) *image.RGBA {
+	target := &softTarget{
+		image: image.NewRGBA(image.Rect(0, 0, width, height)),
+		depth: make([]float32, width*height),
+	}
+	fog := r.settings.FogColor
+	for i := range target.depth {
+		target.depth[i] = float32(math.Inf(1))
+		target.image.Pix[i*4+0] = uint8(fog.X()*255 + 0.5)
+		target.image.Pix[i*4+1] = uint8(fog.Y()*255 + 0.5)
+		target.image.Pix[i*4+2] = uint8(fog.Z()*255 + 0.5)
+		target.image.Pix[i*4+3] = 255
+	}
+	mvp := viewProjection(r.settings, eye, direction, width, height)
+
+	// Meshes are drawn in subsector order so that the image only depends
+	// on the scene. The depth buffer sorts opaque meshes and translucent
+	// meshes are blended afterwards from back to front.
+	ids := []int{}
+	for id := range scene.meshes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	translucent := []*Mesh{}
+	for _, id := range ids {
+		meshes := scene.meshes[id]
+		for i := range meshes {
+			if meshes[i].alpha < 1 {
+				translucent = append(translucent, &meshes[i])
+				continue
+			}
+			r.drawMesh(target, scene, &meshes[i], mvp, eye)
+		}
+	}
+	sort.SliceStable(translucent, func(i, j int) bool {
+		return r.meshDistance(scene, translucent[i], eye) > r.meshDistance(scene, translucent[j], eye)
+	})
+	for _, mesh := range translucent {
+		r.drawMesh(target, scene, mesh, mvp, eye)
+	}
+	return target.image
+}
+
+// meshDistance returns the distance from the eye to the first vertex of a
+// mesh.
+func (r *SoftwareRenderer) meshDistance(scene *Scene, mesh *Mesh, eye mgl32.Vec3) float32 {
+	return scene.vertexPosition(mesh, 0).Sub(eye).Len()
+}
+
+// drawMesh clips the triangles of a mesh against the near plane and
+// rasterizes them.
+func (r *SoftwareRenderer) drawMesh(target *softTarget, scene *Scene, mesh *Mesh, mvp mgl32.Mat4, eye mgl32.Vec3) {
+	for i := 0; i+2 < mesh.count; i += 3 {
+		var triangle [3]softVertex
+		for k := range triangle {
+			data := scene.vertices[(mesh.first+i+k)*vertexFloats:]
+			position := scene.vertexPosition(mesh, i+k)
+			triangle[k] = softVertex{
+				clip:     mvp.Mul4x1(position.Vec4(1)),
+				u:        data[3],
+				v:        data[4],
+				light:    data[5],
+				distance: position.Sub(eye).Len(),
+			}
+		}
+		polygon := clipNear(triangle[:])
+		for k := 1; k+1 < len(polygon); k++ {
+			r.drawTriangle(target, scene, mesh, polygon[0], polygon[k], polygon[k+1])
+		}
+	}
+}
+
+// clipNear clips a polygon in clip coordinates against the near plane,
+// where z = -w.
+func clipNear(polygon []softVertex) []softVertex {
+	inside := func(v softVertex) float32 {
+		return v.clip.Z() + v.clip.W()
+	}
+	clipped := []softVertex{}
+	for i := range polygon {
+		a, b := polygon[i], polygon[(i+1)%len(polygon)]
+		da, db := inside(a), inside(b)
+		if da >= 0 {
+			clipped = append(clipped, a)
+		}
+		if (da >= 0) != (db >= 0) {
+			clipped = append(clipped, lerpSoftVertex(a, b, da/(da-db)))
+		}
+	}
+	return clipped
+}
+
+func lerpSoftVertex(a softVertex, b softVertex, t float32) softVertex {
+	lerp := func(x, y float32) float32 {
+		return x + (y-x)*t
+	}
+	return softVertex{
+		clip:     a.clip.Add(b.clip.Sub(a.clip).Mul(t)),
+		u:        lerp(a.u, b.u),
+		v:        lerp(a.v, b.v),
+		light:    lerp(a.light, b.light),
+		distance: lerp(a.distance, b.distance),
+	}
+}
+
+// drawTriangle rasterizes a triangle in front of the near plane with
+// perspective correct texture coordinates.
+func (r *SoftwareRenderer) drawTriangle(target *softTarget, scene *Scene, mesh *Mesh, a softVertex, b softVertex, c softVertex) {
+	vertices := [3]softVertex{a, b, c}
+	bounds := target.image.Bounds()
+	width, height := float32(bounds.Dx()), float32(bounds.Dy())
+	var x, y, invW [3]float32
+	for k, v := range vertices {
+		invW[k] = 1 / v.clip.W()
+		x[k] = (v.clip.X()*invW[k] + 1) / 2 * width
+		y[k] = (1 - v.clip.Y()*invW[k]) / 2 * height
+	}
+	// The area is positive for triangles that are clockwise on the screen,
+	// which is the front side unless the map is mirrored.
+	area := (x[1]-x[0])*(y[2]-y[0]) - (x[2]-x[0])*(y[1]-y[0])
+	if area == 0 {
+		return
+	}
+	front := area > 0
+	if r.settings.Mirror {
+		front = !front
+	}
+	if !front && !mesh.doubleSided {
+		return
+	}
+
+	images := scene.textures
+	if mesh.flat {
+		images = scene.flats
+	}
+	texture := images[mesh.texture]
+
+	minX := int(math.Max(0, math.Floor(float64(min3(x[0], x[1], x[2])))))
+	maxX := int(math.Min(float64(bounds.Dx()-1), math.Ceil(float64(max3(x[0], x[1], x[2])))))
+	minY := int(math.Max(0, math.Floor(float64(min3(y[0], y[1], y[2])))))
+	maxY := int(math.Min(float64(bounds.Dy()-1), math.Ceil(float64(max3(y[0], y[1], y[2])))))
+	for py := minY; py <= maxY; py++ {
+		for px := minX; px <= maxX; px++ {
+			sx, sy := float32(px)+0.5, float32(py)+0.5
+			b0 := ((x[1]-sx)*(y[2]-sy) - (x[2]-sx)*(y[1]-sy)) / area
+			b1 := ((x[2]-sx)*(y[0]-sy) - (x[0]-sx)*(y[2]-sy)) / area
+			b2 := 1 - b0 - b1
+			if b0 < 0 || b1 < 0 || b2 < 0 {
+				continue
+			}
+			w0, w1, w2 := b0*invW[0], b1*invW[1], b2*invW[2]
+			depth := 1 / (w0 + w1 + w2)
+			if mesh.flat {
+				depth *= flatDepthBias
+			}
+			idx := py*bounds.Dx() + px
+			if depth >= target.depth[idx] {
+				continue
+			}
+			interpolate := func(a, b, c float32) float32 {
+				return (a*w0 + b*w1 + c*w2) * depth
+			}
+			red, green, blue, opaque := r.sample(scene, texture, interpolate(a.u, b.u, c.u), interpolate(a.v, b.v, c.v))
+			if !opaque {
+				continue
+			}
+			light := float32(1)
+			if !mesh.fullbright {
+				light = clamp32(mesh.lightLevel+interpolate(a.light, b.light, c.light), 0, 1)
+			}
+			fog := float32(math.Exp(float64(-r.settings.FogDensity * interpolate(a.distance, b.distance, c.distance))))
+			color := mgl32.Vec3{red * light, green * light, blue * light}
+			color = r.settings.FogColor.Add(color.Sub(r.settings.FogColor).Mul(fog))
+			pix := target.image.Pix[idx*4:]
+			for k := 0; k < 3; k++ {
+				value := color[k]
+				if r.settings.Gamma > 0 {
+					value = float32(math.Pow(float64(value), 1/float64(r.settings.Gamma)))
+				}
+				if mesh.alpha < 1 {
+					value = value*mesh.alpha + float32(pix[k])/255*(1-mesh.alpha)
+				}
+				pix[k] = uint8(clamp32(value, 0, 1)*255 + 0.5)
+			}
+			if mesh.alpha >= 1 {
+				target.depth[idx] = depth
+			}
+		}
+	}
+}
+
+// sample returns the color of the texel at texture coordinates that repeat
+// every unit, and whether the texel is opaque. Missing textures are black
+// like unbound textures on the GPU.
+func (r *SoftwareRenderer) sample(scene *Scene, texture *image.RGBA, u float32, v float32) (float32, float32, float32, bool) {
+	if texture == nil {
+		return 0, 0, 0, true
+	}
+	size := texture.Bounds().Size()
+	tx := int((u - float32(math.Floor(float64(u)))) * float32(size.X))
+	ty := int((v - float32(math.Floor(float64(v)))) * float32(size.Y))
+	if tx >= size.X {
+		tx = size.X - 1
+	}
+	if ty >= size.Y {
+		ty = size.Y - 1
+	}
+	texel := texture.Pix[texture.PixOffset(tx, ty):]
+	// Indexed textures hold the palette index in red and the alpha in
+	// green, like in the fragment shader.
+	if r.settings.Indexed {
+		if texel[1] != 255 {
+			return 0, 0, 0, false
+		}
+		if scene.playpal == nil {
+			gray := float32(texel[0]) / 255
+			return gray, gray, gray, true
+		}
+		rgb := scene.playpal.Palettes[r.settings.Palette].Table[texel[0]]
+		return float32(rgb.Red) / 255, float32(rgb.Green) / 255, float32(rgb.Blue) / 255, true
+	}
+	return float32(texel[0]) / 255, float32(texel[1]) / 255, float32(texel[2]) / 255, texel[3] == 255
+}
+
+func clamp32(v float32, low float32, high float32) float32 {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
+
+func min3(a float32, b float32, c float32) float32 {
+	return float32(math.Min(float64(a), math.Min(float64(b), float64(c))))
+}
+
+func max3(a float32, b float32, c float32) float32 {
+	return float32(math.Max(float64(a), math.Max(float64(b), float64(c))))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/internal/wadtest"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites the reference images in testdata instead of comparing
+// with them:
+//
+//	go test -run TestSoftwareRender . -update
+var update = flag.Bool("update", false, "rewrite the reference images in testdata")
+
+func TestSoftwareRender(t *testing.T) {
+	w := wadtest.MustReadIWAD(t, testWADLumps(0))
+	level, err := w.ReadLevel("MAP01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := &RenderSettings{
+		Width:     160,
+		Height:    100,
+		Gamma:     1.0,
+		Near:      defaultNear,
+		Far:       defaultFar,
+		LevelName: "MAP01",
+	}
+	scene, err := buildScene(w, level, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, angle, err := playerStart(level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := mgl32.Vec2{float32(start.X), float32(start.Y)}
+	transform := settings.transform()
+	eye := transform.mapToWorld(position, float32(eyeHeight(level, position, 0)))
+	direction := viewDirection(float32(transform.mapAngleToView(angle)))
+	rgba := NewSoftwareRenderer(settings).Render(scene, eye, direction, settings.Width, settings.Height)
+
+	path := filepath.Join("testdata", "map01.png")
+	if *update {
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := png.Encode(file, rgba); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if err := compareWithPNG(rgba, path, defaultCompareTolerance); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/go-gl/glfw/v3.1/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+	"math"
+)
+
+// spectatorMaxPitch keeps the spectator from looking straight up or down,
+// where the view's up vector would be parallel to the view direction.
+const (
+	spectatorMaxPitch = 89.0
+	spectatorRunSpeed = 4.0 // Speed multiplier when the run key is held.
+)
+
+// Spectator is a free camera that is decoupled from the player. It flies
+// in the direction it looks, ignoring floor heights and walls.
+type Spectator struct {
+	Eye   mgl32.Vec3 // Position in world coordinates.
+	Angle int16      // Yaw in degrees like the player's angle.
+	Pitch float32    // Pitch in degrees, positive is up.
+}
+
+// Direction returns the direction the spectator looks at.
+func (spectator *Spectator) Direction() mgl32.Vec3 {
+	y, x := math.Sincos(float64(spectator.Angle) * math.Pi / 180)
+	sin, cos := math.Sincos(float64(spectator.Pitch) * math.Pi / 180)
+	return mgl32.Vec3{float32(x * cos), float32(sin), float32(y * cos)}
+}
+
+// Update moves and turns the spectator by the keys held down. Up and down
+// arrows move along the view direction, left and right arrows turn, Page
+// Up and Page Down pitch, and Home and End move straight up and down.
+// Shift is the run key.
+func (spectator *Spectator) Update(window *glfw.Window, speed float32) {
+	if window.GetKey(glfw.KeyLeftShift) == glfw.Press || window.GetKey(glfw.KeyRightShift) == glfw.Press {
+		speed *= spectatorRunSpeed
+	}
+	direction := spectator.Direction()
+	if window.GetKey(glfw.KeyUp) == glfw.Press {
+		spectator.Eye = spectator.Eye.Add(direction.Mul(speed))
+	}
+	if window.GetKey(glfw.KeyDown) == glfw.Press {
+		spectator.Eye = spectator.Eye.Sub(direction.Mul(speed))
+	}
+	if window.GetKey(glfw.KeyHome) == glfw.Press {
+		spectator.Eye = spectator.Eye.Add(mgl32.Vec3{0, speed, 0})
+	}
+	if window.GetKey(glfw.KeyEnd) == glfw.Press {
+		spectator.Eye = spectator.Eye.Sub(mgl32.Vec3{0, speed, 0})
+	}
+	if window.GetKey(glfw.KeyLeft) == glfw.Press {
+		spectator.Angle -= 5
+	}
+	if window.GetKey(glfw.KeyRight) == glfw.Press {
+		spectator.Angle += 5
+	}
+	if window.GetKey(glfw.KeyPageUp) == glfw.Press {
+		spectator.Pitch = mgl32.Clamp(spectator.Pitch+2, -spectatorMaxPitch, spectatorMaxPitch)
+	}
+	if window.GetKey(glfw.KeyPageDown) == glfw.Press {
+		spectator.Pitch = mgl32.Clamp(spectator.Pitch-2, -spectatorMaxPitch, spectatorMaxPitch)
+	}
+}
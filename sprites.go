@@ -0,0 +1,586 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/wad"
+	"math"
+	"sort"
+)
+
+const (
+	spriteVertex = `#version 330
+
+layout(location = 0) in vec3 vertex;
+layout(location = 1) in vec2 vertTexCoord;
+
+uniform mat4 MVP;
+uniform vec3 Eye;
+
+out vec2 fragTexCoord;
+out float fragDistance;
+
+void main()
+{
+    fragTexCoord = vertTexCoord;
+    fragDistance = distance(vertex, Eye);
+    gl_Position = MVP * vec4(vertex, 1.0);
+}` + "\x00"
+
+	spriteFragment = `#version 330
+
+uniform float LightLevel;
+uniform bool Fuzz;
+uniform float FuzzScale;
+uniform float Tic;
+uniform float FogDensity;
+uniform vec3 FogColor;
+uniform float Gamma;
+uniform sampler2D tex;
+
+in vec2 fragTexCoord;
+in float fragDistance;
+
+out vec4 outColor;
+
+// noise returns a pseudo-random number from zero to one for a cell of the
+// screen that changes every tic.
+float noise(vec2 cell)
+{
+    return fract(sin(dot(vec3(cell, Tic), vec3(12.9898, 78.233, 37.719))) * 43758.5453);
+}
+
+void main()
+{
+    float fog = exp(-FogDensity * fragDistance);
+    if (Fuzz) {
+        // Doom draws partially invisible things by darkening what is behind
+        // them by rows shifted up or down by a pixel, so that only their
+        // shimmering outline shows. Here the outline is shifted and the
+        // darkening varies by pixel of a 320x200 screen instead.
+        float n = noise(floor(gl_FragCoord.xy / FuzzScale));
+        vec2 texSize = vec2(textureSize(tex, 0));
+        vec2 uv = fragTexCoord + vec2(0.0, (n - 0.5) * 2.0 / texSize.y);
+        if (texture(tex, uv).a < 0.5) {
+            discard;
+        }
+        outColor = vec4(0.0, 0.0, 0.0, (0.2 + 0.3 * n) * fog);
+        return;
+    }
+    vec4 texel = texture(tex, fragTexCoord);
+    if (texel.a < 0.5) {
+        discard;
+    }
+    vec3 rgb = mix(FogColor, texel.rgb * LightLevel, fog);
+    outColor = vec4(pow(rgb, vec3(1.0 / Gamma)), 1.0);
+}` + "\x00"
+)
+
+// spriteVertexFloats is the number of floats per sprite vertex.
+const spriteVertexFloats = 5
+
+// renderStyle is how the sprite of a thing is drawn.
+type renderStyle int
+
+const (
+	styleNormal renderStyle = iota // Opaque with transparent pixels masked out.
+	styleFuzz                      // Darkens what is behind it, like Doom's spectres.
+)
+
+// thingInfo describes how a type of thing looks. Things cycle through the
+// frames of their sprite, like the spawn states of Doom.
+type thingInfo struct {
+	name   string // Name of the type, as the things command prints it.
+	sprite string // Sprite name, the first four characters of its lumps.
+	frames string // Frame letters in the order that they are shown.
+	tics   int    // Tics that every frame is shown for.
+	style  renderStyle
+}
+
+// thingInfos are the names and looks of the thing types of DOOM and DOOM
+// II. Types without a sprite, such as player starts, are not drawn.
+var thingInfos = map[int16]thingInfo{
+	1:    {name: "Player 1 start"},
+	2:    {name: "Player 2 start"},
+	3:    {name: "Player 3 start"},
+	4:    {name: "Player 4 start"},
+	11:   {name: "Deathmatch start"},
+	14:   {name: "Teleport landing"},
+	3004: {"Former Human", "POSS", "AB", 10, styleNormal},
+	9:    {"Former Human Sergeant", "SPOS", "AB", 10, styleNormal},
+	65:   {"Chaingunner", "CPOS", "AB", 10, styleNormal},
+	3001: {"Imp", "TROO", "AB", 10, styleNormal},
+	3002: {"Demon", "SARG", "AB", 10, styleNormal},
+	58:   {"Spectre", "SARG", "AB", 10, styleFuzz},
+	3006: {"Lost Soul", "SKUL", "AB", 10, styleNormal},
+	3005: {"Cacodemon", "HEAD", "A", 10, styleNormal},
+	69:   {"Hell Knight", "BOS2", "AB", 10, styleNormal},
+	3003: {"Baron of Hell", "BOSS", "AB", 10, styleNormal},
+	68:   {"Arachnotron", "BSPI", "AB", 10, styleNormal},
+	71:   {"Pain Elemental", "PAIN", "A", 10, styleNormal},
+	66:   {"Revenant", "SKEL", "AB", 10, styleNormal},
+	67:   {"Mancubus", "FATT", "AB", 15, styleNormal},
+	64:   {"Arch-vile", "VILE", "AB", 10, styleNormal},
+	16:   {"Cyberdemon", "CYBR", "AB", 10, styleNormal},
+	7:    {"Spider Mastermind", "SPID", "AB", 10, styleNormal},
+	84:   {"Wolfenstein SS", "SSWV", "AB", 10, styleNormal},
+	72:   {"Commander Keen", "KEEN", "A", 0, styleNormal},
+	88:   {"Boss Brain", "BBRN", "A", 0, styleNormal},
+	89:   {name: "Boss Shooter"},
+	87:   {name: "Spawn spot"},
+	2005: {"Chainsaw", "CSAW", "A", 0, styleNormal},
+	2001: {"Shotgun", "SHOT", "A", 0, styleNormal},
+	82:   {"Super Shotgun", "SGN2", "A", 0, styleNormal},
+	2002: {"Chaingun", "MGUN", "A", 0, styleNormal},
+	2003: {"Rocket launcher", "LAUN", "A", 0, styleNormal},
+	2004: {"Plasma gun", "PLAS", "A", 0, styleNormal},
+	2006: {"BFG9000", "BFUG", "A", 0, styleNormal},
+	2007: {"Clip", "CLIP", "A", 0, styleNormal},
+	2048: {"Box of bullets", "AMMO", "A", 0, styleNormal},
+	2008: {"Shells", "SHEL", "A", 0, styleNormal},
+	2049: {"Box of shells", "SBOX", "A", 0, styleNormal},
+	2010: {"Rocket", "ROCK", "A", 0, styleNormal},
+	2046: {"Box of rockets", "BROK", "A", 0, styleNormal},
+	2047: {"Energy cell", "CELL", "A", 0, styleNormal},
+	17:   {"Energy cell pack", "CELP", "A", 0, styleNormal},
+	8:    {"Backpack", "BPAK", "A", 0, styleNormal},
+	2011: {"Stimpack", "STIM", "A", 0, styleNormal},
+	2012: {"Medikit", "MEDI", "A", 0, styleNormal},
+	2014: {"Health bonus", "BON1", "ABCDCB", 6, styleNormal},
+	2015: {"Armor bonus", "BON2", "ABCDCB", 6, styleNormal},
+	2018: {"Green armor", "ARM1", "AB", 6, styleNormal},
+	2019: {"Blue armor", "ARM2", "AB", 6, styleNormal},
+	2013: {"Soul sphere", "SOUL", "ABCDCB", 6, styleNormal},
+	83:   {"Megasphere", "MEGA", "ABCD", 6, styleNormal},
+	2022: {"Invulnerability", "PINV", "ABCD", 6, styleNormal},
+	2023: {"Berserk", "PSTR", "A", 0, styleNormal},
+	2024: {"Partial invisibility", "PINS", "ABCD", 6, styleNormal},
+	2025: {"Radiation suit", "SUIT", "A", 0, styleNormal},
+	2026: {"Computer area map", "PMAP", "ABCDCB", 6, styleNormal},
+	2045: {"Light amplification visor", "PVIS", "AB", 6, styleNormal},
+	5:    {"Blue keycard", "BKEY", "AB", 10, styleNormal},
+	6:    {"Yellow keycard", "YKEY", "AB", 10, styleNormal},
+	13:   {"Red keycard", "RKEY", "AB", 10, styleNormal},
+	40:   {"Blue skull key", "BSKU", "AB", 10, styleNormal},
+	39:   {"Yellow skull key", "YSKU", "AB", 10, styleNormal},
+	38:   {"Red skull key", "RSKU", "AB", 10, styleNormal},
+	2035: {"Barrel", "BAR1", "AB", 6, styleNormal},
+	70:   {"Burning barrel", "FCAN", "ABC", 4, styleNormal},
+	48:   {"Tall techno column", "ELEC", "A", 0, styleNormal},
+	30:   {"Tall green pillar", "COL1", "A", 0, styleNormal},
+	32:   {"Tall red pillar", "COL3", "A", 0, styleNormal},
+	31:   {"Short green pillar", "COL2", "A", 0, styleNormal},
+	36:   {"Short green pillar with heart", "COL5", "AB", 14, styleNormal},
+	33:   {"Short red pillar", "COL4", "A", 0, styleNormal},
+	37:   {"Short red pillar with skull", "COL6", "A", 0, styleNormal},
+	41:   {"Evil eye", "CEYE", "ABCB", 6, styleNormal},
+	42:   {"Floating skull", "FSKU", "ABC", 6, styleNormal},
+	43:   {"Burnt tree", "TRE1", "A", 0, styleNormal},
+	47:   {"Brown stump", "SMIT", "A", 0, styleNormal},
+	54:   {"Large brown tree", "TRE2", "A", 0, styleNormal},
+	2028: {"Floor lamp", "COLU", "A", 0, styleNormal},
+	85:   {"Tall techno floor lamp", "TLMP", "ABCD", 4, styleNormal},
+	86:   {"Short techno floor lamp", "TLP2", "ABCD", 4, styleNormal},
+	34:   {"Candle", "CAND", "A", 0, styleNormal},
+	35:   {"Candelabra", "CBRA", "A", 0, styleNormal},
+	44:   {"Tall blue firestick", "TBLU", "ABCD", 4, styleNormal},
+	45:   {"Tall green firestick", "TGRN", "ABCD", 4, styleNormal},
+	46:   {"Tall red firestick", "TRED", "ABCD", 4, styleNormal},
+	55:   {"Short blue firestick", "SMBT", "ABCD", 4, styleNormal},
+	56:   {"Short green firestick", "SMGT", "ABCD", 4, styleNormal},
+	57:   {"Short red firestick", "SMRT", "ABCD", 4, styleNormal},
+	25:   {"Impaled human", "POL1", "A", 0, styleNormal},
+	26:   {"Twitching impaled human", "POL6", "AB", 6, styleNormal},
+	27:   {"Skull on a pole", "POL4", "A", 0, styleNormal},
+	28:   {"Five skulls shish kebab", "POL2", "A", 0, styleNormal},
+	29:   {"Pile of skulls and candles", "POL3", "AB", 6, styleNormal},
+	49:   {"Hanging victim, twitching", "GOR1", "ABCB", 10, styleNormal},
+	50:   {"Hanging victim, arms out", "GOR2", "A", 0, styleNormal},
+	51:   {"Hanging victim, one-legged", "GOR3", "A", 0, styleNormal},
+	52:   {"Hanging pair of legs", "GOR4", "A", 0, styleNormal},
+	53:   {"Hanging leg", "GOR5", "A", 0, styleNormal},
+	59:   {"Hanging victim, arms out (non-blocking)", "GOR2", "A", 0, styleNormal},
+	60:   {"Hanging pair of legs (non-blocking)", "GOR4", "A", 0, styleNormal},
+	61:   {"Hanging victim, one-legged (non-blocking)", "GOR3", "A", 0, styleNormal},
+	62:   {"Hanging leg (non-blocking)", "GOR5", "A", 0, styleNormal},
+	63:   {"Hanging victim, twitching (non-blocking)", "GOR1", "ABCB", 10, styleNormal},
+	73:   {"Hanging victim, guts removed", "HDB1", "A", 0, styleNormal},
+	74:   {"Hanging victim, guts and brain removed", "HDB2", "A", 0, styleNormal},
+	75:   {"Hanging torso, looking down", "HDB3", "A", 0, styleNormal},
+	76:   {"Hanging torso, open skull", "HDB4", "A", 0, styleNormal},
+	77:   {"Hanging torso, looking up", "HDB5", "A", 0, styleNormal},
+	78:   {"Hanging torso, brain removed", "HDB6", "A", 0, styleNormal},
+	10:   {"Bloody mess", "PLAY", "W", 0, styleNormal},
+	12:   {"Bloody mess 2", "PLAY", "W", 0, styleNormal},
+	15:   {"Dead player", "PLAY", "N", 0, styleNormal},
+	18:   {"Dead former human", "POSS", "L", 0, styleNormal},
+	19:   {"Dead former sergeant", "SPOS", "L", 0, styleNormal},
+	20:   {"Dead imp", "TROO", "M", 0, styleNormal},
+	21:   {"Dead demon", "SARG", "N", 0, styleNormal},
+	22:   {"Dead cacodemon", "HEAD", "L", 0, styleNormal},
+	23:   {name: "Dead lost soul"},
+	24:   {"Pool of blood and flesh", "POL5", "A", 0, styleNormal},
+	79:   {"Pool of blood", "POB1", "A", 0, styleNormal},
+	80:   {"Pool of blood 2", "POB2", "A", 0, styleNormal},
+	81:   {"Pool of brains", "BRS1", "A", 0, styleNormal},
+}
+
+// thingName returns the name of a thing type, or "Unknown" if the type is
+// not a DOOM or DOOM II thing.
+func thingName(thingType int16) string {
+	if info, ok := thingInfos[thingType]; ok {
+		return info.name
+	}
+	return "Unknown"
+}
+
+// spriteTexture is a sprite lump uploaded as a GL texture.
+type spriteTexture struct {
+	texture    uint32
+	width      float32
+	height     float32
+	leftOffset float32 // Columns left of the thing's center.
+	topOffset  float32 // Rows above the thing's feet.
+}
+
+// spriteFrame is a frame of a sprite with the textures of its eight
+// rotations, indexed like the lumps of wad.SpriteFrame.
+type spriteFrame struct {
+	textures [8]*spriteTexture
+	flipped  [8]bool
+}
+
+// sprite is a thing of the level drawn as a sprite.
+type sprite struct {
+	position   mgl32.Vec3 // Bottom center in world coordinates.
+	angle      int16      // Map angle that the thing faces.
+	frames     []*spriteFrame
+	tics       int // Tics that every frame is shown for.
+	phase      int // Tics that the animation is ahead of the game clock.
+	lightLevel float32
+	style      renderStyle
+	texture    *spriteTexture // Texture of the rotation last drawn.
+	distance   float32        // Distance from the eye when last drawn.
+}
+
+// Sprites draws the things of a level as sprites that turn around the
+// vertical axis to face the viewer.
+type Sprites struct {
+	program      uint32
+	matrixID     int32
+	eyeID        int32
+	lightLevelID int32
+	fuzzID       int32
+	fuzzScaleID  int32
+	ticID        int32
+	fogDensityID int32
+	fogColorID   int32
+	gammaID      int32
+	vao          uint32
+	vbo          uint32
+	sprites      []sprite
+	frames       map[string]map[byte]*wad.SpriteFrame // Sprite lumps by sprite name and frame.
+	textures     map[string]*spriteTexture            // Textures by lump name, nil if unreadable.
+	order        []*sprite                            // Sprites from the farthest to the nearest.
+	vertices     []float32
+}
+
+// NewSprites loads the sprites of the things that a game with the given
+// settings spawns on a level. Things whose sprites are missing from the WAD
+// archive are left out.
+func NewSprites(w *wad.WAD, level *wad.Level, settings *RenderSettings, gameSettings *GameSettings) (*Sprites, error) {
+	frames, err := w.SpriteFrames()
+	if err != nil {
+		return nil, err
+	}
+	program, err := newProgram(spriteVertex, spriteFragment)
+	if err != nil {
+		return nil, err
+	}
+	sprites := &Sprites{
+		program:      program,
+		matrixID:     gl.GetUniformLocation(program, gl.Str("MVP\x00")),
+		eyeID:        gl.GetUniformLocation(program, gl.Str("Eye\x00")),
+		lightLevelID: gl.GetUniformLocation(program, gl.Str("LightLevel\x00")),
+		fuzzID:       gl.GetUniformLocation(program, gl.Str("Fuzz\x00")),
+		fuzzScaleID:  gl.GetUniformLocation(program, gl.Str("FuzzScale\x00")),
+		ticID:        gl.GetUniformLocation(program, gl.Str("Tic\x00")),
+		fogDensityID: gl.GetUniformLocation(program, gl.Str("FogDensity\x00")),
+		fogColorID:   gl.GetUniformLocation(program, gl.Str("FogColor\x00")),
+		gammaID:      gl.GetUniformLocation(program, gl.Str("Gamma\x00")),
+		frames:       frames,
+		textures:     map[string]*spriteTexture{},
+	}
+	for i := range level.Things {
+		thing := &level.Things[i]
+		info, ok := thingInfos[thing.Type]
+		if !ok || info.sprite == "" || !gameSettings.SpawnsThing(thing) {
+			continue
+		}
+		frames := []*spriteFrame{}
+		for _, frameLetter := range []byte(info.frames) {
+			if frame := sprites.loadFrame(w, info.sprite, frameLetter, settings.Palette); frame != nil {
+				frames = append(frames, frame)
+			}
+		}
+		if len(frames) == 0 {
+			continue
+		}
+		lightLevel := float32(1.0)
+		if sector, _ := level.SectorAt(thing.XPosition, thing.YPosition); sector != nil {
+			lightLevel = float32(sector.Lightlevel) / 255.0
+		}
+		sprites.sprites = append(sprites.sprites, sprite{
+			position:   settings.transform().doomToWorld(thing.XPosition, thing.YPosition, level.ThingZ(thing)),
+			angle:      thing.Angle,
+			frames:     frames,
+			tics:       info.tics,
+			phase:      spritePhase(i, len(frames)*info.tics),
+			lightLevel: lightLevel,
+			style:      info.style,
+		})
+	}
+
+	gl.GenVertexArrays(1, &sprites.vao)
+	gl.BindVertexArray(sprites.vao)
+	gl.GenBuffers(1, &sprites.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, sprites.vbo)
+
+	vertexAttrib := uint32(0)
+	gl.VertexAttribPointer(vertexAttrib, 3, gl.FLOAT, false, spriteVertexFloats*4, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(vertexAttrib)
+
+	texCoordAttrib := uint32(1)
+	gl.VertexAttribPointer(texCoordAttrib, 2, gl.FLOAT, false, spriteVertexFloats*4, gl.PtrOffset(3*4))
+	gl.EnableVertexAttribArray(texCoordAttrib)
+
+	return sprites, nil
+}
+
+// loadFrame uploads the rotations of a sprite frame. Rotations that the
+// WAD archive lacks are drawn with the first rotation that it has. It
+// returns nil if the frame has no readable rotation at all.
+func (sprites *Sprites) loadFrame(w *wad.WAD, name string, frameLetter byte, palette int) *spriteFrame {
+	lumps, ok := sprites.frames[name][frameLetter]
+	if !ok {
+		verbose.Printf("Sprite %s%c not found\n", name, frameLetter)
+		return nil
+	}
+	frame := &spriteFrame{}
+	fallback := -1
+	for rotation, lumpName := range lumps.Lumps {
+		if lumpName == "" {
+			continue
+		}
+		frame.textures[rotation] = sprites.loadTexture(w, lumpName, palette)
+		frame.flipped[rotation] = lumps.Flipped[rotation]
+		if fallback < 0 && frame.textures[rotation] != nil {
+			fallback = rotation
+		}
+	}
+	if fallback < 0 {
+		return nil
+	}
+	for rotation := range frame.textures {
+		if frame.textures[rotation] == nil {
+			frame.textures[rotation] = frame.textures[fallback]
+			frame.flipped[rotation] = frame.flipped[fallback]
+		}
+	}
+	return frame
+}
+
+// loadTexture uploads a sprite lump unless it is already uploaded. It
+// returns nil if the lump cannot be read.
+func (sprites *Sprites) loadTexture(w *wad.WAD, lumpName string, palette int) *spriteTexture {
+	if texture, ok := sprites.textures[lumpName]; ok {
+		return texture
+	}
+	picture, err := w.LoadPicture(lumpName)
+	if err != nil {
+		fmt.Printf("warning: %s\n", err)
+		sprites.textures[lumpName] = nil
+		return nil
+	}
+	texture := &spriteTexture{
+		texture:    UploadPicture(pictureToRGBA(w, picture, palette)),
+		width:      float32(picture.Width),
+		height:     float32(picture.Height),
+		leftOffset: float32(picture.LeftOffset),
+		topOffset:  float32(picture.TopOffset),
+	}
+	sprites.textures[lumpName] = texture
+	return texture
+}
+
+// spritePhase returns how many tics ahead of the game clock the animation
+// of the ith thing of a level is. Like Doom does by randomizing the first
+// tic of every thing, it keeps things of the same type from animating in
+// lockstep.
+func spritePhase(i int, period int) int {
+	if period <= 0 {
+		return 0
+	}
+	return i * 7919 % period
+}
+
+// frameAt returns the frame of a sprite that is shown at a game tic.
+func (s *sprite) frameAt(tic int) *spriteFrame {
+	if s.tics <= 0 {
+		return s.frames[0]
+	}
+	return s.frames[(tic+s.phase)/s.tics%len(s.frames)]
+}
+
+// spriteRotation returns the index of the rotation of a thing facing a map
+// angle in degrees that is seen along a direction in map coordinates. Like
+// in Doom, every rotation covers the 45 degrees around its direction.
+func spriteRotation(angle int16, direction mgl32.Vec2) int {
+	viewAngle := math.Atan2(float64(direction.Y()), float64(direction.X())) * 180 / math.Pi
+	// A thing seen along the direction that it faces shows its back, which
+	// is rotation 5.
+	rotation := int(math.Floor((viewAngle-float64(angle)+180+22.5)/45)) % 8
+	if rotation < 0 {
+		rotation += 8
+	}
+	return rotation
+}
+
+// Delete releases the shader program, the vertex buffer, and the textures
+// of the sprites.
+func (sprites *Sprites) Delete() {
+	gl.DeleteProgram(sprites.program)
+	gl.DeleteVertexArrays(1, &sprites.vao)
+	gl.DeleteBuffers(1, &sprites.vbo)
+	for _, texture := range sprites.textures {
+		if texture != nil {
+			gl.DeleteTextures(1, &texture.texture)
+		}
+	}
+}
+
+// Render draws the sprites sorted by their distance from the eye. tic is
+// the game tic that animates the frames and the fuzz.
+func (sprites *Sprites) Render(settings *RenderSettings, eye mgl32.Vec3, direction mgl32.Vec3, width int, height int, tic int) {
+	if len(sprites.sprites) == 0 {
+		return
+	}
+	mvp := viewProjection(settings, eye, direction, width, height)
+	transform := settings.transform()
+	right := direction.Cross(mgl32.Vec3{0, 1, 0}).Normalize()
+
+	// Sprites are blended and do not write depth, so they are drawn from
+	// the farthest to the nearest for nearer sprites to cover farther ones.
+	order := sprites.order[:0]
+	for i := range sprites.sprites {
+		s := &sprites.sprites[i]
+		s.distance = s.position.Sub(eye).Len()
+		order = append(order, s)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return order[i].distance > order[j].distance
+	})
+	sprites.order = order
+
+	vertices := sprites.vertices[:0]
+	for _, s := range order {
+		mapDirection, _ := transform.worldToMap(s.position.Sub(eye))
+		rotation := spriteRotation(s.angle, mapDirection)
+		frame := s.frameAt(tic)
+		s.texture = frame.textures[rotation]
+		// A mirrored view shows mirror images of the sprites as well.
+		flipped := frame.flipped[rotation] != transform.mirrored()
+		u0, u1 := float32(0), float32(1)
+		if flipped {
+			u0, u1 = u1, u0
+		}
+		// The center of a thing is leftOffset columns right of the left
+		// edge of its sprite, or as many left of the right edge if the
+		// sprite is flipped.
+		scale := settings.ThingScale
+		leftOffset := s.texture.leftOffset
+		if flipped {
+			leftOffset = s.texture.width - leftOffset
+		}
+		left := s.position.Sub(right.Mul(leftOffset * scale))
+		rightEdge := left.Add(right.Mul(s.texture.width * scale))
+		// The feet of a thing are topOffset rows below the top edge of its
+		// sprite. Most sprites reach a few rows further down, which Doom
+		// draws over the floor but the depth test would cut off, so they
+		// are raised to stand on the floor instead.
+		bottomOffset := s.texture.topOffset - s.texture.height
+		if bottomOffset < 0 {
+			bottomOffset = 0
+		}
+		bottom := mgl32.Vec3{0, bottomOffset * scale, 0}
+		top := mgl32.Vec3{0, (bottomOffset + s.texture.height) * scale, 0}
+		for _, corner := range []struct {
+			position mgl32.Vec3
+			u, v     float32
+		}{
+			{left.Add(bottom), u0, 1},
+			{rightEdge.Add(bottom), u1, 1},
+			{rightEdge.Add(top), u1, 0},
+			{rightEdge.Add(top), u1, 0},
+			{left.Add(top), u0, 0},
+			{left.Add(bottom), u0, 1},
+		} {
+			vertices = append(vertices, corner.position.X(), corner.position.Y(), corner.position.Z(), corner.u, corner.v)
+		}
+	}
+	sprites.vertices = vertices
+
+	gl.UseProgram(sprites.program)
+	gl.UniformMatrix4fv(sprites.matrixID, 1, false, &mvp[0])
+	gl.Uniform3f(sprites.eyeID, eye.X(), eye.Y(), eye.Z())
+	gl.Uniform1f(sprites.fuzzScaleID, float32(height)/screenHeight)
+	gl.Uniform1f(sprites.ticID, float32(tic))
+	gl.Uniform1f(sprites.fogDensityID, settings.FogDensity)
+	gl.Uniform3f(sprites.fogColorID, settings.FogColor.X(), settings.FogColor.Y(), settings.FogColor.Z())
+	gl.Uniform1f(sprites.gammaID, settings.Gamma)
+	gl.ActiveTexture(gl.TEXTURE0)
+
+	gl.BindVertexArray(sprites.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, sprites.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*4, gl.Ptr(vertices), gl.STREAM_DRAW)
+
+	gl.Disable(gl.CULL_FACE)
+	gl.Disable(gl.POLYGON_OFFSET_FILL)
+	gl.PolygonMode(gl.FRONT_AND_BACK, gl.FILL)
+
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.DepthMask(false)
+	fuzz := false
+	gl.Uniform1i(sprites.fuzzID, 0)
+	for i, s := range order {
+		if (s.style == styleFuzz) != fuzz {
+			fuzz = !fuzz
+			if fuzz {
+				gl.Uniform1i(sprites.fuzzID, 1)
+			} else {
+				gl.Uniform1i(sprites.fuzzID, 0)
+			}
+		}
+		gl.Uniform1f(sprites.lightLevelID, s.lightLevel)
+		gl.BindTexture(gl.TEXTURE_2D, s.texture.texture)
+		gl.DrawArrays(gl.TRIANGLES, int32(i*6), 6)
+	}
+	gl.DepthMask(true)
+	gl.Disable(gl.BLEND)
+
+	// The opaque pixels of sprites that are not fuzzy are drawn once more
+	// into the depth buffer only, so that translucent walls that are drawn
+	// afterwards do not cover the sprites in front of them.
+	gl.ColorMask(false, false, false, false)
+	gl.Uniform1i(sprites.fuzzID, 0)
+	for i, s := range order {
+		if s.style == styleFuzz {
+			continue
+		}
+		gl.BindTexture(gl.TEXTURE_2D, s.texture.texture)
+		gl.DrawArrays(gl.TRIANGLES, int32(i*6), 6)
+	}
+	gl.ColorMask(true, true, true, true)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/penberg/godoom/wad"
+	"os"
+	"sort"
+)
+
+var statsCommand = cli.Command{
+	Name:  "stats",
+	Usage: "Print level statistics without starting the game",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "WAD archive",
+			Value: "doom1.wad",
+		},
+		cli.IntFlag{
+			Name:  "level,l",
+			Usage: "Level number",
+			Value: 1,
+		},
+		cli.IntFlag{
+			Name:  "episode",
+			Usage: "Episode number of an ExMy level, used with --map",
+		},
+		cli.IntFlag{
+			Name:  "map",
+			Usage: "Map number of an ExMy level, used with --episode",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print statistics as JSON",
+		},
+	},
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		levelName, err := selectLevelFlags(w, c)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		level, err := w.ReadLevel(levelName)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		stats := levelStats(w, levelName, level)
+		if c.Bool("json") {
+			data, err := json.MarshalIndent(stats, "", "  ")
+			if err != nil {
+				fmt.Printf("error: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		printLevelStats(stats)
+	},
+}
+
+// LevelStats summarizes the contents of a level.
+type LevelStats struct {
+	Name            string   `json:"name"`
+	Vertexes        int      `json:"vertexes"`
+	Linedefs        int      `json:"linedefs"`
+	Sidedefs        int      `json:"sidedefs"`
+	Segs            int      `json:"segs"`
+	SSectors        int      `json:"subsectors"`
+	Nodes           int      `json:"nodes"`
+	Sectors         int      `json:"sectors"`
+	Things          int      `json:"things"`
+	Textures        []string `json:"textures"`
+	Flats           []string `json:"flats"`
+	MissingTextures []string `json:"missing_textures"`
+	MissingFlats    []string `json:"missing_flats"`
+	BBox            wad.BBox `json:"bbox"`
+}
+
+func levelStats(w *wad.WAD, name string, level *wad.Level) LevelStats {
+	stats := LevelStats{
+		Name:     name,
+		Vertexes: len(level.Vertexes),
+		Linedefs: len(level.Linedefs),
+		Sidedefs: len(level.Sidedefs),
+		Segs:     len(level.Segs),
+		SSectors: len(level.SSectors),
+		Nodes:    len(level.Nodes),
+		Sectors:  len(level.Sectors),
+		Things:   len(level.Things),
+	}
+
+	textures := map[string]bool{}
+	for _, sidedef := range level.Sidedefs {
+		for _, texture := range []wad.String8{sidedef.UpperTexture, sidedef.MiddleTexture, sidedef.LowerTexture} {
+			name := wad.ToString(texture)
+			if name != "-" && name != "" {
+				textures[name] = true
+			}
+		}
+	}
+	for name := range textures {
+		stats.Textures = append(stats.Textures, name)
+		if texture, _ := w.LoadTexture(name); texture.Header == nil {
+			stats.MissingTextures = append(stats.MissingTextures, name)
+		}
+	}
+
+	flats := map[string]bool{}
+	for _, sector := range level.Sectors {
+		flats[wad.ToString(sector.Floorpic)] = true
+		flats[wad.ToString(sector.Ceilingpic)] = true
+	}
+	for name := range flats {
+		stats.Flats = append(stats.Flats, name)
+		if flat, _ := w.LoadFlat(name); len(flat.Data) == 0 {
+			stats.MissingFlats = append(stats.MissingFlats, name)
+		}
+	}
+
+	sort.Strings(stats.Textures)
+	sort.Strings(stats.Flats)
+	sort.Strings(stats.MissingTextures)
+	sort.Strings(stats.MissingFlats)
+
+	stats.BBox = level.Bounds()
+	return stats
+}
+
+func printLevelStats(stats LevelStats) {
+	fmt.Printf("Level %s\n", stats.Name)
+	fmt.Printf("  %-16s %8d\n", "Vertexes", stats.Vertexes)
+	fmt.Printf("  %-16s %8d\n", "Linedefs", stats.Linedefs)
+	fmt.Printf("  %-16s %8d\n", "Sidedefs", stats.Sidedefs)
+	fmt.Printf("  %-16s %8d\n", "Segs", stats.Segs)
+	fmt.Printf("  %-16s %8d\n", "Subsectors", stats.SSectors)
+	fmt.Printf("  %-16s %8d\n", "Nodes", stats.Nodes)
+	fmt.Printf("  %-16s %8d\n", "Sectors", stats.Sectors)
+	fmt.Printf("  %-16s %8d\n", "Things", stats.Things)
+	fmt.Printf("  %-16s %8d\n", "Textures", len(stats.Textures))
+	fmt.Printf("  %-16s %8d\n", "Flats", len(stats.Flats))
+	fmt.Printf("  %-16s (%d, %d) - (%d, %d)\n", "Bounding box", stats.BBox.Left, stats.BBox.Bottom, stats.BBox.Right, stats.BBox.Top)
+	for _, name := range stats.MissingTextures {
+		fmt.Printf("warning: Missing texture %s\n", name)
+	}
+	for _, name := range stats.MissingFlats {
+		fmt.Printf("warning: Missing flat %s\n", name)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/penberg/godoom/wad"
+	"image"
+	"image/color"
+)
+
+// Positions of the status bar and its widgets on the Doom screen. Numbers
+// are right-aligned to their x coordinate, which is also where the percent
+// sign goes.
+const (
+	statusBarY = 168
+	healthX    = 90
+	healthY    = 171
+	armorX     = 221
+	armorY     = 171
+)
+
+// deathTint is blended over the view when the player is dead.
+var deathTint = color.RGBA{255, 0, 0, 96}
+
+// StatusBar draws the status bar at the bottom of the Doom screen with the
+// player's health and armor in the big red STTNUM font.
+type StatusBar struct {
+	background *glyph // STBAR, nil if the WAD has no status bar.
+	digits     [10]glyph
+	percent    glyph
+	tint       uint32
+}
+
+// NewStatusBar uploads the status bar graphics of a WAD archive.
+func NewStatusBar(w *wad.WAD) (*StatusBar, error) {
+	load := func(name string) (glyph, error) {
+		picture, err := w.LoadPicture(name)
+		if err != nil {
+			return glyph{}, err
+		}
+		return newGlyph(w, picture), nil
+	}
+	statusBar := &StatusBar{}
+	if w.HasLump("STBAR") {
+		background, err := load("STBAR")
+		if err != nil {
+			return nil, err
+		}
+		statusBar.background = &background
+	}
+	for i := range statusBar.digits {
+		digit, err := load(fmt.Sprintf("STTNUM%d", i))
+		if err != nil {
+			return nil, err
+		}
+		statusBar.digits[i] = digit
+	}
+	percent, err := load("STTPRCNT")
+	if err != nil {
+		return nil, err
+	}
+	statusBar.percent = percent
+	tint := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	tint.Set(0, 0, deathTint)
+	statusBar.tint = UploadPicture(tint)
+	return statusBar, nil
+}
+
+// Delete releases the textures of the status bar.
+func (statusBar *StatusBar) Delete() {
+	if statusBar.background != nil {
+		statusBar.background.delete()
+	}
+	for i := range statusBar.digits {
+		statusBar.digits[i].delete()
+	}
+	statusBar.percent.delete()
+	gl.DeleteTextures(1, &statusBar.tint)
+}
+
+// Draw draws the status bar for a player. The whole screen is tinted red
+// if the player is dead.
+func (statusBar *StatusBar) Draw(renderer *PictureRenderer, player *Player, fbWidth int, fbHeight int) {
+	if player.Dead() {
+		gl.Enable(gl.BLEND)
+		gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+		renderer.Draw(statusBar.tint, 0, 0, screenWidth, screenHeight, fbWidth, fbHeight)
+		gl.Disable(gl.BLEND)
+	}
+	if background := statusBar.background; background != nil {
+		background.draw(renderer, 0, statusBarY, fbWidth, fbHeight)
+	}
+	statusBar.drawPercent(renderer, player.Health, healthX, healthY, fbWidth, fbHeight)
+	statusBar.drawPercent(renderer, player.Armor, armorX, armorY, fbWidth, fbHeight)
+}
+
+// drawPercent draws a number right-aligned to x followed by a percent sign.
+func (statusBar *StatusBar) drawPercent(renderer *PictureRenderer, value int, x int, y int, fbWidth int, fbHeight int) {
+	statusBar.percent.draw(renderer, x, y, fbWidth, fbHeight)
+	if value < 0 {
+		value = 0
+	}
+	for {
+		digit := statusBar.digits[value%10]
+		x -= digit.width
+		digit.draw(renderer, x, y, fbWidth, fbHeight)
+		value /= 10
+		if value == 0 {
+			break
+		}
+	}
+}
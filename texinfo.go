@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/penberg/godoom/wad"
+	"os"
+	"strings"
+)
+
+var texinfoCommand = cli.Command{
+	Name:  "texinfo",
+	Usage: "Print the size and patches of a wall texture",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "WAD archive",
+			Value: "doom1.wad",
+		},
+		cli.StringFlag{
+			Name:  "name,n",
+			Usage: "Texture name",
+		},
+	},
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := printTextureInfo(w, strings.ToUpper(c.String("name"))); err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// printTextureInfo prints the size of a wall texture and the name, offset,
+// and source lump of each of its patches.
+func printTextureInfo(w *wad.WAD, name string) error {
+	texture, err := w.LoadTexture(name)
+	if err != nil {
+		return err
+	}
+	if texture.Header == nil {
+		return fmt.Errorf("Unknown texture '%s'!", name)
+	}
+	fmt.Printf("%s: %dx%d, %d patches\n", name, texture.Header.Width, texture.Header.Height, len(texture.Patches))
+	for i, patch := range texture.Patches {
+		source := "missing"
+		patchName, err := w.PatchName(patch.PNameNumber)
+		if err != nil {
+			patchName = fmt.Sprintf("#%d", patch.PNameNumber)
+			source = err.Error()
+		} else if lumpIdx, ok := w.LumpIndex(patchName); ok {
+			source = fmt.Sprintf("lump %d", lumpIdx)
+		}
+		fmt.Printf("  %d: %-8s at (%d, %d), %s\n", i, patchName, patch.XOffset, patch.YOffset, source)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/penberg/godoom/wad"
+	"os"
+	"sort"
+)
+
+var thingsCommand = cli.Command{
+	Name:  "things",
+	Usage: "Print how many things of each type a level has",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "WAD archive",
+			Value: "doom1.wad",
+		},
+		cli.IntFlag{
+			Name:  "level,l",
+			Usage: "Level number",
+			Value: 1,
+		},
+		cli.IntFlag{
+			Name:  "episode",
+			Usage: "Episode number of an ExMy level, used with --map",
+		},
+		cli.IntFlag{
+			Name:  "map",
+			Usage: "Map number of an ExMy level, used with --episode",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print thing counts as JSON",
+		},
+	},
+	Action: func(c *cli.Context) {
+		w, err := wad.ReadWAD(c.String("file"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		levelName, err := selectLevelFlags(w, c)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		level, err := w.ReadLevel(levelName)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			os.Exit(1)
+		}
+		things := levelThings(levelName, level)
+		if c.Bool("json") {
+			data, err := json.MarshalIndent(things, "", "  ")
+			if err != nil {
+				fmt.Printf("error: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		printLevelThings(things)
+	},
+}
+
+// ThingCount is the number of things of a type on a level.
+type ThingCount struct {
+	Type  int16  `json:"type"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// LevelThings summarizes the things of a level.
+type LevelThings struct {
+	Name             string       `json:"name"`
+	PlayerStarts     int          `json:"player_starts"`
+	DeathmatchStarts int          `json:"deathmatch_starts"`
+	Types            []ThingCount `json:"types"`
+}
+
+func levelThings(name string, level *wad.Level) LevelThings {
+	things := LevelThings{Name: name}
+	counts := map[int16]int{}
+	for _, thing := range level.Things {
+		counts[thing.Type]++
+		switch thing.Type {
+		case wad.ThingPlayer1Start, wad.ThingPlayer2Start, wad.ThingPlayer3Start, wad.ThingPlayer4Start:
+			things.PlayerStarts++
+		case wad.ThingDeathmatchStart:
+			things.DeathmatchStarts++
+		}
+	}
+	for thingType, count := range counts {
+		things.Types = append(things.Types, ThingCount{Type: thingType, Name: thingName(thingType), Count: count})
+	}
+	// Most common types first; the type number breaks ties so that the
+	// order is deterministic.
+	sort.Slice(things.Types, func(i, j int) bool {
+		if things.Types[i].Count != things.Types[j].Count {
+			return things.Types[i].Count > things.Types[j].Count
+		}
+		return things.Types[i].Type < things.Types[j].Type
+	})
+	return things
+}
+
+func printLevelThings(things LevelThings) {
+	fmt.Printf("Level %s\n", things.Name)
+	fmt.Printf("  %-18s %6d\n", "Player starts", things.PlayerStarts)
+	fmt.Printf("  %-18s %6d\n", "Deathmatch starts", things.DeathmatchStarts)
+	for _, count := range things.Types {
+		fmt.Printf("  %5d %-40s x%d\n", count.Type, count.Name, count.Count)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/penberg/godoom/internal/wadtest"
+	"github.com/penberg/godoom/wad"
+	"reflect"
+	"testing"
+)
+
+func TestLevelThings(t *testing.T) {
+	level := wadtest.Level()
+	for _, thingType := range []int16{2, 11, 11, 3001, 2011, 3001, 2011, 3004, 9999} {
+		level.Things = append(level.Things, wad.Thing{XPosition: 32, YPosition: 32, Type: thingType})
+	}
+	things := levelThings("MAP01", level)
+	want := LevelThings{
+		Name:             "MAP01",
+		PlayerStarts:     2,
+		DeathmatchStarts: 2,
+		// Most common types first and ties by type number.
+		Types: []ThingCount{
+			{Type: 11, Name: "Deathmatch start", Count: 2},
+			{Type: 2011, Name: "Stimpack", Count: 2},
+			{Type: 3001, Name: "Imp", Count: 2},
+			{Type: 1, Name: "Player 1 start", Count: 1},
+			{Type: 2, Name: "Player 2 start", Count: 1},
+			{Type: 3004, Name: "Former Human", Count: 1},
+			{Type: 9999, Name: "Unknown", Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(things, want) {
+		t.Errorf("got %+v, want %+v", things, want)
+	}
+
+	data, err := json.Marshal(levelThings("MAP01", wadtest.Level()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `{"name":"MAP01","player_starts":1,"deathmatch_starts":0,"types":[{"type":1,"name":"Player 1 start","count":1}]}`
+	if string(data) != wantJSON {
+		t.Errorf("JSON = %s, want %s", data, wantJSON)
+	}
+}
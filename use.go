@@ -0,0 +1,120 @@
+package main
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/penberg/godoom/wad"
+	"sort"
+)
+
+// useRange is how far the player reaches to use a line, as in Doom.
+const useRange = 64
+
+// lineHit is a linedef that the use line crosses.
+type lineHit struct {
+	linedef  int
+	distance float32
+}
+
+// useLine returns the linedef that the player uses when pressing the use
+// key, or -1 if there is none. Like P_UseLines in Doom, the use line stops
+// at the first linedef with a special or at the first linedef that the
+// player can't see through.
+func useLine(level *wad.Level, position mgl32.Vec2, direction mgl32.Vec2) int {
+	end := position.Add(direction.Normalize().Mul(useRange))
+	hits := []lineHit{}
+	for id, linedef := range level.Linedefs {
+		start := level.Vertexes[linedef.VertexStart]
+		stop := level.Vertexes[linedef.VertexEnd]
+		a := mgl32.Vec2{float32(start.XCoord), float32(start.YCoord)}
+		b := mgl32.Vec2{float32(stop.XCoord), float32(stop.YCoord)}
+		if t, ok := intersectSegments(position, end, a, b); ok {
+			hits = append(hits, lineHit{linedef: id, distance: t})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].distance < hits[j].distance
+	})
+	for _, hit := range hits {
+		linedef := level.Linedefs[hit.linedef]
+		if linedef.Function != 0 {
+			return hit.linedef
+		}
+		if linedef.SidedefLeft == -1 || !lineOpen(level, &linedef) {
+			return -1
+		}
+	}
+	return -1
+}
+
+// lineOpen returns true if there is a gap between the floors and ceilings
+// of the sectors on both sides of a two-sided linedef.
+func lineOpen(level *wad.Level, linedef *wad.Linedef) bool {
+	front := level.Sectors[level.Sidedefs[linedef.SidedefRight].SectorRef]
+	back := level.Sectors[level.Sidedefs[linedef.SidedefLeft].SectorRef]
+	ceiling := front.CeilingHeight
+	if back.CeilingHeight < ceiling {
+		ceiling = back.CeilingHeight
+	}
+	floor := front.FloorHeight
+	if back.FloorHeight > floor {
+		floor = back.FloorHeight
+	}
+	return ceiling > floor
+}
+
+// intersectSegments returns the position along the segment from p0 to p1,
+// between zero and one, where it crosses the segment from q0 to q1.
+func intersectSegments(p0 mgl32.Vec2, p1 mgl32.Vec2, q0 mgl32.Vec2, q1 mgl32.Vec2) (float32, bool) {
+	r := p1.Sub(p0)
+	s := q1.Sub(q0)
+	denominator := r.X()*s.Y() - r.Y()*s.X()
+	if denominator == 0 {
+		return 0, false
+	}
+	d := q0.Sub(p0)
+	t := (d.X()*s.Y() - d.Y()*s.X()) / denominator
+	u := (d.X()*r.Y() - d.Y()*r.X()) / denominator
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return t, true
+}
+
+// useSwitch toggles the switch textures on the front side of a linedef. It
+// returns false if the player is behind the linedef or the linedef has no
+// switch textures. Like in Doom, switches can only be used from the front.
+func useSwitch(level *wad.Level, scene *Scene, switches map[string]string, linedefId int, position mgl32.Vec2) bool {
+	linedef := level.Linedefs[linedefId]
+	start := level.Vertexes[linedef.VertexStart]
+	stop := level.Vertexes[linedef.VertexEnd]
+	a := mgl32.Vec2{float32(start.XCoord), float32(start.YCoord)}
+	edge := mgl32.Vec2{float32(stop.XCoord), float32(stop.YCoord)}.Sub(a)
+	offset := position.Sub(a)
+	if edge.X()*offset.Y()-edge.Y()*offset.X() > 0 {
+		return false
+	}
+	sidedef := &level.Sidedefs[linedef.SidedefRight]
+	toggled := false
+	for _, texture := range []*wad.String8{&sidedef.UpperTexture, &sidedef.MiddleTexture, &sidedef.LowerTexture} {
+		name := wad.ToString(*texture)
+		other, ok := switches[name]
+		if !ok {
+			continue
+		}
+		scene.SetWallTexture(int(linedef.SidedefRight), name, other)
+		*texture = wad.String8{}
+		copy(texture[:], other)
+		toggled = true
+	}
+	return toggled
+}
+
+// switchPairs maps both textures of every switch to the other texture.
+func switchPairs(switches []wad.Switch) map[string]string {
+	pairs := make(map[string]string)
+	for _, sw := range switches {
+		pairs[sw.Off] = sw.On
+		pairs[sw.On] = sw.Off
+	}
+	return pairs
+}
@@ -0,0 +1,146 @@
+package wad
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// BlockmapBlockSize is the width and height of a blockmap block in map
+// units.
+const BlockmapBlockSize = 128
+
+// blockmapEnd terminates the linedef list of a block.
+const blockmapEnd = 0xffff
+
+// Blockmap is a grid of square blocks over a level that lists the linedefs
+// crossing every block, for finding the lines in an area without looking
+// at every line of the level.
+type Blockmap struct {
+	OriginX int16 // Map position of the bottom left corner of the grid.
+	OriginY int16
+	Columns int
+	Rows    int
+	blocks  [][]int // Linedefs indexed by row * Columns + column.
+}
+
+// parseBlockmap parses a BLOCKMAP lump. The lump starts with the origin and
+// size of the grid, followed by an offset in 16-bit words to the linedef
+// list of every block. Every list starts with a zero and ends with 0xffff.
+func parseBlockmap(data []byte) (*Blockmap, error) {
+	word := func(idx int) (uint16, error) {
+		if idx < 0 || 2*idx+2 > len(data) {
+			return 0, fmt.Errorf("lump BLOCKMAP: got %d bytes, want %d", len(data), 2*idx+2)
+		}
+		return binary.LittleEndian.Uint16(data[2*idx:]), nil
+	}
+	header := [4]uint16{}
+	for i := range header {
+		value, err := word(i)
+		if err != nil {
+			return nil, err
+		}
+		header[i] = value
+	}
+	blockmap := &Blockmap{
+		OriginX: int16(header[0]),
+		OriginY: int16(header[1]),
+		Columns: int(header[2]),
+		Rows:    int(header[3]),
+	}
+	// Check the offset table against the lump before allocating the
+	// blocks, so that a corrupt grid size cannot exhaust memory.
+	count := blockmap.Columns * blockmap.Rows
+	if size := 2 * (4 + int64(count)); size > int64(len(data)) {
+		return nil, fmt.Errorf("lump BLOCKMAP: %dx%d blocks: got %d bytes, want %d", blockmap.Columns, blockmap.Rows, len(data), size)
+	}
+	blockmap.blocks = make([][]int, count)
+	// Node builders compress the blockmap by sharing the lists of blocks
+	// with the same linedefs, so every list is parsed once.
+	lists := map[uint16][]int{}
+	for block := range blockmap.blocks {
+		offset, err := word(4 + block)
+		if err != nil {
+			return nil, err
+		}
+		if lines, ok := lists[offset]; ok {
+			blockmap.blocks[block] = lines
+			continue
+		}
+		// Skip the leading zero of the list.
+		lines := []int{}
+		for idx := int(offset) + 1; ; idx++ {
+			linedef, err := word(idx)
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %s", block, err)
+			}
+			if linedef == blockmapEnd {
+				break
+			}
+			lines = append(lines, int(linedef))
+		}
+		lists[offset] = lines
+		blockmap.blocks[block] = lines
+	}
+	return blockmap, nil
+}
+
+// validate checks that every block only lists linedefs of a level with the
+// given number of linedefs.
+func (blockmap *Blockmap) validate(linedefs int) error {
+	for block, lines := range blockmap.blocks {
+		for _, linedef := range lines {
+			if linedef >= linedefs {
+				return fmt.Errorf("block %d: linedef %d out of range", block, linedef)
+			}
+		}
+	}
+	return nil
+}
+
+// LinesInRect returns the linedefs that cross the blocks that overlap a
+// rectangle in map coordinates. Every linedef is returned once, but some
+// may lie outside of the rectangle within the blocks.
+func (blockmap *Blockmap) LinesInRect(bbox BBox) []int {
+	if blockmap.Columns == 0 || blockmap.Rows == 0 {
+		return nil
+	}
+	column0 := blockmap.column(bbox.Left)
+	column1 := blockmap.column(bbox.Right)
+	row0 := blockmap.row(bbox.Bottom)
+	row1 := blockmap.row(bbox.Top)
+	seen := map[int]bool{}
+	lines := []int{}
+	for row := row0; row <= row1; row++ {
+		for column := column0; column <= column1; column++ {
+			for _, linedef := range blockmap.blocks[row*blockmap.Columns+column] {
+				if !seen[linedef] {
+					seen[linedef] = true
+					lines = append(lines, linedef)
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// column returns the column of the block that a map X coordinate is in,
+// clamped to the grid.
+func (blockmap *Blockmap) column(x int16) int {
+	return clampBlock((int(x)-int(blockmap.OriginX))/BlockmapBlockSize, blockmap.Columns)
+}
+
+// row returns the row of the block that a map Y coordinate is in, clamped
+// to the grid.
+func (blockmap *Blockmap) row(y int16) int {
+	return clampBlock((int(y)-int(blockmap.OriginY))/BlockmapBlockSize, blockmap.Rows)
+}
+
+func clampBlock(block int, count int) int {
+	if block < 0 {
+		return 0
+	}
+	if block >= count {
+		return count - 1
+	}
+	return block
+}
@@ -0,0 +1,117 @@
+package wad_test
+
+import (
+	"github.com/penberg/godoom/internal/wadtest"
+	"github.com/penberg/godoom/wad"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testBlockmap returns a BLOCKMAP lump with a grid of 3x2 blocks at
+// (-64, 0). The blocks share three linedef lists:
+//
+//	row 1: B B A
+//	row 0: A B C
+//
+// A lists linedefs 0 and 1, B none, and C linedefs 2 and 1.
+func testBlockmap() []byte {
+	return wadtest.EncodeLE([]uint16{
+		0xffc0, 0, 3, 2,
+		10, 14, 16, 14, 14, 10,
+		0, 0, 1, wad.BlockmapEnd,
+		0, wad.BlockmapEnd,
+		0, 2, 1, wad.BlockmapEnd,
+	})
+}
+
+func TestParseBlockmap(t *testing.T) {
+	blockmap, err := wad.ParseBlockmap(testBlockmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockmap.OriginX != -64 || blockmap.OriginY != 0 || blockmap.Columns != 3 || blockmap.Rows != 2 {
+		t.Errorf("got grid of %dx%d at (%d, %d), want 3x2 at (-64, 0)", blockmap.Columns, blockmap.Rows, blockmap.OriginX, blockmap.OriginY)
+	}
+	want := [][]int{{0, 1}, {}, {2, 1}, {}, {}, {0, 1}}
+	if !reflect.DeepEqual(blockmap.Blocks(), want) {
+		t.Errorf("blocks = %v, want %v", blockmap.Blocks(), want)
+	}
+	if &blockmap.Blocks()[0][0] != &blockmap.Blocks()[5][0] {
+		t.Errorf("blocks 0 and 5 share a list on disk but not in memory")
+	}
+}
+
+func TestParseBlockmapErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		want    string
+	}{
+		{"truncated header", func(data []byte) []byte {
+			return data[:6]
+		}, "lump BLOCKMAP: got 6 bytes, want 8"},
+		{"huge grid", func(data []byte) []byte {
+			return wadtest.EncodeLE([]uint16{0, 0, 0xffff, 0xffff})
+		}, "lump BLOCKMAP: 65535x65535 blocks: got 8 bytes, want 8589672458"},
+		{"truncated offsets", func(data []byte) []byte {
+			return data[:18]
+		}, "lump BLOCKMAP: 3x2 blocks: got 18 bytes, want 20"},
+		{"offset past the end", func(data []byte) []byte {
+			copy(data[2*7:], wadtest.EncodeLE(uint16(100)))
+			return data
+		}, "block 3: lump BLOCKMAP: got 40 bytes, want 204"},
+		{"missing end marker", func(data []byte) []byte {
+			return data[:len(data)-2]
+		}, "block 2: lump BLOCKMAP: got 38 bytes, want 40"},
+	}
+	for _, test := range tests {
+		_, err := wad.ParseBlockmap(test.corrupt(testBlockmap()))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestBlockmapValidate(t *testing.T) {
+	blockmap, err := wad.ParseBlockmap(testBlockmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blockmap.Validate(3); err != nil {
+		t.Errorf("3 linedefs: unexpected error: %s", err)
+	}
+	want := "block 2: linedef 2 out of range"
+	if err := blockmap.Validate(2); err == nil || err.Error() != want {
+		t.Errorf("2 linedefs: got error %v, want %q", err, want)
+	}
+}
+
+func TestBlockmapLinesInRect(t *testing.T) {
+	blockmap, err := wad.ParseBlockmap(testBlockmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		bbox wad.BBox
+		want []int
+	}{
+		{"one block", wad.BBox{Left: -64, Right: -1, Bottom: 0, Top: 127}, []int{0, 1}},
+		{"empty block", wad.BBox{Left: 64, Right: 64, Bottom: 0, Top: 0}, []int{}},
+		{"shared linedef listed once", wad.BBox{Left: -64, Right: 200, Bottom: 0, Top: 0}, []int{0, 1, 2}},
+		{"whole grid", wad.BBox{Left: -64, Right: 319, Bottom: 0, Top: 255}, []int{0, 1, 2}},
+		{"clamped left and below", wad.BBox{Left: -30000, Right: -20000, Bottom: -30000, Top: -20000}, []int{0, 1}},
+		{"clamped right and above", wad.BBox{Left: 20000, Right: 30000, Bottom: 20000, Top: 30000}, []int{0, 1}},
+		{"clamped around the grid", wad.BBox{Left: -30000, Right: 30000, Bottom: 200, Top: 30000}, []int{0, 1}},
+	}
+	for _, test := range tests {
+		if got := blockmap.LinesInRect(test.bbox); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: LinesInRect(%+v) = %v, want %v", test.name, test.bbox, got, test.want)
+		}
+	}
+	empty := &wad.Blockmap{}
+	if got := empty.LinesInRect(wad.BBox{Right: 100, Top: 100}); got != nil {
+		t.Errorf("empty grid: LinesInRect() = %v, want nil", got)
+	}
+}
@@ -0,0 +1,176 @@
+package wad
+
+// Boom generalized linedef specials encode the behavior of a line in bit
+// fields of its special instead of looking it up in a table. Every kind
+// has a base value, and specials from a base up to the next higher base
+// belong to that kind.
+const (
+	boomCrusherBase = 0x2f80
+	boomStairsBase  = 0x3000
+	boomLiftBase    = 0x3400
+	boomLockedBase  = 0x3800
+	boomDoorBase    = 0x3c00
+	boomCeilingBase = 0x4000
+	boomFloorBase   = 0x6000
+)
+
+// BoomKind is the kind of sector action of a generalized linedef special.
+type BoomKind int
+
+const (
+	BoomFloor BoomKind = iota
+	BoomCeiling
+	BoomDoor
+	BoomLockedDoor
+	BoomLift
+	BoomStairs
+	BoomCrusher
+)
+
+// BoomTrigger is how a generalized linedef special is activated.
+type BoomTrigger int
+
+const (
+	BoomWalk   BoomTrigger = iota // Crossing the line.
+	BoomSwitch                    // Using the line.
+	BoomGun                       // Shooting the line.
+	BoomPush                      // Using the line as a door, without a tag.
+)
+
+// BoomSpeed is the speed of the sector that a generalized linedef special
+// moves.
+type BoomSpeed int
+
+const (
+	BoomSlow BoomSpeed = iota
+	BoomNormal
+	BoomFast
+	BoomTurbo
+)
+
+// BoomTarget is the height that a floor, ceiling, or lift moves to.
+type BoomTarget int
+
+const (
+	BoomTargetNone BoomTarget = iota
+	BoomTargetHighestNeighborFloor
+	BoomTargetLowestNeighborFloor
+	BoomTargetNextNeighborFloor
+	BoomTargetHighestNeighborCeiling
+	BoomTargetLowestNeighborCeiling
+	BoomTargetNextNeighborCeiling
+	BoomTargetFloor
+	BoomTargetCeiling
+	BoomTargetShortestLowerTexture
+	BoomTargetShortestUpperTexture
+	BoomTarget24Units
+	BoomTarget32Units
+	BoomTargetPerpetual // Lifts that move up and down until stopped.
+)
+
+var (
+	boomFloorTargets = [8]BoomTarget{
+		BoomTargetHighestNeighborFloor, BoomTargetLowestNeighborFloor, BoomTargetNextNeighborFloor, BoomTargetLowestNeighborCeiling,
+		BoomTargetCeiling, BoomTargetShortestLowerTexture, BoomTarget24Units, BoomTarget32Units,
+	}
+	boomCeilingTargets = [8]BoomTarget{
+		BoomTargetHighestNeighborCeiling, BoomTargetLowestNeighborCeiling, BoomTargetNextNeighborCeiling, BoomTargetHighestNeighborFloor,
+		BoomTargetFloor, BoomTargetShortestUpperTexture, BoomTarget24Units, BoomTarget32Units,
+	}
+	boomLiftTargets = [4]BoomTarget{
+		BoomTargetLowestNeighborFloor, BoomTargetNextNeighborFloor, BoomTargetLowestNeighborCeiling, BoomTargetPerpetual,
+	}
+	boomDoorDelays = [4]int{1, 4, 9, 30}
+	boomLiftDelays = [4]int{1, 3, 5, 10}
+	boomStairSteps = [4]int{4, 8, 16, 24}
+)
+
+// BoomKey is the key that a generalized locked door needs.
+type BoomKey int
+
+const (
+	BoomAnyKey BoomKey = iota
+	BoomRedCard
+	BoomBlueCard
+	BoomYellowCard
+	BoomRedSkull
+	BoomBlueSkull
+	BoomYellowSkull
+	BoomAllKeys
+)
+
+// BoomSpecial is a decoded generalized linedef special. Fields that do not
+// apply to the kind of the special are left zero.
+type BoomSpecial struct {
+	Kind           BoomKind
+	Trigger        BoomTrigger
+	Repeatable     bool
+	Speed          BoomSpeed
+	Target         BoomTarget // Destination of floors, ceilings, and lifts.
+	Up             bool       // Floors, ceilings, and stairs move up instead of down.
+	Monsters       bool       // Monsters can activate the special.
+	Crush          bool       // Floors and ceilings crush things in the way.
+	Silent         bool       // Crushers make no sound.
+	Stay           bool       // Doors stay open or closed instead of returning.
+	Close          bool       // Doors close first instead of opening.
+	Delay          int        // Seconds that doors and lifts wait before returning.
+	Key            BoomKey    // Key that opens a locked door.
+	SkullIsCard    bool       // Skull and card keys of the same color are interchangeable.
+	Step           int        // Height of each step of stairs in map units.
+	IgnoreTextures bool       // Stairs build across sectors with different floor textures.
+}
+
+// DecodeBoomSpecial decodes a Boom generalized linedef special. It returns
+// false if the special is a vanilla special instead.
+func DecodeBoomSpecial(function int16) (BoomSpecial, bool) {
+	bits := int(uint16(function))
+	if bits < boomCrusherBase {
+		return BoomSpecial{}, false
+	}
+	special := BoomSpecial{
+		Trigger:    BoomTrigger(bits & 0x7 >> 1),
+		Repeatable: bits&0x1 != 0,
+		Speed:      BoomSpeed(bits & 0x18 >> 3),
+	}
+	switch {
+	case bits >= boomFloorBase:
+		special.Kind = BoomFloor
+		special.Target = boomFloorTargets[bits&0x380>>7]
+		special.Up = bits&0x40 != 0
+		special.Monsters = bits&0xc00 == 0 && bits&0x20 != 0
+		special.Crush = bits&0x1000 != 0
+	case bits >= boomCeilingBase:
+		special.Kind = BoomCeiling
+		special.Target = boomCeilingTargets[bits&0x380>>7]
+		special.Up = bits&0x40 != 0
+		special.Monsters = bits&0xc00 == 0 && bits&0x20 != 0
+		special.Crush = bits&0x1000 != 0
+	case bits >= boomDoorBase:
+		special.Kind = BoomDoor
+		special.Stay = bits&0x20 != 0
+		special.Close = bits&0x40 != 0
+		special.Monsters = bits&0x80 != 0
+		special.Delay = boomDoorDelays[bits&0x300>>8]
+	case bits >= boomLockedBase:
+		special.Kind = BoomLockedDoor
+		special.Stay = bits&0x20 != 0
+		special.Key = BoomKey(bits & 0x1c0 >> 6)
+		special.SkullIsCard = bits&0x200 != 0
+	case bits >= boomLiftBase:
+		special.Kind = BoomLift
+		special.Monsters = bits&0x20 != 0
+		special.Delay = boomLiftDelays[bits&0xc0>>6]
+		special.Target = boomLiftTargets[bits&0x300>>8]
+	case bits >= boomStairsBase:
+		special.Kind = BoomStairs
+		special.Monsters = bits&0x20 != 0
+		special.Step = boomStairSteps[bits&0xc0>>6]
+		special.Up = bits&0x100 != 0
+		special.IgnoreTextures = bits&0x200 != 0
+	default:
+		special.Kind = BoomCrusher
+		special.Monsters = bits&0x20 != 0
+		special.Silent = bits&0x40 != 0
+	}
+	return special, true
+}
@@ -0,0 +1,53 @@
+package wad
+
+import "testing"
+
+func TestDecodeBoomSpecial(t *testing.T) {
+	tests := []struct {
+		function int
+		want     BoomSpecial
+	}{
+		// W1 slow floor, monsters, down to the lowest neighbor floor.
+		{0x60a0, BoomSpecial{Kind: BoomFloor, Trigger: BoomWalk, Speed: BoomSlow, Target: BoomTargetLowestNeighborFloor, Monsters: true}},
+		// SR fast floor, up to the lowest neighbor ceiling, crushing.
+		{0x71d3, BoomSpecial{Kind: BoomFloor, Trigger: BoomSwitch, Repeatable: true, Speed: BoomFast, Target: BoomTargetLowestNeighborCeiling, Up: true, Crush: true}},
+		// G1 turbo ceiling, down to the floor.
+		{0x421c, BoomSpecial{Kind: BoomCeiling, Trigger: BoomGun, Speed: BoomTurbo, Target: BoomTargetFloor}},
+		// DR normal door, open, wait 4 seconds, close, monsters.
+		{0x3d8f, BoomSpecial{Kind: BoomDoor, Trigger: BoomPush, Repeatable: true, Speed: BoomNormal, Monsters: true, Delay: 4}},
+		// S1 slow door, close and stay closed.
+		{0x3c62, BoomSpecial{Kind: BoomDoor, Trigger: BoomSwitch, Speed: BoomSlow, Stay: true, Close: true, Delay: 1}},
+		// SR normal locked door, open and stay, blue card or skull.
+		{0x3aab, BoomSpecial{Kind: BoomLockedDoor, Trigger: BoomSwitch, Repeatable: true, Speed: BoomNormal, Stay: true, Key: BoomBlueCard, SkullIsCard: true}},
+		// SR normal locked door, open and stay, blue card only.
+		{0x38ab, BoomSpecial{Kind: BoomLockedDoor, Trigger: BoomSwitch, Repeatable: true, Speed: BoomNormal, Stay: true, Key: BoomBlueCard}},
+		// D1 slow locked door, all keys.
+		{0x39c6, BoomSpecial{Kind: BoomLockedDoor, Trigger: BoomPush, Speed: BoomSlow, Key: BoomAllKeys}},
+		// WR fast lift, monsters, wait 3 seconds.
+		{0x3471, BoomSpecial{Kind: BoomLift, Trigger: BoomWalk, Repeatable: true, Speed: BoomFast, Monsters: true, Delay: 3, Target: BoomTargetLowestNeighborFloor}},
+		// S1 slow perpetual lift.
+		{0x3702, BoomSpecial{Kind: BoomLift, Trigger: BoomSwitch, Speed: BoomSlow, Delay: 1, Target: BoomTargetPerpetual}},
+		// S1 slow stairs, 8 unit steps up.
+		{0x3142, BoomSpecial{Kind: BoomStairs, Trigger: BoomSwitch, Speed: BoomSlow, Step: 8, Up: true}},
+		// GR slow silent crusher.
+		{0x2fc5, BoomSpecial{Kind: BoomCrusher, Trigger: BoomGun, Repeatable: true, Speed: BoomSlow, Silent: true}},
+	}
+	for _, test := range tests {
+		got, ok := DecodeBoomSpecial(int16(test.function))
+		if !ok {
+			t.Errorf("DecodeBoomSpecial(%#x) is not a generalized special", test.function)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("DecodeBoomSpecial(%#x) = %+v, want %+v", test.function, got, test.want)
+		}
+	}
+}
+
+func TestDecodeBoomSpecialVanilla(t *testing.T) {
+	for _, function := range []int16{0, 1, 11, 141, 0x2f7f} {
+		if special, ok := DecodeBoomSpecial(function); ok {
+			t.Errorf("DecodeBoomSpecial(%#x) = %+v, want a vanilla special", function, special)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package wad
+
+import (
+	"fmt"
+)
+
+const (
+	demoMarker     = 0x80 // Ends the tic commands of a demo.
+	demoMaxPlayers = 4
+	// Demos of Doom 1.4 and later start with the version number. Older
+	// demos start with the skill level, which is at most 4.
+	demoMinVersion = 104
+)
+
+// Demo button bits.
+const (
+	DemoButtonAttack = 0x01
+	DemoButtonUse    = 0x02
+	DemoButtonChange = 0x04 // The weapon number is in DemoWeaponMask.
+	DemoWeaponMask   = 0x38
+	DemoWeaponShift  = 3
+)
+
+// TicCmd is the input of one player during one tic of a demo.
+type TicCmd struct {
+	ForwardMove int8  // Forward movement, negative is backwards.
+	SideMove    int8  // Strafe movement, negative is left.
+	AngleTurn   int16 // Turn in BAMS, only the high byte is recorded.
+	Buttons     uint8
+}
+
+// Demo is a recording of the input of the players during a game. Old
+// demos that predate versioning have a zero version and the game options
+// that they lack are false.
+type Demo struct {
+	Version       int
+	Skill         int
+	Episode       int
+	Map           int
+	Deathmatch    bool
+	Respawn       bool
+	Fast          bool
+	NoMonsters    bool
+	ConsolePlayer int
+	Players       [demoMaxPlayers]bool
+	Tics          [][]TicCmd // Commands of the players in game indexed by tic.
+}
+
+// NumPlayers returns the number of players in the demo.
+func (demo *Demo) NumPlayers() int {
+	count := 0
+	for _, playing := range demo.Players {
+		if playing {
+			count++
+		}
+	}
+	return count
+}
+
+// ReadDemo reads a demo lump such as DEMO1.
+func (w *WAD) ReadDemo(name string) (*Demo, error) {
+	lump, ok := w.lumps[name]
+	if !ok {
+		return nil, fmt.Errorf("demo %s not found", name)
+	}
+	data, err := w.readLump(lump)
+	if err != nil {
+		return nil, err
+	}
+	demo, err := parseDemo(data)
+	if err != nil {
+		return nil, fmt.Errorf("demo %s: %s", name, err)
+	}
+	return demo, nil
+}
+
+// parseDemo parses the header and the tic commands of a demo.
+func parseDemo(data []byte) (*Demo, error) {
+	demo := &Demo{}
+	var header []byte
+	if len(data) > 0 && data[0] >= demoMinVersion {
+		if len(data) < 9+demoMaxPlayers {
+			return nil, fmt.Errorf("truncated header")
+		}
+		header, data = data[:9+demoMaxPlayers], data[9+demoMaxPlayers:]
+		demo.Version = int(header[0])
+		demo.Skill = int(header[1])
+		demo.Episode = int(header[2])
+		demo.Map = int(header[3])
+		demo.Deathmatch = header[4] != 0
+		demo.Respawn = header[5] != 0
+		demo.Fast = header[6] != 0
+		demo.NoMonsters = header[7] != 0
+		demo.ConsolePlayer = int(header[8])
+		header = header[9:]
+	} else {
+		if len(data) < 3+demoMaxPlayers {
+			return nil, fmt.Errorf("truncated header")
+		}
+		header, data = data[:3+demoMaxPlayers], data[3+demoMaxPlayers:]
+		demo.Skill = int(header[0])
+		demo.Episode = int(header[1])
+		demo.Map = int(header[2])
+		header = header[3:]
+	}
+	for i := range demo.Players {
+		demo.Players[i] = header[i] != 0
+	}
+	players := demo.NumPlayers()
+	if players == 0 {
+		return nil, fmt.Errorf("no players")
+	}
+	for {
+		if len(data) == 0 {
+			return nil, fmt.Errorf("missing end marker")
+		}
+		if data[0] == demoMarker {
+			return demo, nil
+		}
+		if len(data) < 4*players {
+			return nil, fmt.Errorf("truncated tic %d", len(demo.Tics))
+		}
+		tic := make([]TicCmd, players)
+		for i := range tic {
+			tic[i] = TicCmd{
+				ForwardMove: int8(data[0]),
+				SideMove:    int8(data[1]),
+				AngleTurn:   int16(uint16(data[2]) << 8),
+				Buttons:     data[3],
+			}
+			data = data[4:]
+		}
+		demo.Tics = append(demo.Tics, tic)
+	}
+}
@@ -0,0 +1,137 @@
+package wad_test
+
+import (
+	"github.com/penberg/godoom/internal/wadtest"
+	"github.com/penberg/godoom/wad"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDemo(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want wad.Demo
+	}{
+		{
+			"v1.9",
+			[]byte{
+				109, 3, 1, 2, 0, 1, 0, 1, 0, 1, 0, 0, 0,
+				25, 0xfe, 0x02, wad.DemoButtonAttack,
+				0xe7, 0, 0xff, wad.DemoButtonChange | 2<<wad.DemoWeaponShift,
+				wad.DemoMarker,
+			},
+			wad.Demo{
+				Version: 109, Skill: 3, Episode: 1, Map: 2, Respawn: true, NoMonsters: true,
+				Players: [wad.DemoMaxPlayers]bool{true},
+				Tics: [][]wad.TicCmd{
+					{{ForwardMove: 25, SideMove: -2, AngleTurn: 0x0200, Buttons: wad.DemoButtonAttack}},
+					{{ForwardMove: -25, AngleTurn: -0x0100, Buttons: wad.DemoButtonChange | 2<<wad.DemoWeaponShift}},
+				},
+			},
+		},
+		{
+			"v1.9 options and console player",
+			[]byte{109, 4, 0, 7, 1, 0, 1, 0, 2, 1, 0, 1, 0, wad.DemoMarker},
+			wad.Demo{
+				Version: 109, Skill: 4, Map: 7, Deathmatch: true, Fast: true, ConsolePlayer: 2,
+				Players: [wad.DemoMaxPlayers]bool{true, false, true, false},
+			},
+		},
+		{
+			"pre-1.4",
+			[]byte{2, 1, 3, 1, 0, 0, 0, 50, 0, 0, 0, wad.DemoMarker},
+			wad.Demo{
+				Skill: 2, Episode: 1, Map: 3,
+				Players: [wad.DemoMaxPlayers]bool{true},
+				Tics:    [][]wad.TicCmd{{{ForwardMove: 50}}},
+			},
+		},
+		{
+			"four players",
+			[]byte{
+				109, 2, 1, 1, 0, 0, 0, 0, 0, 1, 1, 1, 1,
+				1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0,
+				wad.DemoMarker,
+			},
+			wad.Demo{
+				Version: 109, Skill: 2, Episode: 1, Map: 1,
+				Players: [wad.DemoMaxPlayers]bool{true, true, true, true},
+				Tics:    [][]wad.TicCmd{{{ForwardMove: 1}, {ForwardMove: 2}, {ForwardMove: 3}, {ForwardMove: 4}}},
+			},
+		},
+	}
+	for _, test := range tests {
+		demo, err := wad.ParseDemo(test.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*demo, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *demo, test.want)
+		}
+	}
+}
+
+func TestParseDemoNumPlayers(t *testing.T) {
+	tests := []struct {
+		players []byte
+		want    int
+	}{
+		{[]byte{1, 0, 0, 0}, 1},
+		{[]byte{0, 1, 0, 1}, 2},
+		{[]byte{1, 1, 1, 0}, 3},
+		{[]byte{1, 1, 1, 1}, 4},
+	}
+	for _, test := range tests {
+		data := append([]byte{109, 2, 1, 1, 0, 0, 0, 0, 0}, test.players...)
+		data = append(data, wad.DemoMarker)
+		demo, err := wad.ParseDemo(data)
+		if err != nil {
+			t.Errorf("players %v: unexpected error: %s", test.players, err)
+			continue
+		}
+		if got := demo.NumPlayers(); got != test.want {
+			t.Errorf("players %v: NumPlayers() = %d, want %d", test.players, got, test.want)
+		}
+	}
+}
+
+func TestParseDemoErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", []byte{}, "truncated header"},
+		{"truncated v1.9 header", []byte{109, 3, 1, 1, 0, 0, 0, 0, 0, 1}, "truncated header"},
+		{"truncated pre-1.4 header", []byte{2, 1, 1, 1}, "truncated header"},
+		{"no players", []byte{109, 3, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, wad.DemoMarker}, "no players"},
+		{"missing end marker", []byte{109, 3, 1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 25, 0, 0, 0}, "missing end marker"},
+		{"truncated tic", []byte{109, 3, 1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 25, 0, 0, 0, 25, 0}, "truncated tic 1"},
+		{"truncated tic of a second player", []byte{109, 3, 1, 1, 0, 0, 0, 0, 0, 1, 1, 0, 0, 25, 0, 0, 0, wad.DemoMarker}, "truncated tic 0"},
+	}
+	for _, test := range tests {
+		_, err := wad.ParseDemo(test.data)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReadDemo(t *testing.T) {
+	lumps := append(wadtest.IWADLumps(), wadtest.Lump{Name: "DEMO1", Data: []byte{2, 1, 3, 1, 0, 0, 0, wad.DemoMarker}}, wadtest.Lump{Name: "DEMO2", Data: []byte{109}})
+	w := wadtest.MustReadIWAD(t, lumps)
+	if demo, err := w.ReadDemo("DEMO1"); err != nil {
+		t.Errorf("DEMO1: unexpected error: %s", err)
+	} else if demo.Map != 3 || len(demo.Tics) != 0 {
+		t.Errorf("DEMO1: got map %d with %d tics, want map 3 with none", demo.Map, len(demo.Tics))
+	}
+	if _, err := w.ReadDemo("DEMO2"); err == nil || err.Error() != "demo DEMO2: truncated header" {
+		t.Errorf("DEMO2: got error %v, want demo DEMO2: truncated header", err)
+	}
+	if _, err := w.ReadDemo("DEMO3"); err == nil || err.Error() != "demo DEMO3 not found" {
+		t.Errorf("DEMO3: got error %v, want demo DEMO3 not found", err)
+	}
+}
@@ -0,0 +1,30 @@
+package wad
+
+// Exported for the tests of package wad_test, which share the fixtures of
+// package wadtest with the game.
+
+const (
+	BlockmapEnd           = blockmapEnd
+	DemoMarker            = demoMarker
+	DemoMaxPlayers        = demoMaxPlayers
+	GenmidiMagic          = genmidiMagic
+	GenmidiNumInstruments = genmidiNumInstruments
+	MaxValidationErrors   = maxValidationErrors
+)
+
+var (
+	ParseBlockmap = parseBlockmap
+	ParseDemo     = parseDemo
+	ParseDmxgus   = parseDmxgus
+	ReadUDMF      = readUDMF
+)
+
+// Blocks returns the linedef lists of the blocks of a blockmap.
+func (blockmap *Blockmap) Blocks() [][]int {
+	return blockmap.blocks
+}
+
+// Validate checks that a blockmap only lists existing linedefs.
+func (blockmap *Blockmap) Validate(linedefs int) error {
+	return blockmap.validate(linedefs)
+}
@@ -0,0 +1,154 @@
+package wad
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	genmidiMagic          = "#OPL_II#"
+	genmidiNumInstruments = 175 // 128 melodic and 47 percussion instruments.
+)
+
+// GENMIDI instrument flags.
+const (
+	GenmidiFixedPitch  = 0x0001 // Always play the fixed note.
+	GenmidiDoubleVoice = 0x0004 // Play both voices at once.
+)
+
+// OPLOperator holds the register values of one OPL2 operator.
+type OPLOperator struct {
+	TremoloVibrato uint8
+	AttackDecay    uint8
+	SustainRelease uint8
+	Waveform       uint8
+	KeyScale       uint8
+	Level          uint8
+}
+
+// OPLVoice is a two-operator OPL2 voice.
+type OPLVoice struct {
+	Modulator      OPLOperator
+	Feedback       uint8
+	Carrier        OPLOperator
+	Unused         uint8
+	BaseNoteOffset int16
+}
+
+// GenmidiInstrument is an instrument definition of the GENMIDI lump.
+type GenmidiInstrument struct {
+	Flags      uint16
+	FineTuning uint8
+	FixedNote  uint8
+	Voices     [2]OPLVoice
+}
+
+// Instrument is a named GENMIDI instrument.
+type Instrument struct {
+	GenmidiInstrument
+	Name string
+}
+
+// ReadGenmidi reads the OPL2 instrument table from the GENMIDI lump. The
+// first 128 instruments are the General MIDI melodic instruments and the
+// rest are percussion instruments for notes 35 to 81.
+func (w *WAD) ReadGenmidi() ([]Instrument, error) {
+	lump, ok := w.lumps["GENMIDI"]
+	if !ok {
+		return nil, fmt.Errorf("GENMIDI not found")
+	}
+	decoder, err := w.newLumpDecoder(lump)
+	if err != nil {
+		return nil, err
+	}
+	var magic [8]byte
+	if err := decoder.decode(0, &magic); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != genmidiMagic {
+		return nil, fmt.Errorf("GENMIDI: bad magic %q", magic[:])
+	}
+	var genmidi [genmidiNumInstruments]GenmidiInstrument
+	offset := int64(len(magic))
+	if err := decoder.decode(offset, &genmidi); err != nil {
+		return nil, err
+	}
+	var names [genmidiNumInstruments][32]byte
+	offset += int64(binary.Size(&genmidi))
+	if err := decoder.decode(offset, &names); err != nil {
+		return nil, err
+	}
+	instruments := make([]Instrument, genmidiNumInstruments)
+	for i := range instruments {
+		name := string(names[i][:])
+		if end := strings.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		instruments[i] = Instrument{GenmidiInstrument: genmidi[i], Name: name}
+	}
+	return instruments, nil
+}
+
+// GusPatch maps a MIDI instrument to Gravis Ultrasound patches. The
+// mapping holds the patch used with 256K, 512K, 768K and 1024K of GUS
+// memory.
+type GusPatch struct {
+	Instrument int
+	Mapping    [4]int
+	Name       string
+}
+
+// ReadDmxgus reads the GUS patch mapping from the DMXGUS lump.
+func (w *WAD) ReadDmxgus() ([]GusPatch, error) {
+	lump, ok := w.lumps["DMXGUS"]
+	if !ok {
+		return nil, fmt.Errorf("DMXGUS not found")
+	}
+	data, err := w.readLump(lump)
+	if err != nil {
+		return nil, err
+	}
+	return parseDmxgus(bytes.NewReader(data))
+}
+
+// parseDmxgus parses the text format of DMXGUS. Every line has the
+// instrument number, the four patch mappings and the patch name separated
+// by commas. Lines starting with '#' are comments.
+func parseDmxgus(r io.Reader) ([]GusPatch, error) {
+	patches := []GusPatch{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(strings.TrimRight(scanner.Text(), "\x00\x1a"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("DMXGUS line %d: expected 6 fields, got %d", lineNumber, len(fields))
+		}
+		numbers := [5]int{}
+		for i := range numbers {
+			n, err := strconv.Atoi(strings.TrimSpace(fields[i]))
+			if err != nil {
+				return nil, fmt.Errorf("DMXGUS line %d: %s", lineNumber, err)
+			}
+			numbers[i] = n
+		}
+		patches = append(patches, GusPatch{
+			Instrument: numbers[0],
+			Mapping:    [4]int{numbers[1], numbers[2], numbers[3], numbers[4]},
+			Name:       strings.TrimSpace(fields[5]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return patches, nil
+}
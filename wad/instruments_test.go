@@ -0,0 +1,122 @@
+package wad_test
+
+import (
+	"encoding/binary"
+	"github.com/penberg/godoom/internal/wadtest"
+	"github.com/penberg/godoom/wad"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testGenmidi returns a GENMIDI lump whose instruments have their index as
+// fine tuning and are named after it.
+func testGenmidi() []byte {
+	data := []byte(wad.GenmidiMagic)
+	var instruments [wad.GenmidiNumInstruments]wad.GenmidiInstrument
+	var names [wad.GenmidiNumInstruments][32]byte
+	for i := range instruments {
+		instruments[i].FineTuning = uint8(i)
+		copy(names[i][:], "Instrument "+string(rune('A'+i%26)))
+	}
+	instruments[0].Flags = wad.GenmidiDoubleVoice
+	instruments[0].Voices[1].BaseNoteOffset = -12
+	instruments[130].Flags = wad.GenmidiFixedPitch
+	instruments[130].FixedNote = 60
+	return append(append(data, wadtest.EncodeLE(instruments)...), wadtest.EncodeLE(names)...)
+}
+
+func TestReadGenmidi(t *testing.T) {
+	w := wadtest.MustReadIWAD(t, append(wadtest.IWADLumps(), wadtest.Lump{Name: "GENMIDI", Data: testGenmidi()}))
+	instruments, err := w.ReadGenmidi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instruments) != wad.GenmidiNumInstruments {
+		t.Fatalf("got %d instruments, want %d", len(instruments), wad.GenmidiNumInstruments)
+	}
+	if got := instruments[0]; got.Flags != wad.GenmidiDoubleVoice || got.Voices[1].BaseNoteOffset != -12 || got.Name != "Instrument A" {
+		t.Errorf("instrument 0 = %+v, want a double voice instrument named Instrument A", got)
+	}
+	if got := instruments[130]; got.Flags != wad.GenmidiFixedPitch || got.FixedNote != 60 || got.FineTuning != 130 || got.Name != "Instrument A" {
+		t.Errorf("instrument 130 = %+v, want a fixed pitch instrument", got)
+	}
+	if got := instruments[wad.GenmidiNumInstruments-1]; got.FineTuning != wad.GenmidiNumInstruments-1 || got.Name != "Instrument S" {
+		t.Errorf("last instrument = %+v, want fine tuning %d and name Instrument S", got, wad.GenmidiNumInstruments-1)
+	}
+}
+
+func TestReadGenmidiErrors(t *testing.T) {
+	instrumentsSize := wad.GenmidiNumInstruments * binary.Size(wad.GenmidiInstrument{})
+	valid := testGenmidi()
+	tests := []struct {
+		name  string
+		lumps []wadtest.Lump
+		want  string
+	}{
+		{"missing", nil, "GENMIDI not found"},
+		{"bad magic", []wadtest.Lump{{Name: "GENMIDI", Data: append([]byte("#OPL_I#!"), valid[8:]...)}}, `GENMIDI: bad magic "#OPL_I#!"`},
+		{"truncated magic", []wadtest.Lump{{Name: "GENMIDI", Data: valid[:4]}}, "lump GENMIDI: got 4 bytes, want 8"},
+		{"truncated instruments", []wadtest.Lump{{Name: "GENMIDI", Data: valid[:8+instrumentsSize-1]}}, "lump GENMIDI: got 6307 bytes, want 6308"},
+		{"truncated names", []wadtest.Lump{{Name: "GENMIDI", Data: valid[:len(valid)-32]}}, "lump GENMIDI: got 11876 bytes, want 11908"},
+	}
+	for _, test := range tests {
+		w := wadtest.MustReadIWAD(t, append(wadtest.IWADLumps(), test.lumps...))
+		_, err := w.ReadGenmidi()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestParseDmxgus(t *testing.T) {
+	text := "# Instrument, 256K, 512K, 768K, 1024K, patch\r\n" +
+		"0, 0, 0, 0, 0, acpiano\r\n" +
+		"\r\n" +
+		"  # An indented comment\r\n" +
+		"1,0,1,1,1,britepno\r\n" +
+		" 128 , 2 , 3 , 4 , 5 , drum \r\n" +
+		"\x1a\x00"
+	patches, err := wad.ParseDmxgus(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []wad.GusPatch{
+		{Instrument: 0, Mapping: [4]int{0, 0, 0, 0}, Name: "acpiano"},
+		{Instrument: 1, Mapping: [4]int{0, 1, 1, 1}, Name: "britepno"},
+		{Instrument: 128, Mapping: [4]int{2, 3, 4, 5}, Name: "drum"},
+	}
+	if !reflect.DeepEqual(patches, want) {
+		t.Errorf("got %+v, want %+v", patches, want)
+	}
+}
+
+func TestParseDmxgusErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"too few fields", "# comment\n0, 0, 0, 0, acpiano\n", "DMXGUS line 2: expected 6 fields, got 5"},
+		{"too many fields", "0, 0, 0, 0, 0, acpiano, extra\n", "DMXGUS line 1: expected 6 fields, got 7"},
+		{"bad number", "0, 0, 0, 0, 0, acpiano\n1, 0, x, 0, 0, britepno\n", `DMXGUS line 2: strconv.Atoi: parsing "x": invalid syntax`},
+		{"missing number", "0, , 0, 0, 0, acpiano\n", `DMXGUS line 1: strconv.Atoi: parsing "": invalid syntax`},
+	}
+	for _, test := range tests {
+		_, err := wad.ParseDmxgus(strings.NewReader(test.text))
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReadDmxgus(t *testing.T) {
+	w := wadtest.MustReadIWAD(t, append(wadtest.IWADLumps(), wadtest.Lump{Name: "DMXGUS", Data: []byte("0, 0, 0, 0, 0, acpiano\n")}))
+	if patches, err := w.ReadDmxgus(); err != nil || len(patches) != 1 || patches[0].Name != "acpiano" {
+		t.Errorf("got patches %+v and error %v, want acpiano", patches, err)
+	}
+	w = wadtest.MustReadIWAD(t, wadtest.IWADLumps())
+	if _, err := w.ReadDmxgus(); err == nil || err.Error() != "DMXGUS not found" {
+		t.Errorf("got error %v, want DMXGUS not found", err)
+	}
+}
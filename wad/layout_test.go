@@ -0,0 +1,39 @@
+package wad
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// WAD files store numbers in little-endian byte order and every record is
+// decoded field by field with binary.LittleEndian, so reading them depends
+// on neither the byte order of the host nor the padding of the Go structs.
+// The number of records in a lump is its size divided by the binary.Size
+// of the record, so a record type must keep the size of the record on
+// disk when a field is added, removed, or resized.
+func TestRecordSizes(t *testing.T) {
+	tests := []struct {
+		name   string
+		record interface{}
+		size   int
+	}{
+		{"header", header{}, 12},
+		{"lumpRecord", lumpRecord{}, 16},
+		{"TextureHeader", TextureHeader{}, 22},
+		{"Patch", Patch{}, 10},
+		{"doomThing", doomThing{}, 10},
+		{"hexenThing", hexenThing{}, 20},
+		{"Linedef", Linedef{}, 14},
+		{"Sidedef", Sidedef{}, 30},
+		{"Vertex", Vertex{}, 4},
+		{"Seg", Seg{}, 12},
+		{"SSector", SSector{}, 4},
+		{"Node", Node{}, 28},
+		{"Sector", Sector{}, 26},
+	}
+	for _, test := range tests {
+		if got := binary.Size(test.record); got != test.size {
+			t.Errorf("%s is %d bytes when encoded, want %d bytes like the record on disk", test.name, got, test.size)
+		}
+	}
+}
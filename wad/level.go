@@ -0,0 +1,216 @@
+package wad
+
+import (
+	"math"
+)
+
+// SubsectorBit marks a node child as a subsector.
+const SubsectorBit = int(0x8000)
+
+// Point is a point in map coordinates.
+type Point struct {
+	X int16
+	Y int16
+}
+
+// PointOnSide returns 0 if a point is on the front side of a node's
+// partition line and 1 if it is on the back side.
+func PointOnSide(point *Point, node *Node) int {
+	dx := int(point.X) - int(node.X)
+	dy := int(point.Y) - int(node.Y)
+	// Perp dot product. Unlike the fixed point partition lines of the
+	// Doom engine, the WAD stores them as plain integers.
+	left := int(node.DY) * dx
+	right := int(node.DX) * dy
+	if right < left {
+		// Point is on front side:
+		return 0
+	}
+	// Point is on the back side:
+	return 1
+}
+
+// SegSidedef returns the sidedef on the side of a linedef that a seg runs
+// along, or nil if that side has no sidedef.
+func (level *Level) SegSidedef(seg *Seg, linedef *Linedef) *Sidedef {
+	if seg.Segside == 0 {
+		return &level.Sidedefs[linedef.SidedefRight]
+	} else {
+		if linedef.SidedefLeft == -1 {
+			return nil
+		}
+		return &level.Sidedefs[linedef.SidedefLeft]
+	}
+}
+
+// SegOppositeSidedef returns the sidedef on the other side of a linedef
+// than a seg, or nil if that side has no sidedef.
+func (level *Level) SegOppositeSidedef(seg *Seg, linedef *Linedef) *Sidedef {
+	if seg.Segside == 0 {
+		if linedef.SidedefLeft == -1 {
+			return nil
+		}
+		return &level.Sidedefs[linedef.SidedefLeft]
+	} else {
+		return &level.Sidedefs[linedef.SidedefRight]
+	}
+}
+
+// SubsectorSector returns the sector that a subsector belongs to and its
+// index. The sector is nil and the index -1 if none of the subsector's
+// segs has a sidedef facing it.
+func (level *Level) SubsectorSector(ssectorId int) (*Sector, int) {
+	sectorId := level.subsectorSectorId(ssectorId)
+	if sectorId < 0 {
+		return nil, -1
+	}
+	return &level.Sectors[sectorId], sectorId
+}
+
+// SectorAt returns the sector that contains a point and its index. Points
+// in subsectors without a sidedef facing them get the nearest sector
+// instead. The sector is nil and the index -1 only if the level has no
+// sectors.
+func (level *Level) SectorAt(x int16, y int16) (*Sector, int) {
+	point := Point{X: x, Y: y}
+	sectorId := -1
+	if len(level.Nodes) == 0 {
+		if len(level.SSectors) > 0 {
+			sectorId = level.subsectorSectorId(0)
+		}
+	} else {
+		sectorId = level.nodeSectorId(&point, len(level.Nodes)-1)
+	}
+	if sectorId < 0 {
+		sectorId = level.nearestSectorId(&point)
+	}
+	if sectorId < 0 {
+		return nil, -1
+	}
+	return &level.Sectors[sectorId], sectorId
+}
+
+// ThingZ returns the height of a thing's feet. Things of map formats that
+// do not store heights stand on the floor of their sector.
+func (level *Level) ThingZ(thing *Thing) int16 {
+	z := int16(0)
+	if thing.HasZ {
+		z = thing.Z
+	}
+	if sector, _ := level.SectorAt(thing.XPosition, thing.YPosition); sector != nil {
+		z += sector.FloorHeight
+	}
+	return z
+}
+
+// Bounds returns the bounding box of the level's vertexes.
+func (level *Level) Bounds() BBox {
+	bbox := BBox{}
+	for i, vertex := range level.Vertexes {
+		if i == 0 || vertex.XCoord < bbox.Left {
+			bbox.Left = vertex.XCoord
+		}
+		if i == 0 || vertex.XCoord > bbox.Right {
+			bbox.Right = vertex.XCoord
+		}
+		if i == 0 || vertex.YCoord < bbox.Bottom {
+			bbox.Bottom = vertex.YCoord
+		}
+		if i == 0 || vertex.YCoord > bbox.Top {
+			bbox.Top = vertex.YCoord
+		}
+	}
+	return bbox
+}
+
+// nodeSectorId descends the BSP tree on the side of every partition line
+// that a point is on like R_PointInSubsector and returns the sector of the
+// subsector it ends up in.
+func (level *Level) nodeSectorId(point *Point, idx int) int {
+	for idx&SubsectorBit != SubsectorBit {
+		node := level.Nodes[idx]
+		idx = int(node.Child[PointOnSide(point, &node)])
+	}
+	return level.subsectorSectorId(int(uint16(idx) & ^uint16(SubsectorBit)))
+}
+
+// indexSubsectorSectors maps every subsector to its sector once, so that
+// looking up the sector of a subsector does not walk its segs.
+func (level *Level) indexSubsectorSectors() {
+	level.subsectorSectors = nil
+	sectors := make([]int, len(level.SSectors))
+	for ssectorId := range level.SSectors {
+		sectors[ssectorId] = level.subsectorSectorId(ssectorId)
+	}
+	level.subsectorSectors = sectors
+}
+
+// subsectorSectorId returns the index of the sector that a subsector
+// belongs to, or -1 if none of its segs has a sidedef facing it. Levels
+// that were not read from a WAD archive are not indexed, so their
+// subsectors are looked up from their segs.
+func (level *Level) subsectorSectorId(ssectorId int) int {
+	if level.subsectorSectors != nil {
+		return level.subsectorSectors[ssectorId]
+	}
+	ssector := level.SSectors[ssectorId]
+	for segIdx := ssector.StartSeg; segIdx < ssector.StartSeg+ssector.Numsegs; segIdx++ {
+		seg := level.Segs[segIdx]
+		linedef := level.Linedefs[seg.LineNum]
+		if sidedef := level.SegSidedef(&seg, &linedef); sidedef != nil {
+			return int(sidedef.SectorRef)
+		}
+	}
+	return -1
+}
+
+// nearestSectorId returns the sector on the side of the linedef nearest to
+// a point that the point is on.
+func (level *Level) nearestSectorId(point *Point) int {
+	sectorId := -1
+	nearest := math.Inf(1)
+	for _, linedef := range level.Linedefs {
+		start := level.Vertexes[linedef.VertexStart]
+		end := level.Vertexes[linedef.VertexEnd]
+		distance := segmentDistance(point, &start, &end)
+		if distance >= nearest {
+			continue
+		}
+		sidedef := linedef.SidedefRight
+		if linedefPointOnSide(point, &start, &end) == 1 && linedef.SidedefLeft != -1 {
+			sidedef = linedef.SidedefLeft
+		}
+		if sidedef < 0 || int(sidedef) >= len(level.Sidedefs) {
+			continue
+		}
+		nearest = distance
+		sectorId = int(level.Sidedefs[sidedef].SectorRef)
+	}
+	return sectorId
+}
+
+// linedefPointOnSide returns 0 if a point is on the front (right) side of
+// a line and 1 if it is on the back side.
+func linedefPointOnSide(point *Point, start *Vertex, end *Vertex) int {
+	dx := int(point.X) - int(start.XCoord)
+	dy := int(point.Y) - int(start.YCoord)
+	left := (int(end.YCoord) - int(start.YCoord)) * dx
+	right := (int(end.XCoord) - int(start.XCoord)) * dy
+	if right < left {
+		return 0
+	}
+	return 1
+}
+
+// segmentDistance returns the distance from a point to a line segment.
+func segmentDistance(point *Point, start *Vertex, end *Vertex) float64 {
+	px, py := float64(point.X), float64(point.Y)
+	x0, y0 := float64(start.XCoord), float64(start.YCoord)
+	dx, dy := float64(end.XCoord)-x0, float64(end.YCoord)-y0
+	t := 0.0
+	if lengthSquared := dx*dx + dy*dy; lengthSquared > 0 {
+		t = ((px-x0)*dx + (py-y0)*dy) / lengthSquared
+		t = math.Max(0, math.Min(1, t))
+	}
+	return math.Hypot(px-(x0+t*dx), py-(y0+t*dy))
+}
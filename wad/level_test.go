@@ -0,0 +1,85 @@
+package wad_test
+
+import (
+	"github.com/penberg/godoom/internal/wadtest"
+	"github.com/penberg/godoom/wad"
+	"testing"
+)
+
+// testTwoSectorLevel returns the room of wadtest.Level with its east part, the
+// subsector in front of the partition line, made a sector of its own that
+// a two-sided linedef separates from the west part.
+func testTwoSectorLevel() *wad.Level {
+	level := wadtest.Level()
+	level.Sectors = append(level.Sectors, wad.Sector{FloorHeight: 32, CeilingHeight: 128})
+	level.Sidedefs = append(level.Sidedefs, wad.Sidedef{SectorRef: 1})
+	// The east walls face sector 1 and the bottom wall is split at the
+	// partition line.
+	level.Linedefs[3].SidedefRight = 1
+	level.Linedefs[4].SidedefRight = 1
+	level.Linedefs[5].VertexEnd = 6
+	level.Linedefs[5].SidedefRight = 1
+	level.Linedefs = append(level.Linedefs,
+		wad.Linedef{VertexStart: 6, VertexEnd: 0, SidedefRight: 0, SidedefLeft: -1},
+		// The linedef between the sectors goes south, so that its right
+		// side faces west.
+		wad.Linedef{VertexStart: 3, VertexEnd: 6, Flags: wad.LinedefTwoSided, SidedefRight: 0, SidedefLeft: 1},
+	)
+	level.Segs[3].LineNum = 6
+	segs := []wad.Seg{
+		{VertexStart: 3, VertexEnd: 4, LineNum: 3},
+		{VertexStart: 4, VertexEnd: 5, LineNum: 4},
+		{VertexStart: 5, VertexEnd: 6, LineNum: 5},
+		{VertexStart: 6, VertexEnd: 3, LineNum: 7, Segside: 1},
+	}
+	segs = append(segs, level.Segs[3:]...)
+	segs = append(segs, wad.Seg{VertexStart: 3, VertexEnd: 6, LineNum: 7})
+	level.Segs = segs
+	level.SSectors = []wad.SSector{
+		{Numsegs: 4, StartSeg: 0},
+		{Numsegs: 5, StartSeg: 4},
+	}
+	return level
+}
+
+// pointInPolygon returns true if a point is inside a polygon by counting
+// the polygon edges that a ray from the point crosses.
+func pointInPolygon(x float64, y float64, polygon [][2]float64) bool {
+	inside := false
+	for i := range polygon {
+		a, b := polygon[i], polygon[(i+1)%len(polygon)]
+		if (a[1] > y) != (b[1] > y) && x < a[0]+(y-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func TestSectorAt(t *testing.T) {
+	level := testTwoSectorLevel()
+	if err := level.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sectors := [][][2]float64{
+		{{0, 0}, {0, 256}, {128, 256}, {128, 0}},
+		{{128, 0}, {128, 128}, {256, 128}, {256, 0}},
+	}
+	// Points on a grid that avoids the walls, including points a unit away
+	// from the line between the sectors.
+	for _, x := range []int16{1, 64, 127, 129, 192, 255} {
+		for _, y := range []int16{1, 64, 127, 129, 192, 255} {
+			want := -1
+			for sectorId, polygon := range sectors {
+				if pointInPolygon(float64(x), float64(y), polygon) {
+					want = sectorId
+				}
+			}
+			if want < 0 {
+				continue
+			}
+			if _, got := level.SectorAt(x, y); got != want {
+				t.Errorf("SectorAt(%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package wad
+
+import (
+	"fmt"
+)
+
+// SpriteFrame is a frame of a sprite, made of the lumps that show it from
+// eight directions.
+type SpriteFrame struct {
+	// Lumps holds the lump names of rotations 1 to 8. Rotation 1 shows the
+	// front of a thing and every following rotation shows it from 45
+	// degrees further counterclockwise around it. Frames that look the same
+	// from every direction have the same lump in every rotation.
+	Lumps   [8]string
+	Flipped [8]bool // The lump of a rotation is drawn mirrored.
+}
+
+// SpriteFrames indexes the sprite lumps of the WAD archive by sprite name,
+// the first four characters of the lump names, and by frame letter.
+//
+// A sprite lump name continues with a frame letter and a rotation from 0
+// to 8, where 0 stands for every rotation, such as POSSA1. Lumps can be
+// shared by the mirrored rotations on either side of a thing with a second
+// frame letter and rotation, such as POSSA2A8.
+func (w *WAD) SpriteFrames() (map[string]map[byte]*SpriteFrame, error) {
+	names, err := w.SpriteNames()
+	if err != nil {
+		return nil, err
+	}
+	sprites := map[string]map[byte]*SpriteFrame{}
+	for _, name := range names {
+		if err := addSpriteLump(sprites, name); err != nil {
+			Logger.Printf("warning: Sprite %s: %s\n", name, err)
+		}
+	}
+	return sprites, nil
+}
+
+// addSpriteLump adds a sprite lump to the frames of its sprite.
+func addSpriteLump(sprites map[string]map[byte]*SpriteFrame, name string) error {
+	if len(name) != 6 && len(name) != 8 {
+		return fmt.Errorf("lump name is not 6 or 8 characters long")
+	}
+	sprite := name[:4]
+	if sprites[sprite] == nil {
+		sprites[sprite] = map[byte]*SpriteFrame{}
+	}
+	for i := 4; i < len(name); i += 2 {
+		frameLetter, rotation := name[i], name[i+1]
+		if rotation < '0' || rotation > '8' {
+			return fmt.Errorf("invalid rotation %c", rotation)
+		}
+		frame := sprites[sprite][frameLetter]
+		if frame == nil {
+			frame = &SpriteFrame{}
+			sprites[sprite][frameLetter] = frame
+		}
+		// The second frame and rotation reuse the lump mirrored.
+		flipped := i > 4
+		if rotation == '0' {
+			for r := range frame.Lumps {
+				frame.Lumps[r] = name
+				frame.Flipped[r] = flipped
+			}
+			continue
+		}
+		frame.Lumps[rotation-'1'] = name
+		frame.Flipped[rotation-'1'] = flipped
+	}
+	return nil
+}
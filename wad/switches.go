@@ -0,0 +1,123 @@
+package wad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// Switch is a pair of wall textures that a switch toggles between when it
+// is used.
+type Switch struct {
+	Off string
+	On  string
+}
+
+// switchEntry is an entry of Boom's SWITCHES lump. The list is terminated
+// by an entry with a zero episode.
+type switchEntry struct {
+	Off     [9]byte
+	On      [9]byte
+	Episode int16
+}
+
+// vanillaSwitches are the switch textures that are hardcoded in Doom.
+var vanillaSwitches = []Switch{
+	{"SW1BRCOM", "SW2BRCOM"},
+	{"SW1BRN1", "SW2BRN1"},
+	{"SW1BRN2", "SW2BRN2"},
+	{"SW1BRNGN", "SW2BRNGN"},
+	{"SW1BROWN", "SW2BROWN"},
+	{"SW1COMM", "SW2COMM"},
+	{"SW1COMP", "SW2COMP"},
+	{"SW1DIRT", "SW2DIRT"},
+	{"SW1EXIT", "SW2EXIT"},
+	{"SW1GRAY", "SW2GRAY"},
+	{"SW1GRAY1", "SW2GRAY1"},
+	{"SW1METAL", "SW2METAL"},
+	{"SW1PIPE", "SW2PIPE"},
+	{"SW1SLAD", "SW2SLAD"},
+	{"SW1STARG", "SW2STARG"},
+	{"SW1STON1", "SW2STON1"},
+	{"SW1STON2", "SW2STON2"},
+	{"SW1STONE", "SW2STONE"},
+	{"SW1STRTN", "SW2STRTN"},
+	{"SW1BLUE", "SW2BLUE"},
+	{"SW1CMT", "SW2CMT"},
+	{"SW1GARG", "SW2GARG"},
+	{"SW1GSTON", "SW2GSTON"},
+	{"SW1HOT", "SW2HOT"},
+	{"SW1LION", "SW2LION"},
+	{"SW1SATYR", "SW2SATYR"},
+	{"SW1SKIN", "SW2SKIN"},
+	{"SW1VINE", "SW2VINE"},
+	{"SW1WOOD", "SW2WOOD"},
+	{"SW1PANEL", "SW2PANEL"},
+	{"SW1ROCK", "SW2ROCK"},
+	{"SW1MET2", "SW2MET2"},
+	{"SW1WDMET", "SW2WDMET"},
+	{"SW1BRIK", "SW2BRIK"},
+	{"SW1MOD1", "SW2MOD1"},
+	{"SW1ZIM", "SW2ZIM"},
+	{"SW1STON6", "SW2STON6"},
+	{"SW1TEK", "SW2TEK"},
+	{"SW1MARB", "SW2MARB"},
+	{"SW1SKULL", "SW2SKULL"},
+}
+
+// ReadSwitches returns the switch texture pairs of the WAD archive. They
+// are read from Boom's SWITCHES lump if there is one and Doom's hardcoded
+// list is used otherwise. Only pairs whose textures both exist are
+// returned.
+func (w *WAD) ReadSwitches() ([]Switch, error) {
+	switches := vanillaSwitches
+	if lump, ok := w.lumps["SWITCHES"]; ok {
+		data, err := w.readLump(lump)
+		if err != nil {
+			return nil, err
+		}
+		switches, err = parseSwitches(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+	result := []Switch{}
+	for _, sw := range switches {
+		_, hasOff := w.textures[sw.Off]
+		_, hasOn := w.textures[sw.On]
+		if hasOff && hasOn {
+			result = append(result, sw)
+		}
+	}
+	return result, nil
+}
+
+// parseSwitches parses the binary format of the SWITCHES lump.
+func parseSwitches(r io.Reader) ([]Switch, error) {
+	switches := []Switch{}
+	for {
+		var entry switchEntry
+		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			if err == io.EOF {
+				return switches, nil
+			}
+			return nil, err
+		}
+		if entry.Episode == 0 {
+			return switches, nil
+		}
+		switches = append(switches, Switch{
+			Off: strings.ToUpper(cString(entry.Off[:])),
+			On:  strings.ToUpper(cString(entry.On[:])),
+		})
+	}
+}
+
+// cString converts a NUL-terminated name to a string.
+func cString(b []byte) string {
+	if end := bytes.IndexByte(b, 0); end >= 0 {
+		b = b[:end]
+	}
+	return string(b)
+}
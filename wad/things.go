@@ -0,0 +1,19 @@
+package wad
+
+// Thing types of player starts.
+const (
+	ThingPlayer1Start    = 1
+	ThingPlayer2Start    = 2
+	ThingPlayer3Start    = 3
+	ThingPlayer4Start    = 4
+	ThingDeathmatchStart = 11
+)
+
+// Thing flags.
+const (
+	ThingSkillEasy   = 0x0001 // Present on skills 1 and 2.
+	ThingSkillMedium = 0x0002 // Present on skill 3.
+	ThingSkillHard   = 0x0004 // Present on skills 4 and 5.
+	ThingAmbush      = 0x0008 // Monsters wait until they see or hear the player.
+	ThingMultiplayer = 0x0010 // Present only in multiplayer games.
+)
@@ -0,0 +1,474 @@
+package wad
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// UDMF maps store their data as text in a TEXTMAP lump, which is a list of
+// global assignments and blocks of assignments such as:
+//
+//	namespace = "doom";
+//	vertex { x = 64.0; y = -32.0; }
+//
+// Only the fields of the standard namespaces that have a place in the
+// binary records are read; others are ignored.
+
+// udmfTokenKind is the kind of a token of a TEXTMAP lump.
+type udmfTokenKind int
+
+const (
+	udmfIdentifier udmfTokenKind = iota // Keywords and field names.
+	udmfNumber
+	udmfString
+	udmfPunct // One of "=;{}".
+	udmfEOF
+)
+
+type udmfToken struct {
+	kind udmfTokenKind
+	text string // Strings are unquoted.
+	line int
+}
+
+// udmfBlock is a block of a TEXTMAP lump with its field values by
+// lowercase field name.
+type udmfBlock struct {
+	kind   string
+	line   int
+	fields map[string]udmfToken
+}
+
+// udmfLexer splits a TEXTMAP lump into tokens.
+type udmfLexer struct {
+	data []byte
+	pos  int
+	line int
+}
+
+// next returns the next token, skipping whitespace and comments.
+func (lexer *udmfLexer) next() (udmfToken, error) {
+	if err := lexer.skipSpace(); err != nil {
+		return udmfToken{}, err
+	}
+	if lexer.pos >= len(lexer.data) {
+		return udmfToken{kind: udmfEOF, line: lexer.line}, nil
+	}
+	start := lexer.pos
+	c := lexer.data[lexer.pos]
+	switch {
+	case strings.IndexByte("=;{}", c) >= 0:
+		lexer.pos++
+		return udmfToken{kind: udmfPunct, text: string(c), line: lexer.line}, nil
+	case c == '"':
+		return lexer.quoted()
+	case c == '-' || c == '+' || c == '.' || isDigit(c):
+		lexer.pos++
+		for lexer.pos < len(lexer.data) && isNumberByte(lexer.data[lexer.pos]) {
+			lexer.pos++
+		}
+		return udmfToken{kind: udmfNumber, text: string(lexer.data[start:lexer.pos]), line: lexer.line}, nil
+	case c == '_' || isLetter(c):
+		for lexer.pos < len(lexer.data) && (lexer.data[lexer.pos] == '_' || isLetter(lexer.data[lexer.pos]) || isDigit(lexer.data[lexer.pos])) {
+			lexer.pos++
+		}
+		return udmfToken{kind: udmfIdentifier, text: string(lexer.data[start:lexer.pos]), line: lexer.line}, nil
+	}
+	return udmfToken{}, fmt.Errorf("line %d: unexpected character %q", lexer.line, c)
+}
+
+// skipSpace skips whitespace, line comments, and block comments.
+func (lexer *udmfLexer) skipSpace() error {
+	for lexer.pos < len(lexer.data) {
+		c := lexer.data[lexer.pos]
+		switch {
+		case c == '\n':
+			lexer.line++
+			lexer.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			lexer.pos++
+		case c == '/' && lexer.peek(1) == '/':
+			for lexer.pos < len(lexer.data) && lexer.data[lexer.pos] != '\n' {
+				lexer.pos++
+			}
+		case c == '/' && lexer.peek(1) == '*':
+			line := lexer.line
+			lexer.pos += 2
+			for lexer.pos < len(lexer.data) && !(lexer.data[lexer.pos] == '*' && lexer.peek(1) == '/') {
+				if lexer.data[lexer.pos] == '\n' {
+					lexer.line++
+				}
+				lexer.pos++
+			}
+			if lexer.pos >= len(lexer.data) {
+				return fmt.Errorf("line %d: unterminated comment", line)
+			}
+			lexer.pos += 2
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// quoted reads a string token, resolving backslash escapes.
+func (lexer *udmfLexer) quoted() (udmfToken, error) {
+	line := lexer.line
+	lexer.pos++
+	var text []byte
+	for lexer.pos < len(lexer.data) {
+		c := lexer.data[lexer.pos]
+		lexer.pos++
+		switch c {
+		case '"':
+			return udmfToken{kind: udmfString, text: string(text), line: line}, nil
+		case '\\':
+			if lexer.pos < len(lexer.data) {
+				c = lexer.data[lexer.pos]
+				lexer.pos++
+			}
+		case '\n':
+			lexer.line++
+		}
+		text = append(text, c)
+	}
+	return udmfToken{}, fmt.Errorf("line %d: unterminated string", line)
+}
+
+func (lexer *udmfLexer) peek(offset int) byte {
+	if lexer.pos+offset < len(lexer.data) {
+		return lexer.data[lexer.pos+offset]
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNumberByte(c byte) bool {
+	return isDigit(c) || isLetter(c) || c == '.' || c == '+' || c == '-'
+}
+
+// parseUDMF parses a TEXTMAP lump into its global fields and blocks.
+func parseUDMF(data []byte) (map[string]udmfToken, []udmfBlock, error) {
+	lexer := &udmfLexer{data: data, line: 1}
+	globals := map[string]udmfToken{}
+	blocks := []udmfBlock{}
+	for {
+		token, err := lexer.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if token.kind == udmfEOF {
+			return globals, blocks, nil
+		}
+		if token.kind != udmfIdentifier {
+			return nil, nil, fmt.Errorf("line %d: expected identifier, got %q", token.line, token.text)
+		}
+		next, err := lexer.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case next.kind == udmfPunct && next.text == "=":
+			value, err := parseUDMFValue(lexer)
+			if err != nil {
+				return nil, nil, err
+			}
+			globals[strings.ToLower(token.text)] = value
+		case next.kind == udmfPunct && next.text == "{":
+			block := udmfBlock{kind: strings.ToLower(token.text), line: token.line, fields: map[string]udmfToken{}}
+			if err := parseUDMFBlock(lexer, &block); err != nil {
+				return nil, nil, err
+			}
+			blocks = append(blocks, block)
+		default:
+			return nil, nil, fmt.Errorf("line %d: expected '=' or '{' after %s", next.line, token.text)
+		}
+	}
+}
+
+// parseUDMFBlock parses the assignments of a block up to its closing brace.
+func parseUDMFBlock(lexer *udmfLexer, block *udmfBlock) error {
+	for {
+		token, err := lexer.next()
+		if err != nil {
+			return err
+		}
+		if token.kind == udmfPunct && token.text == "}" {
+			return nil
+		}
+		if token.kind != udmfIdentifier {
+			return fmt.Errorf("line %d: expected field name in %s block, got %q", token.line, block.kind, token.text)
+		}
+		equals, err := lexer.next()
+		if err != nil {
+			return err
+		}
+		if equals.kind != udmfPunct || equals.text != "=" {
+			return fmt.Errorf("line %d: expected '=' after %s", equals.line, token.text)
+		}
+		value, err := parseUDMFValue(lexer)
+		if err != nil {
+			return err
+		}
+		block.fields[strings.ToLower(token.text)] = value
+	}
+}
+
+// parseUDMFValue parses the value of an assignment and its semicolon.
+func parseUDMFValue(lexer *udmfLexer) (udmfToken, error) {
+	value, err := lexer.next()
+	if err != nil {
+		return udmfToken{}, err
+	}
+	if value.kind == udmfPunct || value.kind == udmfEOF {
+		return udmfToken{}, fmt.Errorf("line %d: expected value", value.line)
+	}
+	semicolon, err := lexer.next()
+	if err != nil {
+		return udmfToken{}, err
+	}
+	if semicolon.kind != udmfPunct || semicolon.text != ";" {
+		return udmfToken{}, fmt.Errorf("line %d: expected ';' after value", semicolon.line)
+	}
+	return value, nil
+}
+
+// intField returns the integer value of a field, or def if it is not set.
+// Fractional values are truncated.
+func (block *udmfBlock) intField(name string, def int) (int, error) {
+	value, ok := block.fields[name]
+	if !ok {
+		return def, nil
+	}
+	if value.kind != udmfNumber {
+		return 0, fmt.Errorf("line %d: %s %s: not a number", value.line, block.kind, name)
+	}
+	if i, err := strconv.ParseInt(value.text, 0, 32); err == nil {
+		return int(i), nil
+	}
+	f, err := strconv.ParseFloat(value.text, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("line %d: %s %s: bad number %s", value.line, block.kind, name, value.text)
+	}
+	return int(f), nil
+}
+
+// int16Field returns the value of a field like intField, and fails if the
+// value does not fit the binary records.
+func (block *udmfBlock) int16Field(name string, def int) (int16, error) {
+	i, err := block.intField(name, def)
+	if err != nil {
+		return 0, err
+	}
+	if i < math.MinInt16 || i > math.MaxInt16 {
+		return 0, fmt.Errorf("line %d: %s %s: %d out of range", block.line, block.kind, name, i)
+	}
+	return int16(i), nil
+}
+
+// boolField returns whether a flag field is set to true.
+func (block *udmfBlock) boolField(name string) bool {
+	value, ok := block.fields[name]
+	return ok && value.kind == udmfIdentifier && strings.ToLower(value.text) == "true"
+}
+
+// string8Field returns the value of a texture name field in uppercase, or
+// def if it is not set.
+func (block *udmfBlock) string8Field(name string, def string) String8 {
+	text := def
+	if value, ok := block.fields[name]; ok && value.kind == udmfString {
+		text = value.text
+	}
+	var s String8
+	copy(s[:], strings.ToUpper(text))
+	return s
+}
+
+// udmfLinedefFlags maps the flag fields of a UDMF linedef to the bits of
+// the binary linedef flags.
+var udmfLinedefFlags = []struct {
+	name string
+	flag int16
+}{
+	{"blocking", LinedefBlocking},
+	{"blockmonsters", LinedefBlockMonsters},
+	{"twosided", LinedefTwoSided},
+	{"dontpegtop", LinedefUpperUnpegged},
+	{"dontpegbottom", LinedefLowerUnpegged},
+	{"secret", LinedefSecret},
+	{"blocksound", LinedefBlockSound},
+	{"dontdraw", LinedefNotOnMap},
+	{"mapped", LinedefAlreadyOnMap},
+}
+
+// readUDMF reads a level from the contents of a TEXTMAP lump. UDMF maps
+// have no BSP tree of their own, so the level has no segs, subsectors, or
+// nodes.
+func readUDMF(data []byte) (*Level, error) {
+	globals, blocks, err := parseUDMF(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := globals["namespace"]; !ok {
+		return nil, fmt.Errorf("no namespace")
+	}
+	level := &Level{}
+	for i := range blocks {
+		block := &blocks[i]
+		switch block.kind {
+		case "thing":
+			thing, err := readUDMFThing(block)
+			if err != nil {
+				return nil, err
+			}
+			level.Things = append(level.Things, thing)
+		case "vertex":
+			var vertex Vertex
+			if vertex.XCoord, err = block.int16Field("x", 0); err != nil {
+				return nil, err
+			}
+			if vertex.YCoord, err = block.int16Field("y", 0); err != nil {
+				return nil, err
+			}
+			level.Vertexes = append(level.Vertexes, vertex)
+		case "linedef":
+			linedef, err := readUDMFLinedef(block)
+			if err != nil {
+				return nil, err
+			}
+			level.Linedefs = append(level.Linedefs, linedef)
+		case "sidedef":
+			sidedef, err := readUDMFSidedef(block)
+			if err != nil {
+				return nil, err
+			}
+			level.Sidedefs = append(level.Sidedefs, sidedef)
+		case "sector":
+			sector, err := readUDMFSector(block)
+			if err != nil {
+				return nil, err
+			}
+			level.Sectors = append(level.Sectors, sector)
+		}
+	}
+	return level, nil
+}
+
+func readUDMFThing(block *udmfBlock) (Thing, error) {
+	thing := Thing{HasZ: true}
+	var err error
+	if thing.XPosition, err = block.int16Field("x", 0); err != nil {
+		return thing, err
+	}
+	if thing.YPosition, err = block.int16Field("y", 0); err != nil {
+		return thing, err
+	}
+	if thing.Z, err = block.int16Field("height", 0); err != nil {
+		return thing, err
+	}
+	if thing.Angle, err = block.int16Field("angle", 0); err != nil {
+		return thing, err
+	}
+	if thing.Type, err = block.int16Field("type", 0); err != nil {
+		return thing, err
+	}
+	if block.boolField("skill1") || block.boolField("skill2") {
+		thing.Options |= ThingSkillEasy
+	}
+	if block.boolField("skill3") {
+		thing.Options |= ThingSkillMedium
+	}
+	if block.boolField("skill4") || block.boolField("skill5") {
+		thing.Options |= ThingSkillHard
+	}
+	if block.boolField("ambush") {
+		thing.Options |= ThingAmbush
+	}
+	if !block.boolField("single") && (block.boolField("dm") || block.boolField("coop")) {
+		thing.Options |= ThingMultiplayer
+	}
+	return thing, nil
+}
+
+func readUDMFLinedef(block *udmfBlock) (Linedef, error) {
+	var linedef Linedef
+	var err error
+	if linedef.VertexStart, err = block.int16Field("v1", -1); err != nil {
+		return linedef, err
+	}
+	if linedef.VertexEnd, err = block.int16Field("v2", -1); err != nil {
+		return linedef, err
+	}
+	if linedef.SidedefRight, err = block.int16Field("sidefront", -1); err != nil {
+		return linedef, err
+	}
+	if linedef.SidedefLeft, err = block.int16Field("sideback", -1); err != nil {
+		return linedef, err
+	}
+	if linedef.Function, err = block.int16Field("special", 0); err != nil {
+		return linedef, err
+	}
+	// The Doom namespace keeps the sector tag of a special in its first
+	// argument.
+	if linedef.Tag, err = block.int16Field("arg0", 0); err != nil {
+		return linedef, err
+	}
+	for _, flag := range udmfLinedefFlags {
+		if block.boolField(flag.name) {
+			linedef.Flags |= flag.flag
+		}
+	}
+	return linedef, nil
+}
+
+func readUDMFSidedef(block *udmfBlock) (Sidedef, error) {
+	sidedef := Sidedef{
+		UpperTexture:  block.string8Field("texturetop", "-"),
+		LowerTexture:  block.string8Field("texturebottom", "-"),
+		MiddleTexture: block.string8Field("texturemiddle", "-"),
+	}
+	var err error
+	if sidedef.XOffset, err = block.int16Field("offsetx", 0); err != nil {
+		return sidedef, err
+	}
+	if sidedef.YOffset, err = block.int16Field("offsety", 0); err != nil {
+		return sidedef, err
+	}
+	if sidedef.SectorRef, err = block.int16Field("sector", -1); err != nil {
+		return sidedef, err
+	}
+	return sidedef, nil
+}
+
+func readUDMFSector(block *udmfBlock) (Sector, error) {
+	sector := Sector{
+		Floorpic:   block.string8Field("texturefloor", "-"),
+		Ceilingpic: block.string8Field("textureceiling", "-"),
+	}
+	var err error
+	if sector.FloorHeight, err = block.int16Field("heightfloor", 0); err != nil {
+		return sector, err
+	}
+	if sector.CeilingHeight, err = block.int16Field("heightceiling", 0); err != nil {
+		return sector, err
+	}
+	if sector.Lightlevel, err = block.int16Field("lightlevel", 160); err != nil {
+		return sector, err
+	}
+	if sector.SpecialSector, err = block.int16Field("special", 0); err != nil {
+		return sector, err
+	}
+	if sector.Tag, err = block.int16Field("id", 0); err != nil {
+		return sector, err
+	}
+	return sector, nil
+}
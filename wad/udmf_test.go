@@ -0,0 +1,136 @@
+package wad_test
+
+import (
+	"github.com/penberg/godoom/internal/wadtest"
+	"github.com/penberg/godoom/wad"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadUDMF(t *testing.T) {
+	textmap := `// A level written by a map editor.
+namespace = "doom";
+/* Vertices may have
+   fractional coordinates. */
+vertex { x = 64.5; y = -32.0; }
+Vertex { X = 0x10; Y = 1e2; }
+linedef {
+	v1 = 0; v2 = 1;
+	sidefront = 0; sideback = 1;
+	special = 1; arg0 = 7;
+	blocking = true; twosided = true; dontpegtop = true;
+	secret = false;
+}
+linedef { v1 = 1; v2 = 0; }
+sidedef {
+	sector = 0;
+	offsetx = 8; offsety = -4;
+	texturetop = "startan2"; texturebottom = "BR\"ICK"; texturemiddle = "a;b // c";
+	comment = "ignored } {";
+}
+sidedef { sector = 1; }
+sector {
+	heightfloor = -16; heightceiling = 120;
+	texturefloor = "floor4_8"; textureceiling = "ceil3_5";
+	lightlevel = 192; special = 9; id = 3;
+}
+sector {}
+thing {
+	x = 32.0; y = 48.0; height = 24.0; angle = 90; type = 3004;
+	skill1 = true; skill3 = true; ambush = true; coop = true;
+}
+thing { type = 1; skill4 = true; single = true; dm = true; }
+`
+	level, err := wad.ReadUDMF([]byte(textmap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vertexes := []wad.Vertex{{XCoord: 64, YCoord: -32}, {XCoord: 16, YCoord: 100}}
+	linedefs := []wad.Linedef{
+		{VertexStart: 0, VertexEnd: 1, Flags: wad.LinedefBlocking | wad.LinedefTwoSided | wad.LinedefUpperUnpegged, Function: 1, Tag: 7, SidedefRight: 0, SidedefLeft: 1},
+		{VertexStart: 1, VertexEnd: 0, SidedefRight: -1, SidedefLeft: -1},
+	}
+	sidedefs := []wad.Sidedef{
+		{XOffset: 8, YOffset: -4, UpperTexture: wadtest.Name8("STARTAN2"), LowerTexture: wadtest.Name8("BR\"ICK"), MiddleTexture: wadtest.Name8("A;B // C"), SectorRef: 0},
+		{UpperTexture: wadtest.Name8("-"), LowerTexture: wadtest.Name8("-"), MiddleTexture: wadtest.Name8("-"), SectorRef: 1},
+	}
+	sectors := []wad.Sector{
+		{FloorHeight: -16, CeilingHeight: 120, Floorpic: wadtest.Name8("FLOOR4_8"), Ceilingpic: wadtest.Name8("CEIL3_5"), Lightlevel: 192, SpecialSector: 9, Tag: 3},
+		{Floorpic: wadtest.Name8("-"), Ceilingpic: wadtest.Name8("-"), Lightlevel: 160},
+	}
+	things := []wad.Thing{
+		{XPosition: 32, YPosition: 48, Z: 24, HasZ: true, Angle: 90, Type: 3004, Options: wad.ThingSkillEasy | wad.ThingSkillMedium | wad.ThingAmbush | wad.ThingMultiplayer},
+		{Type: 1, HasZ: true, Options: wad.ThingSkillHard},
+	}
+	if !reflect.DeepEqual(level.Vertexes, vertexes) {
+		t.Errorf("vertexes = %+v, want %+v", level.Vertexes, vertexes)
+	}
+	if !reflect.DeepEqual(level.Linedefs, linedefs) {
+		t.Errorf("linedefs = %+v, want %+v", level.Linedefs, linedefs)
+	}
+	if !reflect.DeepEqual(level.Sidedefs, sidedefs) {
+		t.Errorf("sidedefs = %+v, want %+v", level.Sidedefs, sidedefs)
+	}
+	if !reflect.DeepEqual(level.Sectors, sectors) {
+		t.Errorf("sectors = %+v, want %+v", level.Sectors, sectors)
+	}
+	if !reflect.DeepEqual(level.Things, things) {
+		t.Errorf("things = %+v, want %+v", level.Things, things)
+	}
+	if len(level.Segs) != 0 || len(level.SSectors) != 0 || len(level.Nodes) != 0 {
+		t.Errorf("got %d segs, %d subsectors, and %d nodes, want none", len(level.Segs), len(level.SSectors), len(level.Nodes))
+	}
+}
+
+func TestReadUDMFErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		textmap string
+		want    string
+	}{
+		{"no namespace", `vertex { x = 0; y = 0; }`, "no namespace"},
+		{"unterminated block", "namespace = \"doom\";\nvertex { x = 0;", "line 2: expected field name in vertex block"},
+		{"unterminated string", "namespace = \"doom\";\nsidedef {\ntexturetop = \"STARTAN2; }", "line 3: unterminated string"},
+		{"unterminated comment", "namespace = \"doom\";\n/* vertex { x = 0; }", "line 2: unterminated comment"},
+		{"missing = in block", "namespace = \"doom\";\nvertex { x 0; }", "line 2: expected '=' after x"},
+		{"missing = in global", `namespace "doom";`, "line 1: expected '=' or '{' after namespace"},
+		{"missing semicolon", "namespace = \"doom\";\nvertex { x = 0 }", "line 2: expected ';' after value"},
+		{"missing value", "namespace = \"doom\";\nvertex { x = ; }", "line 2: expected value"},
+		{"bad number", "namespace = \"doom\";\nvertex {\nx = 1.2.3; }", "line 3: vertex x: bad number 1.2.3"},
+		{"string as number", "namespace = \"doom\";\nthing { type = \"1\"; }", "line 2: thing type: not a number"},
+		{"number out of range", "namespace = \"doom\";\nvertex { x = 40000; }", "line 2: vertex x: 40000 out of range"},
+		{"unexpected character", "namespace = \"doom\";\nvertex { x = 0; } #", "line 2: unexpected character '#'"},
+		{"stray value", `namespace = "doom"; 12;`, `line 1: expected identifier, got "12"`},
+	}
+	for _, test := range tests {
+		_, err := wad.ReadUDMF([]byte(test.textmap))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReadUDMFLevel(t *testing.T) {
+	textmap := `namespace = "doom";
+vertex { x = 0.0; y = 0.0; }
+vertex { x = 0.0; y = 64.0; }
+sidedef { sector = 1; }
+linedef { v1 = 0; v2 = 1; sidefront = 0; }
+sector { }
+`
+	lumps := append(wadtest.IWADLumps(), wadtest.Lump{Name: "MAP01", Data: nil}, wadtest.Lump{Name: "TEXTMAP", Data: []byte(textmap)}, wadtest.Lump{Name: "ENDMAP", Data: nil})
+	w := wadtest.MustReadIWAD(t, lumps)
+	_, err := w.ReadLevel("MAP01")
+	want := "level MAP01: invalid level: sidedef 0: sector 1 out of range"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	lumps[len(lumps)-2].Data = []byte(`namespace = "doom"; vertex {`)
+	w = wadtest.MustReadIWAD(t, lumps)
+	_, err = w.ReadLevel("MAP01")
+	want = "level MAP01: TEXTMAP: line 1: expected field name in vertex block"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
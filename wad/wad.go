@@ -0,0 +1,1304 @@
+// Package wad provides access to Doom's data archives also known as WAD files.
+// The file format is documented in The Unofficial DOOM Specs:
+// http://www.gamers.org/dhs/helpdocs/dmsp1666.html
+package wad
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Logger receives progress messages and warnings about lumps that are
+// skipped while reading a WAD archive. It discards them by default.
+var Logger = log.New(io.Discard, "", 0)
+
+type String8 [8]byte
+
+// WAD is a struct that represents Doom's data archive that contains
+// graphics, sounds, and level data. The data is organized as named
+// lumps.
+type WAD struct {
+	header                  *header
+	file                    io.ReaderAt
+	size                    int64
+	pnames                  []String8
+	patches                 map[string]Image
+	TransparentPaletteIndex byte
+	Playpal                 *Playpal
+	textures                map[string]Texture
+	flats                   map[string]Flat
+	levels                  map[string]int
+	lumps                   map[string]int
+	lumpInfos               []lumpInfo
+	iwadLumps               int // Lumps of the IWAD, which come before those of added PWADs.
+	pwads                   []pwad
+}
+
+// pwad is a PWAD file whose lumps were added to a WAD archive.
+type pwad struct {
+	file io.ReaderAt
+	size int64
+}
+
+type header struct {
+	Magic        [4]byte
+	NumLumps     int32
+	InfoTableOfs int32
+}
+
+// lumpInfo is an entry of the lump directory. Lumps of an added PWAD are
+// read from the PWAD's file, so every entry knows the file it belongs to.
+type lumpInfo struct {
+	Filepos int32
+	Size    int32
+	Name    String8
+	file    io.ReaderAt
+}
+
+// lumpRecord is an entry of the lump directory as stored in a WAD file, 16
+// bytes on disk.
+type lumpRecord struct {
+	Filepos int32
+	Size    int32
+	Name    String8
+}
+
+type Texture struct {
+	Header  *TextureHeader
+	Patches []Patch
+}
+
+type TextureHeader struct {
+	TexName         String8
+	Masked          int32
+	Width           int16
+	Height          int16
+	ColumnDirectory int32
+	NumPatches      int16
+}
+
+type Patch struct {
+	XOffset     int16
+	YOffset     int16
+	PNameNumber int16
+	Stepdir     int16
+	ColorMap    int16
+}
+
+type Image struct {
+	Width      int
+	Height     int
+	LeftOffset int // Columns between the left edge and the origin of a picture.
+	TopOffset  int // Rows between the top edge and the origin of a picture.
+	Pixels     []byte
+}
+
+type PictureHeader struct {
+	Width      int16
+	Height     int16
+	LeftOffset int16
+	TopOffset  int16
+}
+
+type Flat struct {
+	Data []byte
+}
+
+type Level struct {
+	Things   []Thing
+	Linedefs []Linedef
+	Sidedefs []Sidedef
+	Vertexes []Vertex
+	Segs     []Seg
+	SSectors []SSector
+	Nodes    []Node
+	Sectors  []Sector
+	Blockmap *Blockmap // Nil if the level has no BLOCKMAP lump.
+
+	subsectorSectors []int // Sector index of every subsector, -1 if unknown.
+}
+
+type Thing struct {
+	XPosition int16
+	YPosition int16
+	Angle     int16
+	Type      int16
+	Options   int16
+	Z         int16 // Height above the floor, only read if HasZ is set.
+	HasZ      bool  // The map format stores the height of things.
+}
+
+// doomThing is a thing record of a Doom-format THINGS lump, 10 bytes on
+// disk.
+type doomThing struct {
+	XPosition int16
+	YPosition int16
+	Angle     int16
+	Type      int16
+	Options   int16
+}
+
+// hexenThing is a thing record of a Hexen-format THINGS lump, 20 bytes on
+// disk.
+type hexenThing struct {
+	TID       int16
+	XPosition int16
+	YPosition int16
+	Z         int16
+	Angle     int16
+	Type      int16
+	Options   int16
+	Special   uint8
+	Args      [5]uint8
+}
+
+// Linedef is a record of the LINEDEFS lump, 14 bytes on disk.
+type Linedef struct {
+	VertexStart  int16
+	VertexEnd    int16
+	Flags        int16
+	Function     int16
+	Tag          int16
+	SidedefRight int16
+	SidedefLeft  int16
+}
+
+// Linedef flags.
+const (
+	LinedefBlocking      = 0x0001 // Blocks players and monsters.
+	LinedefBlockMonsters = 0x0002 // Blocks monsters.
+	LinedefTwoSided      = 0x0004 // Has a sidedef on both sides.
+	LinedefUpperUnpegged = 0x0008 // Upper texture is drawn from the top down.
+	LinedefLowerUnpegged = 0x0010 // Lower texture is drawn from the bottom up.
+	LinedefSecret        = 0x0020 // Drawn as a one-sided wall on the automap.
+	LinedefBlockSound    = 0x0040 // Blocks sound propagation.
+	LinedefNotOnMap      = 0x0080 // Never drawn on the automap.
+	LinedefAlreadyOnMap  = 0x0100 // Drawn on the automap before it is seen.
+)
+
+// Sidedef is a record of the SIDEDEFS lump, 30 bytes on disk.
+type Sidedef struct {
+	XOffset       int16
+	YOffset       int16
+	UpperTexture  String8
+	LowerTexture  String8
+	MiddleTexture String8
+	SectorRef     int16
+}
+
+// Vertex is a record of the VERTEXES lump, 4 bytes on disk.
+type Vertex struct {
+	XCoord int16
+	YCoord int16
+}
+
+// Seg is a record of the SEGS lump, 12 bytes on disk.
+type Seg struct {
+	VertexStart int16
+	VertexEnd   int16
+	Bams        int16
+	LineNum     int16
+	Segside     int16
+	Segoffset   int16
+}
+
+// SSector is a record of the SSECTORS lump, 4 bytes on disk.
+type SSector struct {
+	Numsegs  int16
+	StartSeg int16
+}
+
+// BBox is a bounding box in map coordinates, 8 bytes on disk.
+type BBox struct {
+	Top    int16
+	Bottom int16
+	Left   int16
+	Right  int16
+}
+
+// Node is a record of the NODES lump, 28 bytes on disk. Its bounding boxes
+// are decoded field by field like the rest of the record.
+type Node struct {
+	X     int16
+	Y     int16
+	DX    int16
+	DY    int16
+	BBox  [2]BBox
+	Child [2]int16
+}
+
+// Sector is a record of the SECTORS lump, 26 bytes on disk.
+type Sector struct {
+	FloorHeight   int16
+	CeilingHeight int16
+	Floorpic      String8
+	Ceilingpic    String8
+	Lightlevel    int16
+	SpecialSector int16
+	Tag           int16
+}
+
+// Sector specials.
+const (
+	SectorDamageHellslime      = 4  // 20 damage and blinking light.
+	SectorDamageNukage         = 5  // 10 damage.
+	SectorDamageSlime          = 7  // 5 damage.
+	SectorSecret               = 9  // Counts towards the secrets found on the level.
+	SectorDamageEnd            = 11 // 20 damage, ends the level when health is low.
+	SectorDamageSuperHellslime = 16 // 20 damage.
+)
+
+// DamagePerTic returns the damage that the floor of a sector deals to a
+// player standing on it on a damage tic, or zero if the floor is harmless.
+// Doom has a damage tic once every 32 tics.
+func (sector *Sector) DamagePerTic() int {
+	switch sector.SpecialSector {
+	case SectorDamageSlime:
+		return 5
+	case SectorDamageNukage:
+		return 10
+	case SectorDamageHellslime, SectorDamageEnd, SectorDamageSuperHellslime:
+		return 20
+	}
+	return 0
+}
+
+type Reject struct {
+}
+
+type RGB struct {
+	Red   uint8
+	Green uint8
+	Blue  uint8
+}
+
+type Palette struct {
+	Table [256]RGB
+}
+
+// NumPalettes is the number of palettes in PLAYPAL: the normal palette
+// followed by the damage, item pickup and radiation suit tints.
+const NumPalettes = 14
+
+type Playpal struct {
+	Palettes [NumPalettes]Palette
+}
+
+// BamsToRadians converts a binary angle measurement, where the full circle
+// spans the 16-bit range, to radians.
+func BamsToRadians(bams int16) float64 {
+	return float64(uint16(bams)) * 2 * math.Pi / 65536
+}
+
+// ToString converts a NUL-padded lump or texture name to a string.
+func ToString(s String8) string {
+	var i int
+	for i = 0; i < len(s); i++ {
+		if s[i] == 0 {
+			break
+		}
+	}
+	return string(s[:i])
+}
+
+// ReadWAD reads WAD metadata to memory. It returns a WAD object that
+// can be used to read individual lumps.
+func ReadWAD(filename string) (*WAD, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	wad, err := ReadWADContext(context.Background(), file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return wad, nil
+}
+
+// ReadWADContext reads WAD metadata from the first size bytes of r like
+// ReadWAD. Reading stops early with the context's error if ctx is
+// cancelled between lumps.
+func ReadWADContext(ctx context.Context, r io.ReaderAt, size int64) (*WAD, error) {
+	wad := &WAD{
+		file: r,
+		size: size,
+	}
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if string(header.Magic[:]) != "IWAD" {
+		return nil, fmt.Errorf("bad magic: %s\n", header.Magic)
+	}
+	wad.header = header
+	lumpInfos, err := readDirectory(r, size, header)
+	if err != nil {
+		return nil, err
+	}
+	wad.lumpInfos = lumpInfos
+	wad.iwadLumps = len(lumpInfos)
+	wad.indexLumps()
+	playpal, err := wad.readPlaypal()
+	if err != nil {
+		return nil, err
+	}
+	wad.Playpal = playpal
+	wad.TransparentPaletteIndex = 255
+	pnames, err := wad.readPatchNames()
+	if err != nil {
+		return nil, err
+	}
+	wad.pnames = pnames
+	patches, err := wad.readPatchLumps(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wad.patches = patches
+	textures, err := wad.readTextureLumps(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wad.textures = textures
+	flats, err := wad.readFlatLumps(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wad.flats = flats
+	return wad, nil
+}
+
+// AddPWAD adds the lumps of a PWAD file to the archive like
+// AddPWADReader.
+func (w *WAD) AddPWAD(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.AddPWADReader(file, info.Size()); err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	return nil
+}
+
+// AddPWADReader adds the lumps of a PWAD in the first size bytes of r to
+// the archive. Its lumps replace lumps of the same name, and its levels are
+// added to the levels of the archive or replace them. Wall textures,
+// flats, patches, and the palette are still the IWAD's.
+func (w *WAD) AddPWADReader(r io.ReaderAt, size int64) error {
+	header, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	if string(header.Magic[:]) != "PWAD" {
+		return fmt.Errorf("bad magic: %s", header.Magic)
+	}
+	lumpInfos, err := readDirectory(r, size, header)
+	if err != nil {
+		return err
+	}
+	w.lumpInfos = append(w.lumpInfos, lumpInfos...)
+	w.pwads = append(w.pwads, pwad{file: r, size: size})
+	w.indexLumps()
+	return nil
+}
+
+func readHeader(file io.ReaderAt) (*header, error) {
+	var header header
+	data, err := readFrom(file, "header", 0, binary.Size(&header))
+	if err != nil {
+		return nil, err
+	}
+	if err := decode(data, &header); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// readDirectory reads the lump directory of a WAD file of size bytes.
+func readDirectory(file io.ReaderAt, size int64, header *header) ([]lumpInfo, error) {
+	if header.NumLumps < 0 {
+		return nil, fmt.Errorf("bad lump count %d", header.NumLumps)
+	}
+	// Check the lump count against the file before allocating the
+	// directory, so that a corrupt count cannot exhaust memory.
+	recordSize := int64(binary.Size(lumpRecord{}))
+	if header.InfoTableOfs < 0 || int64(header.InfoTableOfs) > size || int64(header.NumLumps)*recordSize > size-int64(header.InfoTableOfs) {
+		return nil, fmt.Errorf("lump directory: %d lumps at offset %d do not fit in %d bytes", header.NumLumps, header.InfoTableOfs, size)
+	}
+	records := make([]lumpRecord, header.NumLumps, header.NumLumps)
+	data, err := readFrom(file, "lump directory", int64(header.InfoTableOfs), binary.Size(records))
+	if err != nil {
+		return nil, err
+	}
+	if err := decode(data, records); err != nil {
+		return nil, err
+	}
+	lumpInfos := make([]lumpInfo, len(records), len(records))
+	for i, record := range records {
+		lumpInfos[i] = lumpInfo{Filepos: record.Filepos, Size: record.Size, Name: record.Name, file: file}
+	}
+	return lumpInfos, nil
+}
+
+// indexLumps looks up lumps and levels by name in the lump directory. Later
+// lumps of the same name, such as those of PWADs, win.
+func (w *WAD) indexLumps() {
+	lumps := map[string]int{}
+	levels := map[string]int{}
+	for i := range w.lumpInfos {
+		lumpInfo := w.lumpInfos[i]
+		// Binary maps start with THINGS and UDMF maps with TEXTMAP.
+		if name := ToString(lumpInfo.Name); i > 0 && (name == "THINGS" || name == "TEXTMAP") {
+			levelIdx := i - 1
+			levelLump := w.lumpInfos[levelIdx]
+			levels[ToString(levelLump.Name)] = levelIdx
+		}
+		lumps[ToString(lumpInfo.Name)] = i
+	}
+	w.levels = levels
+	w.lumps = lumps
+}
+
+// requireLump returns the index of a lump that the WAD archive must have.
+// A missing lump is an error rather than index zero, which would silently
+// read the first lump instead.
+func (w *WAD) requireLump(name string) (int, error) {
+	lumpIdx, ok := w.lumps[name]
+	if !ok {
+		return 0, fmt.Errorf("lump %s not found", name)
+	}
+	return lumpIdx, nil
+}
+
+func (w *WAD) readPlaypal() (*Playpal, error) {
+	playpalLump, err := w.requireLump("PLAYPAL")
+	if err != nil {
+		return nil, err
+	}
+	Logger.Printf("Loading palette ...\n")
+	playpal := Playpal{}
+	if err := w.readRecords(&w.lumpInfos[playpalLump], &playpal); err != nil {
+		return nil, err
+	}
+	return &playpal, nil
+}
+
+func (w *WAD) readPatchNames() ([]String8, error) {
+	pnamesLump, err := w.requireLump("PNAMES")
+	if err != nil {
+		return nil, err
+	}
+	lump, err := w.newLumpDecoder(pnamesLump)
+	if err != nil {
+		return nil, err
+	}
+	var count uint32
+	if err := lump.decode(0, &count); err != nil {
+		return nil, err
+	}
+	Logger.Printf("Loading %d patches ...\n", count)
+	if int64(count)*8 > int64(len(lump.data)) {
+		return nil, lump.truncated(4 + int64(count)*8)
+	}
+	pnames := make([]String8, count, count)
+	if err := lump.decode(4, pnames); err != nil {
+		return nil, err
+	}
+	// Lumps are looked up by upper-case name, like W_CheckNumForName does,
+	// but some PNAMES lumps, such as DOOM II's, list names in lower case.
+	for i := range pnames {
+		copy(pnames[i][:], bytes.ToUpper(pnames[i][:]))
+	}
+	return pnames, nil
+}
+
+func (w *WAD) readPatchLumps(ctx context.Context) (map[string]Image, error) {
+	patches := make(map[string]Image)
+	for _, pname := range w.pnames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		lumpIdx, ok := w.lumps[ToString(pname)]
+		if !ok {
+			Logger.Printf("warning: Patch %s not found\n", ToString(pname))
+			continue
+		}
+		lump, err := w.readLump(lumpIdx)
+		if err != nil {
+			return nil, err
+		}
+		image, err := w.decodePicture(lump)
+		if err != nil {
+			Logger.Printf("warning: Patch %s: %s\n", ToString(pname), err)
+			continue
+		}
+		patches[ToString(pname)] = *image
+	}
+	return patches, nil
+}
+
+// readLump reads the data of a lump.
+func (w *WAD) readLump(lumpIdx int) ([]byte, error) {
+	lumpInfo := w.lumpInfos[lumpIdx]
+	return readFrom(lumpInfo.file, "lump "+ToString(lumpInfo.Name), int64(lumpInfo.Filepos), int(lumpInfo.Size))
+}
+
+// readFrom reads size bytes at an offset of a WAD file. The error names
+// what was read and how many bytes the file actually has if it ends too
+// early.
+func readFrom(file io.ReaderAt, what string, offset int64, size int) ([]byte, error) {
+	if offset < 0 || size < 0 {
+		return nil, fmt.Errorf("%s: bad offset %d or size %d", what, offset, size)
+	}
+	data := make([]byte, size, size)
+	n, err := file.ReadAt(data, offset)
+	if n == size {
+		return data, nil
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %s", what, err)
+	}
+	return nil, fmt.Errorf("%s: got %d bytes, want %d", what, n, size)
+}
+
+// decode decodes little-endian binary data into v, which must have the
+// same size as the data.
+func decode(data []byte, v interface{}) error {
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, v)
+}
+
+// lumpDecoder decodes structures at offsets of a lump and reports reads
+// past the end of the lump.
+type lumpDecoder struct {
+	name string
+	data []byte
+}
+
+func (w *WAD) newLumpDecoder(lumpIdx int) (*lumpDecoder, error) {
+	data, err := w.readLump(lumpIdx)
+	if err != nil {
+		return nil, err
+	}
+	return &lumpDecoder{name: ToString(w.lumpInfos[lumpIdx].Name), data: data}, nil
+}
+
+// decode decodes v from an offset of the lump.
+func (lump *lumpDecoder) decode(offset int64, v interface{}) error {
+	end := offset + int64(binary.Size(v))
+	if offset < 0 || end > int64(len(lump.data)) {
+		return lump.truncated(end)
+	}
+	return decode(lump.data[offset:end], v)
+}
+
+// truncated returns an error for a lump that is shorter than size bytes.
+func (lump *lumpDecoder) truncated(size int64) error {
+	return fmt.Errorf("lump %s: got %d bytes, want %d", lump.name, len(lump.data), size)
+}
+
+// readRecords reads a lump into v, which is a record or a slice of
+// records that fills the lump.
+func (w *WAD) readRecords(lumpInfo *lumpInfo, v interface{}) error {
+	size := binary.Size(v)
+	if int(lumpInfo.Size) < size {
+		return fmt.Errorf("lump %s: got %d bytes, want %d", ToString(lumpInfo.Name), lumpInfo.Size, size)
+	}
+	data, err := readFrom(lumpInfo.file, "lump "+ToString(lumpInfo.Name), int64(lumpInfo.Filepos), size)
+	if err != nil {
+		return err
+	}
+	return decode(data, v)
+}
+
+// decodePicture decodes a lump in the column-based picture format used by
+// patches and sprites. Pixels that are not covered by any post are set to
+// the transparent palette index.
+func (w *WAD) decodePicture(lump []byte) (*Image, error) {
+	reader := bytes.NewBuffer(lump[0:])
+	var header PictureHeader
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Width <= 0 || header.Height <= 0 || header.Width > 4096 || header.Height > 4096 {
+		return nil, fmt.Errorf("invalid picture size %dx%d", header.Width, header.Height)
+	}
+	offsets := make([]int32, header.Width, header.Width)
+	if err := binary.Read(reader, binary.LittleEndian, offsets); err != nil {
+		return nil, err
+	}
+	size := int(header.Width) * int(header.Height)
+	pixels := make([]byte, size, size)
+	for y := 0; y < int(header.Height); y++ {
+		for x := 0; x < int(header.Width); x++ {
+			pixels[y*int(header.Width)+x] = w.TransparentPaletteIndex
+		}
+	}
+	for columnIndex, offset := range offsets {
+		for {
+			if offset < 0 || int(offset) >= len(lump) {
+				return nil, fmt.Errorf("column %d out of bounds", columnIndex)
+			}
+			rowStart := lump[offset]
+			offset += 1
+			if rowStart == 255 {
+				break
+			}
+			if int(offset)+2 > len(lump) {
+				return nil, fmt.Errorf("column %d truncated", columnIndex)
+			}
+			numPixels := lump[offset]
+			offset += 1
+			offset += 1 /* Padding */
+			if int(offset)+int(numPixels) > len(lump) {
+				return nil, fmt.Errorf("column %d truncated", columnIndex)
+			}
+			for i := 0; i < int(numPixels); i++ {
+				row := int(rowStart) + i
+				if row < int(header.Height) {
+					pixels[row*int(header.Width)+columnIndex] = lump[offset]
+				}
+				offset += 1
+			}
+			offset += 1 /* Padding */
+		}
+	}
+	return &Image{
+		Width:      int(header.Width),
+		Height:     int(header.Height),
+		LeftOffset: int(header.LeftOffset),
+		TopOffset:  int(header.TopOffset),
+		Pixels:     pixels,
+	}, nil
+}
+
+func (w *WAD) readTextureLumps(ctx context.Context) (map[string]Texture, error) {
+	// Every IWAD has TEXTURE1, but only registered and later IWADs have
+	// TEXTURE2.
+	texture1, err := w.requireLump("TEXTURE1")
+	if err != nil {
+		return nil, err
+	}
+	textureLumps := []int{texture1}
+	if lump, ok := w.lumps["TEXTURE2"]; ok {
+		textureLumps = append(textureLumps, lump)
+	}
+	textures := make(map[string]Texture)
+	for _, i := range textureLumps {
+		lump, err := w.newLumpDecoder(i)
+		if err != nil {
+			return nil, err
+		}
+		var count uint32
+		if err := lump.decode(0, &count); err != nil {
+			return nil, err
+		}
+		Logger.Printf("Loading %d textures ...\n", count)
+		if int64(count)*4 > int64(len(lump.data)) {
+			return nil, lump.truncated(4 + int64(count)*4)
+		}
+		offsets := make([]int32, count, count)
+		if err := lump.decode(4, offsets); err != nil {
+			return nil, err
+		}
+		for _, offset := range offsets {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			var header TextureHeader
+			if err := lump.decode(int64(offset), &header); err != nil {
+				return nil, err
+			}
+			name := ToString(header.TexName)
+			if header.NumPatches < 0 {
+				return nil, fmt.Errorf("texture %s: bad patch count %d", name, header.NumPatches)
+			}
+			// Check the declared patch count against the lump before
+			// allocating the patches.
+			patchesOffset := int64(offset) + int64(binary.Size(&header))
+			patchesEnd := patchesOffset + int64(header.NumPatches)*int64(binary.Size(Patch{}))
+			if patchesEnd > int64(len(lump.data)) {
+				return nil, fmt.Errorf("texture %s: %d patches: %s", name, header.NumPatches, lump.truncated(patchesEnd))
+			}
+			patches := make([]Patch, header.NumPatches, header.NumPatches)
+			if err := lump.decode(patchesOffset, patches); err != nil {
+				return nil, err
+			}
+			for i, patch := range patches {
+				if patch.PNameNumber < 0 || int(patch.PNameNumber) >= len(w.pnames) {
+					return nil, fmt.Errorf("texture %s: patch %d: patch number %d out of range (%d in PNAMES)", name, i, patch.PNameNumber, len(w.pnames))
+				}
+			}
+			texture := Texture{Header: &header, Patches: patches}
+			textures[name] = texture
+		}
+	}
+	return textures, nil
+}
+
+func (w *WAD) readFlatLumps(ctx context.Context) (map[string]Flat, error) {
+	flats := make(map[string]Flat)
+	startLump, endLump, err := w.markerRange("F", "FF")
+	if err != nil {
+		return nil, err
+	}
+	for i := startLump + 1; i < endLump; i++ {
+		lumpInfo := w.lumpInfos[i]
+		// Skip the F1_START style sub-markers, which have no data.
+		if lumpInfo.Size == 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := w.readLump(i)
+		if err != nil {
+			return nil, err
+		}
+		flats[ToString(lumpInfo.Name)] = Flat{Data: data}
+	}
+	return flats, nil
+}
+
+// markerRange returns the lumps of the start and end markers of a section.
+// PWADs often use doubled marker names such as FF_START, so every given
+// marker prefix is tried for both ends in order. Sections are only read
+// from the IWAD, so markers of added PWADs are ignored, and the end marker
+// is the first one after the start marker.
+func (w *WAD) markerRange(prefixes ...string) (int, int, error) {
+	find := func(suffix string, from int) (int, error) {
+		for _, prefix := range prefixes {
+			for i := from; i < w.iwadLumps; i++ {
+				if ToString(w.lumpInfos[i].Name) == prefix+suffix {
+					return i, nil
+				}
+			}
+		}
+		return 0, fmt.Errorf("%s%s not found", prefixes[0], suffix)
+	}
+	start, err := find("_START", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := find("_END", start+1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// Checksum returns the SHA-1 checksum of the WAD archive and the PWADs
+// added to it.
+func (w *WAD) Checksum() ([]byte, error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(w.file, 0, w.size)); err != nil {
+		return nil, err
+	}
+	for _, pwad := range w.pwads {
+		if _, err := io.Copy(h, io.NewSectionReader(pwad.file, 0, pwad.size)); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// ModTime returns the latest modification time of the WAD archive and the
+// PWADs added to it. It fails for archives that were not read from a file.
+func (w *WAD) ModTime() (time.Time, error) {
+	latest := time.Time{}
+	files := []io.ReaderAt{w.file}
+	for _, pwad := range w.pwads {
+		files = append(files, pwad.file)
+	}
+	for _, r := range files {
+		file, ok := r.(*os.File)
+		if !ok {
+			return time.Time{}, fmt.Errorf("modification time not available")
+		}
+		info, err := file.Stat()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (w *WAD) LoadTexture(texname string) (*Texture, error) {
+	texture := w.textures[texname]
+	return &texture, nil
+}
+
+func (w *WAD) LoadImage(pnameNumber int16) (*Image, error) {
+	if pnameNumber < 0 || int(pnameNumber) >= len(w.pnames) {
+		return nil, fmt.Errorf("patch number %d out of range", pnameNumber)
+	}
+	pname := ToString(w.pnames[pnameNumber])
+	image, ok := w.patches[pname]
+	if !ok {
+		return nil, fmt.Errorf("patch %s not found", pname)
+	}
+	return &image, nil
+}
+
+// PatchName returns the name of a patch in PNAMES.
+func (w *WAD) PatchName(pnameNumber int16) (string, error) {
+	if pnameNumber < 0 || int(pnameNumber) >= len(w.pnames) {
+		return "", fmt.Errorf("patch number %d out of range", pnameNumber)
+	}
+	return ToString(w.pnames[pnameNumber]), nil
+}
+
+// PatchCount returns the number of patches in PNAMES.
+func (w *WAD) PatchCount() int {
+	return len(w.pnames)
+}
+
+// LumpIndex returns the index of the lump with the given name in the WAD
+// directory.
+func (w *WAD) LumpIndex(name string) (int, bool) {
+	lumpIdx, ok := w.lumps[name]
+	return lumpIdx, ok
+}
+
+func (w *WAD) LoadFlat(flatname string) (*Flat, error) {
+	flat := w.flats[flatname]
+	return &flat, nil
+}
+
+// HasLump returns true if the WAD archive has a lump with the given name.
+func (w *WAD) HasLump(name string) bool {
+	_, ok := w.lumps[name]
+	return ok
+}
+
+// LevelNames returns an array of level names found in the WAD archive.
+func (w *WAD) LevelNames() []string {
+	result := []string{}
+	for name := range w.levels {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// TextureNames returns a sorted array of wall texture names found in the
+// WAD archive.
+func (w *WAD) TextureNames() []string {
+	result := []string{}
+	for name := range w.textures {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// FlatNames returns a sorted array of flat names found in the WAD archive.
+func (w *WAD) FlatNames() []string {
+	result := []string{}
+	for name := range w.flats {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// SpriteNames returns a sorted array of sprite lump names found between
+// the S_START and S_END markers of the WAD archive.
+func (w *WAD) SpriteNames() ([]string, error) {
+	startLump, endLump, err := w.markerRange("S", "SS")
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for i := startLump + 1; i < endLump; i++ {
+		lumpInfo := w.lumpInfos[i]
+		if lumpInfo.Size == 0 {
+			continue
+		}
+		result = append(result, ToString(lumpInfo.Name))
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// LoadPicture reads and decodes a lump in picture format, such as a sprite
+// or an intermission background.
+func (w *WAD) LoadPicture(name string) (*Image, error) {
+	lumpIdx, ok := w.lumps[name]
+	if !ok {
+		return nil, fmt.Errorf("picture %s not found", name)
+	}
+	lump, err := w.readLump(lumpIdx)
+	if err != nil {
+		return nil, err
+	}
+	image, err := w.decodePicture(lump)
+	if err != nil {
+		return nil, fmt.Errorf("picture %s: %s", name, err)
+	}
+	return image, nil
+}
+
+// levelLumps are the lumps of a binary map, which follow its marker lump.
+var levelLumps = map[string]bool{
+	"THINGS":   true,
+	"LINEDEFS": true,
+	"SIDEDEFS": true,
+	"VERTEXES": true,
+	"SEGS":     true,
+	"SSECTORS": true,
+	"NODES":    true,
+	"SECTORS":  true,
+	"REJECT":   true,
+	"BLOCKMAP": true,
+}
+
+// ReadLevel reads level data from WAD archive and returns a Level struct.
+func (w *WAD) ReadLevel(name string) (*Level, error) {
+	return w.ReadLevelContext(context.Background(), name)
+}
+
+// ReadLevelContext reads level data like ReadLevel. Reading stops early
+// with the context's error if ctx is cancelled between lumps.
+func (w *WAD) ReadLevelContext(ctx context.Context, name string) (*Level, error) {
+	levelIdx, ok := w.levels[name]
+	if !ok {
+		return nil, fmt.Errorf("level %s not found", name)
+	}
+	if ToString(w.lumpInfos[levelIdx+1].Name) == "TEXTMAP" {
+		return w.readUDMFLevel(name, levelIdx+1)
+	}
+	level := Level{}
+	hexen := w.hexenLevel(levelIdx)
+	for i := levelIdx + 1; i < len(w.lumpInfos); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		lumpInfo := w.lumpInfos[i]
+		name := ToString(lumpInfo.Name)
+		if !levelLumps[name] {
+			break
+		}
+		switch name {
+		case "THINGS":
+			things, err := w.readThings(&lumpInfo, hexen)
+			if err != nil {
+				return nil, err
+			}
+			level.Things = things
+		case "SIDEDEFS":
+			sidedefs, err := w.readSidedefs(&lumpInfo)
+			if err != nil {
+				return nil, err
+			}
+			level.Sidedefs = sidedefs
+		case "LINEDEFS":
+			linedefs, err := w.readLinedefs(&lumpInfo)
+			if err != nil {
+				return nil, err
+			}
+			level.Linedefs = linedefs
+		case "VERTEXES":
+			vertexes, err := w.readVertexes(&lumpInfo)
+			if err != nil {
+				return nil, err
+			}
+			level.Vertexes = vertexes
+		case "SEGS":
+			segs, err := w.readSegs(&lumpInfo)
+			if err != nil {
+				return nil, err
+			}
+			level.Segs = segs
+		case "SSECTORS":
+			ssectors, err := w.readSSectors(&lumpInfo)
+			if err != nil {
+				return nil, err
+			}
+			level.SSectors = ssectors
+		case "NODES":
+			nodes, err := w.readNodes(&lumpInfo)
+			if err != nil {
+				return nil, err
+			}
+			level.Nodes = nodes
+		case "SECTORS":
+			sectors, err := w.readSectors(&lumpInfo)
+			if err != nil {
+				return nil, err
+			}
+			level.Sectors = sectors
+		case "BLOCKMAP":
+			data, err := readFrom(lumpInfo.file, "lump BLOCKMAP", int64(lumpInfo.Filepos), int(lumpInfo.Size))
+			if err != nil {
+				return nil, err
+			}
+			// The game only uses the blockmap to speed up queries, so a
+			// broken one, as overflowing blockmaps of huge maps often are,
+			// is dropped rather than failing the level.
+			blockmap, err := parseBlockmap(data)
+			if err != nil {
+				Logger.Printf("warning: Blockmap ignored: %s\n", err)
+				continue
+			}
+			level.Blockmap = blockmap
+		default:
+			Logger.Printf("Unhandled lump %s\n", name)
+		}
+	}
+	if err := level.Validate(); err != nil {
+		return nil, fmt.Errorf("level %s: %s", name, err)
+	}
+	if level.Blockmap != nil {
+		if err := level.Blockmap.validate(len(level.Linedefs)); err != nil {
+			Logger.Printf("warning: Blockmap ignored: %s\n", err)
+			level.Blockmap = nil
+		}
+	}
+	level.indexSubsectorSectors()
+	return &level, nil
+}
+
+// readUDMFLevel reads a level from the TEXTMAP lump of a UDMF map. The
+// nodes of UDMF maps are in a ZNODES lump, which is not read yet, so the
+// level has things and map geometry but cannot be drawn.
+func (w *WAD) readUDMFLevel(name string, lumpIdx int) (*Level, error) {
+	data, err := w.readLump(lumpIdx)
+	if err != nil {
+		return nil, err
+	}
+	level, err := readUDMF(data)
+	if err != nil {
+		return nil, fmt.Errorf("level %s: TEXTMAP: %s", name, err)
+	}
+	if err := level.Validate(); err != nil {
+		return nil, fmt.Errorf("level %s: %s", name, err)
+	}
+	level.indexSubsectorSectors()
+	return level, nil
+}
+
+// maxValidationErrors is the number of violations reported by Validate.
+const maxValidationErrors = 5
+
+// Validate checks that all references between the level's records are in
+// range. It returns an error that lists the first few violations.
+func (level *Level) Validate() error {
+	violations := []string{}
+	violation := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+	inRange := func(idx int16, count int) bool {
+		return idx >= 0 && int(idx) < count
+	}
+	for i, sidedef := range level.Sidedefs {
+		if !inRange(sidedef.SectorRef, len(level.Sectors)) {
+			violation("sidedef %d: sector %d out of range", i, sidedef.SectorRef)
+		}
+	}
+	for i, linedef := range level.Linedefs {
+		if !inRange(linedef.VertexStart, len(level.Vertexes)) {
+			violation("linedef %d: start vertex %d out of range", i, linedef.VertexStart)
+		}
+		if !inRange(linedef.VertexEnd, len(level.Vertexes)) {
+			violation("linedef %d: end vertex %d out of range", i, linedef.VertexEnd)
+		}
+		if !inRange(linedef.SidedefRight, len(level.Sidedefs)) {
+			violation("linedef %d: right sidedef %d out of range", i, linedef.SidedefRight)
+		}
+		if linedef.SidedefLeft != -1 && !inRange(linedef.SidedefLeft, len(level.Sidedefs)) {
+			violation("linedef %d: left sidedef %d out of range", i, linedef.SidedefLeft)
+		}
+	}
+	for i, seg := range level.Segs {
+		if !inRange(seg.VertexStart, len(level.Vertexes)) {
+			violation("seg %d: start vertex %d out of range", i, seg.VertexStart)
+		}
+		if !inRange(seg.VertexEnd, len(level.Vertexes)) {
+			violation("seg %d: end vertex %d out of range", i, seg.VertexEnd)
+		}
+		if !inRange(seg.LineNum, len(level.Linedefs)) {
+			violation("seg %d: linedef %d out of range", i, seg.LineNum)
+		}
+	}
+	for i, ssector := range level.SSectors {
+		if ssector.StartSeg < 0 || ssector.Numsegs < 0 || int(ssector.StartSeg)+int(ssector.Numsegs) > len(level.Segs) {
+			violation("subsector %d: segs %d+%d out of range", i, ssector.StartSeg, ssector.Numsegs)
+		}
+	}
+	// Node builders write the children of a node before it, so that the
+	// root is the last node. Requiring that also rules out cycles, which
+	// would make descending the tree loop forever.
+	for i, node := range level.Nodes {
+		for side, child := range node.Child {
+			idx := int(uint16(child))
+			if idx&SubsectorBit == SubsectorBit {
+				if ssectorId := idx &^ SubsectorBit; ssectorId >= len(level.SSectors) {
+					violation("node %d: child %d subsector %d out of range", i, side, ssectorId)
+				}
+			} else if idx >= i {
+				violation("node %d: child %d node %d is not before it", i, side, idx)
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	more := ""
+	if len(violations) > maxValidationErrors {
+		more = fmt.Sprintf(" (and %d more)", len(violations)-maxValidationErrors)
+		violations = violations[:maxValidationErrors]
+	}
+	return fmt.Errorf("invalid level: %s%s", strings.Join(violations, "; "), more)
+}
+
+// hexenLevel returns true if the binary map at levelIdx is in the Hexen
+// format, whose map lumps are followed by a BEHAVIOR lump with its scripts.
+// Maps of PWADs may leave out lumps such as REJECT and BLOCKMAP, so the
+// BEHAVIOR lump is looked for after whichever map lumps there are.
+func (w *WAD) hexenLevel(levelIdx int) bool {
+	for i := levelIdx + 1; i < len(w.lumpInfos); i++ {
+		name := ToString(w.lumpInfos[i].Name)
+		if !levelLumps[name] {
+			return name == "BEHAVIOR"
+		}
+	}
+	return false
+}
+
+// readThings reads the things of a Doom-format or, if hexen is set, a
+// Hexen-format THINGS lump.
+func (w *WAD) readThings(lumpInfo *lumpInfo, hexen bool) ([]Thing, error) {
+	if hexen {
+		var record hexenThing
+		count := int(lumpInfo.Size) / binary.Size(record)
+		records := make([]hexenThing, count, count)
+		if err := w.readRecords(lumpInfo, records); err != nil {
+			return nil, err
+		}
+		things := make([]Thing, count, count)
+		for i, record := range records {
+			things[i] = Thing{
+				XPosition: record.XPosition,
+				YPosition: record.YPosition,
+				Angle:     record.Angle,
+				Type:      record.Type,
+				Options:   record.Options,
+				Z:         record.Z,
+				HasZ:      true,
+			}
+		}
+		return things, nil
+	}
+	var record doomThing
+	count := int(lumpInfo.Size) / binary.Size(record)
+	records := make([]doomThing, count, count)
+	if err := w.readRecords(lumpInfo, records); err != nil {
+		return nil, err
+	}
+	things := make([]Thing, count, count)
+	for i, record := range records {
+		things[i] = Thing{
+			XPosition: record.XPosition,
+			YPosition: record.YPosition,
+			Angle:     record.Angle,
+			Type:      record.Type,
+			Options:   record.Options,
+		}
+	}
+	return things, nil
+}
+
+func (w *WAD) readLinedefs(lumpInfo *lumpInfo) ([]Linedef, error) {
+	var linedef Linedef
+	count := int(lumpInfo.Size) / binary.Size(linedef)
+	linedefs := make([]Linedef, count, count)
+	if err := w.readRecords(lumpInfo, linedefs); err != nil {
+		return nil, err
+	}
+	return linedefs, nil
+}
+
+func (w *WAD) readSidedefs(lumpInfo *lumpInfo) ([]Sidedef, error) {
+	var sidedef Sidedef
+	count := int(lumpInfo.Size) / binary.Size(sidedef)
+	sidedefs := make([]Sidedef, count, count)
+	if err := w.readRecords(lumpInfo, sidedefs); err != nil {
+		return nil, err
+	}
+	return sidedefs, nil
+}
+
+func (w *WAD) readVertexes(lumpInfo *lumpInfo) ([]Vertex, error) {
+	var vertex Vertex
+	count := int(lumpInfo.Size) / binary.Size(vertex)
+	vertexes := make([]Vertex, count, count)
+	if err := w.readRecords(lumpInfo, vertexes); err != nil {
+		return nil, err
+	}
+	return vertexes, nil
+}
+
+func (w *WAD) readSegs(lumpInfo *lumpInfo) ([]Seg, error) {
+	var seg Seg
+	count := int(lumpInfo.Size) / binary.Size(seg)
+	segs := make([]Seg, count, count)
+	if err := w.readRecords(lumpInfo, segs); err != nil {
+		return nil, err
+	}
+	return segs, nil
+}
+
+func (w *WAD) readSSectors(lumpInfo *lumpInfo) ([]SSector, error) {
+	var ssector SSector
+	count := int(lumpInfo.Size) / binary.Size(ssector)
+	ssectors := make([]SSector, count, count)
+	if err := w.readRecords(lumpInfo, ssectors); err != nil {
+		return nil, err
+	}
+	return ssectors, nil
+}
+
+func (w *WAD) readNodes(lumpInfo *lumpInfo) ([]Node, error) {
+	var node Node
+	count := int(lumpInfo.Size) / binary.Size(node)
+	nodes := make([]Node, count, count)
+	if err := w.readRecords(lumpInfo, nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func (w *WAD) readSectors(lumpInfo *lumpInfo) ([]Sector, error) {
+	var sector Sector
+	count := int(lumpInfo.Size) / binary.Size(sector)
+	sectors := make([]Sector, count, count)
+	if err := w.readRecords(lumpInfo, sectors); err != nil {
+		return nil, err
+	}
+	return sectors, nil
+}
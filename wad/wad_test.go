@@ -0,0 +1,394 @@
+package wad_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/penberg/godoom/internal/wadtest"
+	"github.com/penberg/godoom/wad"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBamsToRadians(t *testing.T) {
+	tests := []struct {
+		bams int16
+		want float64
+	}{
+		{0, 0},
+		{0x4000, math.Pi / 2},
+		{-0x8000, math.Pi},
+		{-0x4000, 3 * math.Pi / 2},
+		{-1, 2 * math.Pi * 65535 / 65536},
+	}
+	for _, test := range tests {
+		if got := wad.BamsToRadians(test.bams); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("BamsToRadians(%#x) = %f, want %f", test.bams, got, test.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(level *wad.Level)
+		want    string
+	}{
+		{"valid", func(level *wad.Level) {}, ""},
+		{"sidedef sector", func(level *wad.Level) { level.Sidedefs[0].SectorRef = 1 }, "sidedef 0: sector 1 out of range"},
+		{"linedef vertex", func(level *wad.Level) { level.Linedefs[2].VertexEnd = 7 }, "linedef 2: end vertex 7 out of range"},
+		{"linedef sidedef", func(level *wad.Level) { level.Linedefs[1].SidedefLeft = 3 }, "linedef 1: left sidedef 3 out of range"},
+		{"seg linedef", func(level *wad.Level) { level.Segs[4].LineNum = -2 }, "seg 4: linedef -2 out of range"},
+		{"subsector segs", func(level *wad.Level) { level.SSectors[1].Numsegs = 5 }, "subsector 1: segs 3+5 out of range"},
+		{"node subsector", func(level *wad.Level) { level.Nodes[0].Child[1] = wadtest.SubsectorChild(2) }, "node 0: child 1 subsector 2 out of range"},
+		{"node cycle", func(level *wad.Level) { level.Nodes[0].Child[0] = 0 }, "node 0: child 0 node 0 is not before it"},
+		{"node out of range", func(level *wad.Level) { level.Nodes[0].Child[1] = 5 }, "node 0: child 1 node 5 is not before it"},
+	}
+	for _, test := range tests {
+		level := wadtest.Level()
+		test.corrupt(level)
+		err := level.Validate()
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestValidateLimitsViolations(t *testing.T) {
+	level := wadtest.Level()
+	for i := range level.Segs {
+		level.Segs[i].LineNum = 100
+	}
+	err := level.Validate()
+	if err == nil {
+		t.Fatal("no error for corrupt segs")
+	}
+	want := fmt.Sprintf("(and %d more)", len(level.Segs)-wad.MaxValidationErrors)
+	if !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("got error %q, want it to end with %q", err, want)
+	}
+}
+
+func TestReadWADErrors(t *testing.T) {
+	valid := func() []byte {
+		return wadtest.BuildWAD("IWAD", wadtest.IWADLumps())
+	}
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		want    string
+	}{
+		{"truncated header", func(data []byte) []byte {
+			return data[:5]
+		}, "header: got 5 bytes, want 12"},
+		{"negative lump count", func(data []byte) []byte {
+			copy(data[4:], wadtest.EncodeLE(int32(-1)))
+			return data
+		}, "bad lump count -1"},
+		{"huge lump count", func(data []byte) []byte {
+			copy(data[4:], wadtest.EncodeLE(int32(1<<30)))
+			return data
+		}, "lump directory: 1073741824 lumps at offset"},
+		{"directory past the end", func(data []byte) []byte {
+			return data[:len(data)-1]
+		}, "do not fit in"},
+		{"directory offset past the end", func(data []byte) []byte {
+			copy(data[8:], wadtest.EncodeLE(int32(len(data)+16)))
+			return data
+		}, "do not fit in"},
+		{"lump past the end", func(data []byte) []byte {
+			wadtest.SetDirectoryEntry(data, 1, 12+wad.NumPalettes*256*3, int32(len(data)))
+			return data
+		}, "lump PNAMES: got"},
+		{"truncated palette", func(data []byte) []byte {
+			wadtest.SetDirectoryEntry(data, 0, 12, 768)
+			return data
+		}, "lump PLAYPAL: got 768 bytes, want 10752"},
+		{"truncated patch names", func(data []byte) []byte {
+			// PNAMES declares more patches than it holds.
+			copy(data[12+wad.NumPalettes*256*3:], wadtest.EncodeLE(uint32(2)))
+			return data
+		}, "lump PNAMES: got 4 bytes, want 20"},
+		{"missing lump", func(data []byte) []byte {
+			w := wadtest.IWADLumps()
+			return wadtest.BuildWAD("IWAD", w[1:])
+		}, "lump PLAYPAL not found"},
+	}
+	for _, test := range tests {
+		_, err := wadtest.ReadWAD(test.corrupt(valid()))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+	if _, err := wadtest.ReadWAD(valid()); err != nil {
+		t.Errorf("valid WAD: unexpected error: %s", err)
+	}
+}
+
+func TestReadTextureErrors(t *testing.T) {
+	// texture builds a TEXTURE1 lump with one texture that declares
+	// numPatches patches and holds the given patches.
+	texture := func(numPatches int16, patches ...wad.Patch) []byte {
+		header := wad.TextureHeader{TexName: wad.String8{'W', 'A', 'L', 'L'}, Width: 64, Height: 128, NumPatches: numPatches}
+		return wadtest.EncodeLE(uint32(1), int32(8), header, patches)
+	}
+	tests := []struct {
+		name     string
+		texture1 []byte
+		want     string
+	}{
+		{"valid", texture(1, wad.Patch{PNameNumber: 0}), ""},
+		{"negative patch count", texture(-1), "texture WALL: bad patch count -1"},
+		{"more patches than the lump holds", texture(3, wad.Patch{PNameNumber: 0}), "texture WALL: 3 patches: lump TEXTURE1: got 40 bytes, want 60"},
+		{"patch number out of range", texture(2, wad.Patch{PNameNumber: 0}, wad.Patch{PNameNumber: 1}), "texture WALL: patch 1: patch number 1 out of range (1 in PNAMES)"},
+		{"negative patch number", texture(1, wad.Patch{PNameNumber: -1}), "texture WALL: patch 0: patch number -1 out of range (1 in PNAMES)"},
+		{"texture past the end", wadtest.EncodeLE(uint32(1), int32(100)), "lump TEXTURE1: got 8 bytes, want 122"},
+		{"more textures than the lump holds", wadtest.EncodeLE(uint32(2), int32(8)), "lump TEXTURE1: got 8 bytes, want 12"},
+	}
+	for _, test := range tests {
+		lumps := wadtest.IWADLumps()
+		lumps[1].Data = wadtest.EncodeLE(uint32(1), wad.String8{'W', 'A', 'L', 'L', 'P', 'A', 'T'})
+		lumps[2].Data = test.texture1
+		_, err := wadtest.ReadWAD(wadtest.BuildWAD("IWAD", lumps))
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReadLevelTruncatedLump(t *testing.T) {
+	level := wadtest.Level()
+	lumps := append(wadtest.IWADLumps(), wadtest.LevelLumps("MAP01", level)...)
+	data := wadtest.BuildWAD("IWAD", lumps)
+	w, err := wadtest.ReadWAD(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.ReadLevel("MAP01"); err != nil {
+		t.Fatalf("valid level: unexpected error: %s", err)
+	}
+	// Make the VERTEXES lump run past the end of the file.
+	vertexes := len(wadtest.IWADLumps()) + 4
+	offset := int32(12)
+	for _, lump := range lumps[:vertexes] {
+		offset += int32(len(lump.Data))
+	}
+	wadtest.SetDirectoryEntry(data, vertexes, offset, int32(4*len(data)))
+	if w, err = wadtest.ReadWAD(data); err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.ReadLevel("MAP01")
+	want := fmt.Sprintf("lump VERTEXES: got %d bytes, want %d", len(data)-int(offset), 4*len(data))
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestReadLevelWithoutNodes(t *testing.T) {
+	textmap := `namespace = "doom";
+vertex { x = 0.0; y = 0.0; }
+vertex { x = 0.0; y = 64.0; }
+vertex { x = 64.0; y = 0.0; }
+sidedef { sector = 0; texturemiddle = "WALL"; }
+linedef { v1 = 0; v2 = 1; sidefront = 0; }
+linedef { v1 = 1; v2 = 2; sidefront = 0; }
+linedef { v1 = 2; v2 = 0; sidefront = 0; }
+sector { heightfloor = 0; heightceiling = 128; texturefloor = "FLOOR"; textureceiling = "CEIL"; }
+thing { x = 16.0; y = 16.0; type = 1; }
+`
+	udmf := append(wadtest.IWADLumps(), wadtest.Lump{Name: "MAP01", Data: nil}, wadtest.Lump{Name: "TEXTMAP", Data: []byte(textmap)}, wadtest.Lump{Name: "ENDMAP", Data: nil})
+	level := wadtest.Level()
+	level.Segs = nil
+	level.SSectors = nil
+	level.Nodes = nil
+	binary := append(wadtest.IWADLumps(), wadtest.LevelLumps("MAP01", level)...)
+	tests := []struct {
+		name     string
+		lumps    []wadtest.Lump
+		linedefs int
+		things   int
+		sectorAt wad.Point
+	}{
+		{"UDMF", udmf, 3, 1, wad.Point{X: 16, Y: 16}},
+		{"binary", binary, 6, 1, wad.Point{X: 64, Y: 64}},
+	}
+	for _, test := range tests {
+		w := wadtest.MustReadIWAD(t, test.lumps)
+		level, err := w.ReadLevel("MAP01")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if len(level.Linedefs) != test.linedefs || len(level.Things) != test.things || len(level.SSectors) != 0 {
+			t.Errorf("%s: got %d linedefs, %d things, and %d subsectors, want %d, %d, and 0", test.name, len(level.Linedefs), len(level.Things), len(level.SSectors), test.linedefs, test.things)
+		}
+		// Without nodes, sectors are found by the nearest linedef.
+		if _, id := level.SectorAt(test.sectorAt.X, test.sectorAt.Y); id != 0 {
+			t.Errorf("%s: SectorAt(%v) = %d, want 0", test.name, test.sectorAt, id)
+		}
+	}
+}
+
+func TestReadHexenThings(t *testing.T) {
+	level := wadtest.Level()
+	level.Sectors[0].FloorHeight = 16
+	level.Sectors[0].CeilingHeight = 144
+	// Thing IDs, specials, and arguments are not kept, but must not shift
+	// the fields around them.
+	things := wadtest.EncodeLE(
+		int16(7), int16(64), int16(64), int16(0), int16(90), int16(1), int16(7), uint8(80), [5]uint8{1, 2, 3, 4, 5},
+		int16(0), int16(192), int16(64), int16(24), int16(180), int16(3004), int16(4), uint8(0), [5]uint8{},
+	)
+	levelLumps := wadtest.LevelLumps("MAP01", level)
+	levelLumps[1].Data = things
+	behavior := wadtest.Lump{Name: "BEHAVIOR", Data: []byte("ACS\x00")}
+	tests := []struct {
+		name  string
+		lumps []wadtest.Lump
+	}{
+		{"all map lumps", append(append([]wadtest.Lump{}, levelLumps...), behavior)},
+		{"without REJECT and BLOCKMAP", append(append([]wadtest.Lump{}, levelLumps[:9]...), behavior)},
+	}
+	want := []wad.Thing{
+		{XPosition: 64, YPosition: 64, Angle: 90, Type: 1, Options: 7, HasZ: true},
+		{XPosition: 192, YPosition: 64, Z: 24, HasZ: true, Angle: 180, Type: 3004, Options: 4},
+	}
+	for _, test := range tests {
+		w := wadtest.MustReadIWAD(t, append(wadtest.IWADLumps(), test.lumps...))
+		level, err := w.ReadLevel("MAP01")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(level.Things, want) {
+			t.Errorf("%s: things = %+v, want %+v", test.name, level.Things, want)
+			continue
+		}
+		if z := level.ThingZ(&level.Things[0]); z != 16 {
+			t.Errorf("%s: thing 0 at height %d, want the floor at 16", test.name, z)
+		}
+		if z := level.ThingZ(&level.Things[1]); z != 40 {
+			t.Errorf("%s: thing 1 at height %d, want 24 above the floor at 16", test.name, z)
+		}
+	}
+
+	// Things of Doom-format maps stand on the floor.
+	w := wadtest.MustReadIWAD(t, append(wadtest.IWADLumps(), wadtest.LevelLumps("MAP01", level)...))
+	doomLevel, err := w.ReadLevel("MAP01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thing := doomLevel.Things[0]; thing.HasZ || doomLevel.ThingZ(&thing) != 16 {
+		t.Errorf("Doom-format thing %+v at height %d, want the floor at 16", thing, doomLevel.ThingZ(&thing))
+	}
+}
+
+func TestAddPWAD(t *testing.T) {
+	// picture encodes a one pixel picture.
+	picture := func(pixel byte) []byte {
+		return wadtest.EncodeLE(int16(1), int16(1), int16(0), int16(0), int32(12), []byte{0, 1, 0, pixel, 0, 0xff})
+	}
+	iwadLumps := append(wadtest.IWADLumps(), wadtest.Lump{Name: "TITLEPIC", Data: picture(1)})
+	iwadLumps = append(iwadLumps, wadtest.LevelLumps("MAP01", wadtest.Level())...)
+	w := wadtest.MustReadIWAD(t, iwadLumps)
+
+	replaced := wadtest.Level()
+	replaced.Sectors[0].Lightlevel = 96
+	pwadLumps := append([]wadtest.Lump{{Name: "TITLEPIC", Data: picture(2)}}, wadtest.LevelLumps("MAP01", replaced)...)
+	// MAP02 is the last lump of the directory and has no REJECT or
+	// BLOCKMAP.
+	pwadLumps = append(pwadLumps, wadtest.LevelLumps("MAP02", wadtest.Level())[:9]...)
+	pwad := wadtest.BuildWAD("PWAD", pwadLumps)
+	if err := w.AddPWADReader(bytes.NewReader(pwad), int64(len(pwad))); err != nil {
+		t.Fatal(err)
+	}
+
+	if image, err := w.LoadPicture("TITLEPIC"); err != nil {
+		t.Errorf("TITLEPIC: %s", err)
+	} else if image.Pixels[0] != 2 {
+		t.Errorf("TITLEPIC has pixel %d, want 2 from the PWAD", image.Pixels[0])
+	}
+	if got, want := strings.Join(w.LevelNames(), " "), "MAP01 MAP02"; got != want {
+		t.Errorf("LevelNames() = %q, want %q", got, want)
+	}
+	if level, err := w.ReadLevel("MAP01"); err != nil {
+		t.Errorf("MAP01: %s", err)
+	} else if level.Sectors[0].Lightlevel != 96 {
+		t.Errorf("MAP01 has light level %d, want 96 from the PWAD", level.Sectors[0].Lightlevel)
+	}
+	if level, err := w.ReadLevel("MAP02"); err != nil {
+		t.Errorf("MAP02: %s", err)
+	} else if len(level.Sectors) != 1 || len(level.Nodes) != 1 {
+		t.Errorf("MAP02 has %d sectors and %d nodes, want 1 and 1", len(level.Sectors), len(level.Nodes))
+	}
+	if _, err := w.ReadLevel("MAP03"); err == nil || err.Error() != "level MAP03 not found" {
+		t.Errorf("MAP03: got error %v, want level MAP03 not found", err)
+	}
+
+	if err := w.AddPWADReader(bytes.NewReader(pwad[:5]), 5); err == nil {
+		t.Errorf("truncated PWAD: no error")
+	}
+}
+
+func TestSpriteNames(t *testing.T) {
+	sprite := wadtest.EncodeLE(int16(1), int16(1), int16(0), int16(0), int32(12), []byte{0, 1, 0, 0, 0, 0xff})
+	iwadLumps := append(wadtest.IWADLumps(),
+		wadtest.Lump{Name: "S_START"},
+		wadtest.Lump{Name: "TROOA1", Data: sprite},
+		wadtest.Lump{Name: "S_END"},
+		wadtest.Lump{Name: "SARGA1", Data: sprite},
+	)
+	w := wadtest.MustReadIWAD(t, iwadLumps)
+	// The sprites of PWADs are not read, even if their markers pair with
+	// those of the IWAD.
+	pwad := wadtest.BuildWAD("PWAD", []wadtest.Lump{
+		{Name: "SS_START"},
+		{Name: "POSSA1", Data: sprite},
+		{Name: "S_END"},
+	})
+	if err := w.AddPWADReader(bytes.NewReader(pwad), int64(len(pwad))); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := w.SpriteNames(); err != nil || strings.Join(names, " ") != "TROOA1" {
+		t.Errorf("got sprites %v and error %v, want TROOA1", names, err)
+	}
+
+	// Doubled markers of the IWAD are found too.
+	iwadLumps[5].Name, iwadLumps[7].Name = "SS_START", "SS_END"
+	w = wadtest.MustReadIWAD(t, iwadLumps)
+	if names, err := w.SpriteNames(); err != nil || strings.Join(names, " ") != "TROOA1" {
+		t.Errorf("doubled markers: got sprites %v and error %v, want TROOA1", names, err)
+	}
+	w = wadtest.MustReadIWAD(t, iwadLumps[:7])
+	if _, err := w.SpriteNames(); err == nil || err.Error() != "S_END not found" {
+		t.Errorf("missing end marker: got error %v, want S_END not found", err)
+	}
+}
+
+func TestLowerCasePatchNames(t *testing.T) {
+	lumps := wadtest.IWADLumps()
+	lumps[1].Data = wadtest.EncodeLE(uint32(1), wadtest.Name8("wallpat"))
+	lumps = append(lumps, wadtest.Lump{Name: "WALLPAT", Data: wadtest.EncodeLE(int16(1), int16(1), int16(0), int16(0), int32(12), []byte{0, 1, 0, 7, 0, 0xff})})
+	w := wadtest.MustReadIWAD(t, lumps)
+	if name, err := w.PatchName(0); err != nil || name != "WALLPAT" {
+		t.Errorf("PatchName(0) = %q, %v, want WALLPAT", name, err)
+	}
+	if image, err := w.LoadImage(0); err != nil {
+		t.Errorf("LoadImage(0): unexpected error: %s", err)
+	} else if image.Pixels[0] != 7 {
+		t.Errorf("LoadImage(0) has pixel %d, want 7", image.Pixels[0])
+	}
+}